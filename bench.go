@@ -0,0 +1,43 @@
+// Benchmark mode (-bench) for the littlelang command
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/benhoyt/littlelang/interpreter"
+)
+
+// Call run n times, where each call runs the program in a fresh
+// interpreter, stopping early if a run fails. Return the time each run
+// took, and the interpreter and exit status of the last run.
+func benchmark(n int, run func() (*interpreter.Interpreter, int)) ([]time.Duration, *interpreter.Interpreter, int) {
+	var times []time.Duration
+	var interp *interpreter.Interpreter
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		var status int
+		interp, status = run()
+		times = append(times, time.Since(start))
+		if status != 0 {
+			return times, interp, status
+		}
+	}
+	return times, interp, 0
+}
+
+// Print the minimum, median, and maximum of the given run times, and
+// return the median
+func showBenchmark(times []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	fmt.Printf("%d runs: min %s, median %s, max %s\n",
+		len(sorted), sorted[0], median, sorted[len(sorted)-1])
+	return median
+}
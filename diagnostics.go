@@ -0,0 +1,155 @@
+// Error reporting for the littlelang command
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Number of source lines to show before the line with the error
+const contextLines = 2
+
+// ANSI escape codes used to colorize diagnostics
+const (
+	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
+	colorRed   = "\033[1;31m"
+	colorBlue  = "\033[1;34m"
+)
+
+// Sources of the files the command has parsed, by filename, so that an
+// error in one file can be shown while running another
+var sourceFiles = make(map[string][]byte)
+
+// Diagnostics are colorized if stderr is a terminal and $NO_COLOR isn't set
+var useColor = isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Return s wrapped in the given color code (if colors are enabled)
+func colorize(s, color string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Show a parser or interpreter error on stderr: the error message, the
+// source lines leading up to the error with the erroring token underlined,
+// and for runtime errors inside functions, the call stack
+func showError(source []byte, err error) {
+	showErrorSkip(source, err, 0)
+}
+
+// Show an error like showError, but leave out the first skip frames (the
+// outermost calls) of the call stack
+func showErrorSkip(source []byte, err error, skip int) {
+	w := os.Stderr
+	var pos tokenizer.Position
+	switch e := err.(type) {
+	case parser.Error:
+		pos = e.Position
+	case interpreter.Error:
+		pos = e.Position()
+	default:
+		fmt.Fprintln(w, colorize(err.Error(), colorRed))
+		return
+	}
+
+	// Split "type error at 1:2: message" into prefix and message
+	message := err.Error()
+	if i := strings.Index(message, ": "); i >= 0 {
+		message = colorize(message[:i+1], colorRed) + colorize(message[i+1:], colorBold)
+	} else {
+		message = colorize(message, colorRed)
+	}
+	fmt.Fprintln(w, message)
+	showErrorSource(w, source, pos)
+
+	if e, ok := err.(interpreter.Error); ok && len(e.Stack()) > skip {
+		fmt.Fprintln(w, "call stack (most recent call last):")
+		for _, frame := range e.Stack()[skip:] {
+			fmt.Fprintf(w, "    %s called at %s\n", frame.Function, frame.Position)
+		}
+	}
+}
+
+// Show the source lines leading up to pos, with the token at pos
+// underlined. If pos has a filename, the lines are from that file rather
+// than source (it may be a library or included file).
+func showErrorSource(w io.Writer, source []byte, pos tokenizer.Position) {
+	if pos.Filename != "" {
+		fileSource, ok := sourceFiles[pos.Filename]
+		if !ok {
+			// Included files are read by the interpreter, so read it again
+			fileSource, ok = readSource(pos.Filename)
+		}
+		if !ok {
+			return
+		}
+		source = fileSource
+	}
+	lines := bytes.Split(source, []byte{'\n'})
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return
+	}
+	gutterWidth := len(fmt.Sprint(pos.Line))
+	first := pos.Line - contextLines
+	if first < 1 {
+		first = 1
+	}
+	for n := first; n <= pos.Line; n++ {
+		gutter := colorize(fmt.Sprintf("%*d |", gutterWidth, n), colorBlue)
+		fmt.Fprintf(w, "%s %s\n", gutter, expandTabs(string(lines[n-1])))
+	}
+
+	// Line up the underline with the error column, allowing for tabs
+	line := []rune(string(lines[pos.Line-1]))
+	column := pos.Column - 1
+	if column > len(line) {
+		column = len(line)
+	}
+	indent := len(expandTabs(string(line[:column])))
+	width := tokenWidth(source, pos)
+	if column+width > len(line) {
+		width = len(line) - column
+	}
+	if width < 1 {
+		width = 1
+	}
+	underline := "^" + strings.Repeat("~", width-1)
+	gutter := colorize(strings.Repeat(" ", gutterWidth)+" |", colorBlue)
+	fmt.Fprintf(w, "%s %s%s\n", gutter, strings.Repeat(" ", indent), colorize(underline, colorRed))
+}
+
+// Return the width in characters of the token starting at pos, or 1 if
+// there's no token there
+func tokenWidth(source []byte, pos tokenizer.Position) int {
+	t := tokenizer.NewTokenizer(source)
+	for {
+		tokPos, tok, _ := t.Next()
+		if tok == tokenizer.EOF || tok == tokenizer.ILLEGAL || tokPos.Line > pos.Line ||
+			(tokPos.Line == pos.Line && tokPos.Column > pos.Column) {
+			return 1
+		}
+		if tokPos.Line == pos.Line && tokPos.Column == pos.Column {
+			return utf8.RuneCountInString(t.Lexeme())
+		}
+	}
+}
+
+func expandTabs(s string) string {
+	return strings.Replace(s, "\t", "    ", -1)
+}
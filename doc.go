@@ -0,0 +1,166 @@
+// "littlelang doc" command: generate documentation for a source file
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benhoyt/littlelang/parser"
+)
+
+// Documentation extracted from a source file
+type fileDoc struct {
+	Name      string // base name of source file
+	Comment   string // comment block at top of file
+	Functions []functionDoc
+}
+
+type functionDoc struct {
+	Signature string // for example "func add(a, b)"
+	Comment   string // comment block directly above the definition
+}
+
+// Run the doc subcommand with the given args and return the exit status
+func docCommand(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	format := flags.String("format", "markdown", "output `format` (markdown or html)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: littlelang doc [-format markdown|html] source_filename\n\n")
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	if *format != "markdown" && *format != "html" {
+		fmt.Fprintf(os.Stderr, "invalid doc format %q (must be markdown or html)\n", *format)
+		return exitUsage
+	}
+	filename := flags.Arg(0)
+	input, prog := parseFile(filename)
+	doc := extractDoc(filepath.Base(filename), input, prog)
+	if *format == "html" {
+		writeDocHTML(os.Stdout, doc)
+	} else {
+		writeDocMarkdown(os.Stdout, doc)
+	}
+	return 0
+}
+
+// Extract the file comment and the top-level function definitions (with
+// their comments) from a parsed program
+func extractDoc(name string, source []byte, prog *parser.Program) fileDoc {
+	lines := strings.Split(string(source), "\n")
+	doc := fileDoc{Name: name}
+
+	// File comment is the first block of comment lines, if it's at the very
+	// top of the file and isn't directly above a function
+	if start, end := commentBlock(lines, 0); start == 0 && end > 0 {
+		if end >= len(lines) || strings.TrimSpace(lines[end]) == "" {
+			doc.Comment = commentText(lines[start:end])
+		}
+	}
+
+	for _, s := range prog.Statements {
+		f, ok := s.(*parser.FunctionDefinition)
+		if !ok {
+			continue
+		}
+		ellipsis := ""
+		if f.Ellipsis {
+			ellipsis = "..."
+		}
+		name := f.Name
+		if f.Struct != "" {
+			name = f.Struct + "." + f.Name
+		}
+		signature := fmt.Sprintf("func %s(%s%s)", name, strings.Join(f.Parameters, ", "), ellipsis)
+
+		// Comment lines directly above the func line
+		line := f.Position().Line - 1
+		start := line
+		for start > 0 && isCommentLine(lines[start-1]) {
+			start--
+		}
+		doc.Functions = append(doc.Functions, functionDoc{signature, commentText(lines[start:line])})
+	}
+	return doc
+}
+
+// Return the bounds of the block of comment lines starting at or after
+// line index i (skipping blank lines)
+func commentBlock(lines []string, i int) (int, int) {
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	start := i
+	for i < len(lines) && isCommentLine(lines[i]) {
+		i++
+	}
+	return start, i
+}
+
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "//")
+}
+
+// Return the text of the given comment lines without the "//" prefixes
+func commentText(lines []string) string {
+	var b bytes.Buffer
+	for _, line := range lines {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "//")
+		line = strings.TrimPrefix(line, " ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func writeDocMarkdown(w io.Writer, doc fileDoc) {
+	fmt.Fprintf(w, "# %s\n", doc.Name)
+	if doc.Comment != "" {
+		fmt.Fprintf(w, "\n%s\n", doc.Comment)
+	}
+	if len(doc.Functions) > 0 {
+		fmt.Fprintf(w, "\n## Functions\n")
+	}
+	for _, f := range doc.Functions {
+		fmt.Fprintf(w, "\n### `%s`\n", f.Signature)
+		if f.Comment != "" {
+			fmt.Fprintf(w, "\n%s\n", f.Comment)
+		}
+	}
+}
+
+func writeDocHTML(w io.Writer, doc fileDoc) {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n",
+		html.EscapeString(doc.Name))
+	fmt.Fprintf(w, "<h1>%s</h1>\n", html.EscapeString(doc.Name))
+	if doc.Comment != "" {
+		writeParagraphsHTML(w, doc.Comment)
+	}
+	if len(doc.Functions) > 0 {
+		fmt.Fprintf(w, "<h2>Functions</h2>\n")
+	}
+	for _, f := range doc.Functions {
+		fmt.Fprintf(w, "<h3><code>%s</code></h3>\n", html.EscapeString(f.Signature))
+		if f.Comment != "" {
+			writeParagraphsHTML(w, f.Comment)
+		}
+	}
+	fmt.Fprintf(w, "</body>\n</html>\n")
+}
+
+// Write text as HTML paragraphs, which are separated by blank lines
+func writeParagraphsHTML(w io.Writer, text string) {
+	for _, para := range strings.Split(text, "\n\n") {
+		fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(strings.TrimSpace(para)))
+	}
+}
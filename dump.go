@@ -0,0 +1,134 @@
+// Debugging dumps of the tokens and AST for the littlelang command
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/tokenizer"
+)
+
+// optionalValue is a flag that may be given on its own ("-ast") or with a
+// value ("-ast=json"), in which case set is true and value is the value (or
+// the default if no value was given).
+type optionalValue struct {
+	set   bool
+	value string
+}
+
+func (v *optionalValue) String() string {
+	return v.value
+}
+
+func (v *optionalValue) Set(s string) error {
+	v.set = true
+	if s != "true" {
+		v.value = s
+	}
+	return nil
+}
+
+func (v *optionalValue) IsBoolFlag() bool {
+	return true
+}
+
+func optionalFlag(name, value, usage string) *optionalValue {
+	v := &optionalValue{value: value}
+	flag.Var(v, name, usage)
+	return v
+}
+
+// Print input's token stream to w, one token per line with its position
+// and value. Return false if the tokenizer hit an ILLEGAL token.
+func dumpTokens(w io.Writer, input []byte) bool {
+	t := tokenizer.NewTokenizer(input)
+	for {
+		pos, tok, val := t.Next()
+		if tok == tokenizer.EOF {
+			return true
+		}
+		fmt.Fprintf(w, "%d:%d %s %q\n", pos.Line, pos.Column, tok, val)
+		if tok == tokenizer.ILLEGAL {
+			return false
+		}
+	}
+}
+
+// Print program's AST to w in the given format ("text" or "json")
+func dumpAST(w io.Writer, prog *parser.Program, format string) error {
+	switch format {
+	case "text":
+		fmt.Fprintln(w, prog)
+	case "json":
+		obj := nodeToJSON(reflect.ValueOf(prog)).(map[string]interface{})
+		if prog.Comments == nil && prog.Attached == nil {
+			// Comments weren't parsed, so leave out the fields for them
+			delete(obj, "Comments")
+			delete(obj, "Attached")
+		}
+		b, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", b)
+	default:
+		return fmt.Errorf("invalid AST format %q (must be text or json)", format)
+	}
+	return nil
+}
+
+var (
+	tokenType    = reflect.TypeOf(tokenizer.ILLEGAL)
+	positionType = reflect.TypeOf((*interface{ Position() tokenizer.Position })(nil)).Elem()
+)
+
+// Convert an AST node to a JSON-friendly value. Nodes become objects with
+// a "Node" key for the node type, "Line" and "Column" keys for the node's
+// position, and a key for each exported field of the node.
+func nodeToJSON(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return nodeToJSON(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		obj := nodeToJSON(v.Elem()).(map[string]interface{})
+		obj["Node"] = v.Elem().Type().Name()
+		if v.Type().Implements(positionType) {
+			pos := v.Interface().(interface{ Position() tokenizer.Position }).Position()
+			obj["Line"] = pos.Line
+			obj["Column"] = pos.Column
+		}
+		return obj
+	case reflect.Struct:
+		obj := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			obj[field.Name] = nodeToJSON(v.Field(i))
+		}
+		return obj
+	case reflect.Slice:
+		values := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values[i] = nodeToJSON(v.Index(i))
+		}
+		return values
+	default:
+		if v.Type() == tokenType {
+			return v.Interface().(tokenizer.Token).String()
+		}
+		return v.Interface()
+	}
+}
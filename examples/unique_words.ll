@@ -16,11 +16,8 @@ for key in counts {
 sort(pairs, func(x) {
     return -x[1]
 })
-max = len(pairs)
-if max > 25 {
-    max = 25
-}
-for i in max {
+num = min(len(pairs), 25)
+for i in num {
     pair = pairs[i]
     print(pair[0], pair[1])
 }
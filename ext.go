@@ -0,0 +1,39 @@
+// Loading of builtin function extensions from Go plugins
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+
+	"github.com/benhoyt/littlelang/interpreter"
+)
+
+// Load the Go plugin at filename and add the builtins it provides to
+// builtins, or exit with an error message. An extension is a Go package
+// built with "go build -buildmode=plugin" that defines an exported
+// variable:
+//
+//	var Builtins = map[string]interpreter.BuiltinFunc{...}
+func loadExtension(filename string, builtins map[string]interpreter.BuiltinFunc) {
+	p, err := plugin.Open(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading extension %q: %v\n", filename, err)
+		os.Exit(exitError)
+	}
+	symbol, err := p.Lookup("Builtins")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading extension %q: %v\n", filename, err)
+		os.Exit(exitError)
+	}
+	extBuiltins, ok := symbol.(*map[string]interpreter.BuiltinFunc)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error loading extension %q: Builtins has type %T, not map[string]interpreter.BuiltinFunc\n",
+			filename, symbol)
+		os.Exit(exitError)
+	}
+	for name, f := range *extBuiltins {
+		builtins[name] = f
+	}
+}
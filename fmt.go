@@ -0,0 +1,205 @@
+// "littlelang fmt" command: format source files in the canonical style
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benhoyt/littlelang/format"
+)
+
+// Run the fmt subcommand with the given args and return the exit status
+func fmtCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	showDiff := flags.Bool("d", false, "print a diff of the changes instead of rewriting the files")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: littlelang fmt [-d] source_filename...\n\n")
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil || flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading %q\n", filename)
+			status = exitError
+			continue
+		}
+		output, err := format.Source(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n", filename)
+			showError(input, err)
+			status = exitParse
+			continue
+		}
+		if bytes.Equal(input, output) {
+			continue
+		}
+		if *showDiff {
+			writeDiff(os.Stdout, filename, input, output)
+			continue
+		}
+		// Keep the file's permissions (for example, for executable scripts)
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = ioutil.WriteFile(filename, output, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %q: %v\n", filename, err)
+			status = exitError
+		}
+	}
+	return status
+}
+
+// Number of unchanged lines shown around each change in a diff
+const diffContext = 3
+
+// A line of a diff: kind is ' ' for an unchanged line, '-' for a removed
+// line, or '+' for an added one
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// Write a unified diff of the changes from a to b to w
+func writeDiff(w io.Writer, filename string, a, b []byte) {
+	lines := diffLines(splitLines(a), splitLines(b))
+	if filepath.IsAbs(filename) {
+		// An "a/" prefix would give "a//tmp/x.ll", which patch -p1
+		// doesn't handle, so leave the prefixes off
+		fmt.Fprintf(w, "--- %s\n+++ %s\n", filename, filename)
+	} else {
+		name := filepath.ToSlash(filepath.Clean(filename))
+		fmt.Fprintf(w, "--- a/%s\n+++ b/%s\n", name, name)
+	}
+
+	// Number of lines of a and b before each diff line
+	aBefore := make([]int, len(lines)+1)
+	bBefore := make([]int, len(lines)+1)
+	for i, line := range lines {
+		aBefore[i+1], bBefore[i+1] = aBefore[i], bBefore[i]
+		if line.kind != '+' {
+			aBefore[i+1]++
+		}
+		if line.kind != '-' {
+			bBefore[i+1]++
+		}
+	}
+
+	i := 0
+	for {
+		for i < len(lines) && lines[i].kind == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			return
+		}
+		// A hunk includes later changes that are close enough for their
+		// context lines to overlap
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].kind == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				end += diffContext
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = next
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(aBefore[start], aBefore[end]), hunkRange(bBefore[start], bBefore[end]))
+		for _, line := range lines[start:end] {
+			fmt.Fprintf(w, "%c%s\n", line.kind, line.text)
+		}
+		i = end
+	}
+}
+
+// Return the "start,count" range of a hunk header, given the number of
+// lines before and at the end of the hunk
+func hunkRange(before, end int) string {
+	if end == before {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, end-before)
+}
+
+func splitLines(b []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// Return the diff from a to b, using the longest common subsequence of the
+// lines that aren't in the common prefix or suffix
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := prefix
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return append(lines, suffix...)
+}
@@ -0,0 +1,713 @@
+// Package format formats littlelang source code in a canonical style.
+//
+// Call Source(source) to get the formatted source. The output is printed
+// from the parsed program, so spacing and indentation are normalized:
+// statements go on their own lines, blocks are indented four spaces, and
+// the items of lists, maps, and calls are separated by ", ". Comments,
+// blank lines between statements (at most one), parentheses, and line
+// breaks within lists, maps, calls, and after binary operators are kept
+// from the source.
+//
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/benhoyt/littlelang/parser"
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+type token struct {
+	pos    Position
+	token  Token
+	offset int
+	lexeme string
+}
+
+type comment struct {
+	pos     Position
+	text    string
+	ownLine bool // true if there's no code before the comment on its line
+}
+
+// Range of tokens an expression spans in the source, including any
+// parentheses around it
+type extent struct {
+	first  int
+	last   int
+	parens bool
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	lines    []string
+	tokens   []token
+	index    map[Position]int // index in tokens of the token at each position
+	match    map[int]int      // index of closing bracket for each opening one
+	extents  map[parser.Expression]extent
+	comments []comment // comments not yet written, in source order
+	indent   int
+
+	blockStart     bool // at start of block, so no blank line is needed
+	needNewline    bool // current line ends with a comment
+	continueIndent int  // extra indents for a line continued after an operator
+}
+
+// Source formats the given littlelang program source and returns the
+// result. If the source has a syntax error, it returns nil and a
+// parser.Error value.
+func Source(source []byte) ([]byte, error) {
+	prog, err := parser.ParseProgram(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &printer{
+		lines:   strings.Split(string(source), "\n"),
+		index:   make(map[Position]int),
+		match:   make(map[int]int),
+		extents: make(map[parser.Expression]extent),
+
+		continueIndent: 1,
+	}
+	if bytes.HasPrefix(source, []byte("#!")) {
+		// Keep "#!" line (which the tokenizer skips) as is
+		p.buf.WriteString(strings.TrimRight(p.lines[0], " \t\r"))
+	}
+	p.tokenize(source)
+	p.statements(prog.Statements, Position{Line: len(p.lines) + 1})
+	if p.buf.Len() == 0 {
+		return []byte{}, nil
+	}
+	p.buf.WriteByte('\n')
+	return p.buf.Bytes(), nil
+}
+
+// Find the tokens in source, the comments between them, and the matching
+// pairs of brackets
+func (p *printer) tokenize(source []byte) {
+	t := NewTokenizer(source)
+	t.EmitComments()
+	var opens []int
+	prevLine := 0 // line the previous token ends on
+	for {
+		pos, tok, val := t.Next()
+		if tok == EOF {
+			return
+		}
+		if tok == COMMENT {
+			p.comments = append(p.comments, comment{
+				pos:     pos,
+				text:    strings.TrimRight("//"+val, " \t\r"),
+				ownLine: pos.Line > prevLine,
+			})
+			continue
+		}
+		switch tok {
+		case LPAREN, LBRACKET, LBRACE, OPTLBRACKET:
+			opens = append(opens, len(p.tokens))
+		case RPAREN, RBRACKET, RBRACE:
+			p.match[opens[len(opens)-1]] = len(p.tokens)
+			opens = opens[:len(opens)-1]
+		}
+		p.index[pos] = len(p.tokens)
+		p.tokens = append(p.tokens, token{pos, tok, t.Offset(), t.Lexeme()})
+		prevLine = pos.Line + strings.Count(t.Lexeme(), "\n")
+	}
+}
+
+func (p *printer) write(s string) {
+	if p.needNewline {
+		p.newline()
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat("    ", p.indent))
+	p.needNewline = false
+}
+
+// Report whether the given source line has a blank line before it
+func (p *printer) blankBefore(line int) bool {
+	return line >= 2 && line-2 < len(p.lines) && strings.TrimSpace(p.lines[line-2]) == ""
+}
+
+// Start a new line for a statement or comment that's on the given line in
+// the source, keeping a blank line before it if the source has one
+func (p *printer) startLine(line int) {
+	if p.buf.Len() > 0 {
+		if !p.blockStart && p.blankBefore(line) {
+			p.buf.WriteByte('\n')
+		}
+		p.newline()
+	}
+	p.blockStart = false
+}
+
+func (p *printer) writeComment(c comment) {
+	if c.ownLine || p.needNewline {
+		p.startLine(c.pos.Line)
+	} else {
+		p.buf.WriteByte(' ')
+	}
+	p.buf.WriteString(c.text)
+	p.needNewline = true
+}
+
+// Write the comments that come before pos in the source
+func (p *printer) flushComments(pos Position) {
+	for p.commentsBefore(pos) {
+		p.writeComment(p.comments[0])
+		p.comments = p.comments[1:]
+	}
+}
+
+// Report whether there are comments to write before pos
+func (p *printer) commentsBefore(pos Position) bool {
+	return len(p.comments) > 0 && before(p.comments[0].pos, pos)
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// Return the position of the bracket that closes the one at token index i
+func (p *printer) closing(i int) Position {
+	return p.tokens[p.match[i]].pos
+}
+
+// Write statements one per line, followed by the comments before end
+func (p *printer) statements(block parser.Block, end Position) {
+	for _, s := range block {
+		pos := p.statementStart(s)
+		p.flushComments(pos)
+		p.startLine(pos.Line)
+		p.statement(s)
+	}
+	p.flushComments(end)
+}
+
+// Write a block in braces, where open is the token index of its {
+func (p *printer) block(block parser.Block, open int) {
+	end := p.closing(open)
+	p.write("{")
+	if len(block) == 0 && !p.commentsBefore(end) {
+		p.write("}")
+		return
+	}
+	defer p.setContinueIndent(1)()
+	p.indent++
+	p.blockStart = true
+	p.statements(block, end)
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+// Write a function body, where open is the token index of its {. A body
+// with a single simple statement stays on one line if it was on one line
+// in the source, as in "func(a, b) { return a + b }".
+func (p *printer) functionBody(block parser.Block, open int) {
+	end := p.closing(open)
+	if len(block) == 1 && end.Line == p.tokens[open].pos.Line && !p.commentsBefore(end) {
+		switch block[0].(type) {
+		case *parser.Assign, *parser.OuterAssign, *parser.Return, *parser.Yield, *parser.ExpressionStatement:
+			p.write("{ ")
+			p.statement(block[0])
+			p.write(" }")
+			return
+		}
+	}
+	p.block(block, open)
+}
+
+func (p *printer) statement(s parser.Statement) {
+	i := p.index[s.Position()]
+	switch s := s.(type) {
+	case *parser.Assign:
+		p.expr(s.Target, 0)
+		p.write(" = ")
+		p.expr(s.Value, 0)
+	case *parser.OuterAssign:
+		p.write("outer " + s.Name + " = ")
+		p.expr(s.Value, 0)
+	case *parser.If:
+		p.write("if ")
+		p.header(s.Condition)
+		p.write(" ")
+		open := p.braceAfter(s.Condition)
+		p.block(s.Body, open)
+		close := p.match[open]
+		if close+1 == len(p.tokens) || p.tokens[close+1].token != ELSE {
+			break
+		}
+		p.write(" else ")
+		if p.tokens[close+2].token == IF {
+			// Keep "else if" form, rather than "else { if ... }"
+			p.statement(s.Else[0])
+			break
+		}
+		p.block(s.Else, close+2)
+	case *parser.While:
+		p.write("while ")
+		p.header(s.Condition)
+		p.write(" ")
+		p.block(s.Body, p.braceAfter(s.Condition))
+	case *parser.For:
+		p.write("for " + s.Name)
+		if s.ValueName != "" {
+			p.write(", " + s.ValueName)
+		}
+		p.write(" in ")
+		p.header(s.Iterable)
+		p.write(" ")
+		p.block(s.Body, p.braceAfter(s.Iterable))
+	case *parser.Try:
+		p.write("try ")
+		p.block(s.Body, i+1)
+		p.write(" catch " + s.ErrorName + " ")
+		p.block(s.Catch, p.match[i+1]+3)
+	case *parser.Match:
+		p.write("match ")
+		p.header(s.Value)
+		p.write(" {")
+		end := p.closing(p.braceAfter(s.Value))
+		p.indent++
+		p.blockStart = true
+		for _, c := range s.Cases {
+			pos := p.expressionStart(c.Pattern)
+			p.flushComments(pos)
+			p.startLine(pos.Line)
+			p.expr(c.Pattern, 0)
+			p.write(" ")
+			p.block(c.Body, p.braceAfter(c.Pattern))
+		}
+		p.flushComments(end)
+		p.indent--
+		p.newline()
+		p.write("}")
+	case *parser.Return:
+		p.write("return ")
+		p.expr(s.Result, 0)
+	case *parser.Yield:
+		p.write("yield ")
+		p.expr(s.Value, 0)
+	case *parser.ExpressionStatement:
+		// A statement starting with "func" is a function definition or
+		// expression, so a function that's called needs parentheses (unless
+		// the source already has them)
+		_, isFunc := s.Expression.(*parser.FunctionExpression)
+		_, startsFunc := expressionLeft(s.Expression).(*parser.FunctionExpression)
+		if startsFunc && !isFunc && p.tokens[p.extent(s.Expression).first].token != LPAREN {
+			p.write("(")
+			p.expr(s.Expression, 0)
+			p.write(")")
+		} else {
+			p.expr(s.Expression, 0)
+		}
+	case *parser.FunctionDefinition:
+		name := s.Name
+		if s.Struct != "" {
+			name = s.Struct + "." + s.Name
+		}
+		p.write("func " + name + params(s.Parameters, s.Ellipsis) + " ")
+		lparen := i + 2
+		if s.Struct != "" {
+			lparen = i + 4
+		}
+		p.functionBody(s.Body, p.match[lparen]+1)
+	case *parser.StructDefinition:
+		p.write("struct " + s.Name + " {")
+		if len(s.Fields) > 0 && p.tokens[i+3].pos.Line > s.Position().Line {
+			// Fields on their own lines
+			p.indent++
+			p.blockStart = true
+			for j, field := range s.Fields {
+				pos := p.tokens[i+3+j].pos
+				p.flushComments(pos)
+				p.startLine(pos.Line)
+				p.write(field)
+			}
+			p.flushComments(p.closing(i + 2))
+			p.indent--
+			p.newline()
+		} else if len(s.Fields) > 0 {
+			p.write(" " + strings.Join(s.Fields, " ") + " ")
+		}
+		p.write("}")
+	}
+}
+
+// Write the expression in an if, while, for, or match statement's header,
+// indenting continued lines twice so they stand out from the block
+func (p *printer) header(e parser.Expression) {
+	defer p.setContinueIndent(2)()
+	p.expr(e, 0)
+}
+
+// Set the number of extra indents for lines continued after a binary
+// operator, and return a function that restores the previous setting
+func (p *printer) setContinueIndent(n int) func() {
+	old := p.continueIndent
+	p.continueIndent = n
+	return func() { p.continueIndent = old }
+}
+
+func params(names []string, ellipsis bool) string {
+	s := "(" + strings.Join(names, ", ")
+	if ellipsis {
+		s += "..."
+	}
+	return s + ")"
+}
+
+// Return the position of the start of a statement's source
+func (p *printer) statementStart(s parser.Statement) Position {
+	switch s := s.(type) {
+	case *parser.Assign:
+		return p.expressionStart(s.Target)
+	case *parser.ExpressionStatement:
+		return p.expressionStart(s.Expression)
+	}
+	return s.Position()
+}
+
+// Return the position of the start of an expression's source
+func (p *printer) expressionStart(e parser.Expression) Position {
+	return p.tokens[p.extent(e).first].pos
+}
+
+// Return the token index of the { that follows expression e
+func (p *printer) braceAfter(e parser.Expression) int {
+	return p.extent(e).last + 1
+}
+
+// Return the leftmost subexpression of e, which its source starts with
+// (not counting parentheses)
+func expressionLeft(e parser.Expression) parser.Expression {
+	switch e := e.(type) {
+	case *parser.Binary:
+		return expressionLeft(e.Left)
+	case *parser.Call:
+		return expressionLeft(e.Function)
+	case *parser.Subscript:
+		return expressionLeft(e.Container)
+	case *parser.Slice:
+		return expressionLeft(e.Container)
+	case *parser.Spread:
+		return expressionLeft(e.Value)
+	default:
+		return e
+	}
+}
+
+// Return the range of tokens expression e spans, including any parentheses
+// around it
+func (p *printer) extent(e parser.Expression) extent {
+	if x, ok := p.extents[e]; ok {
+		return x
+	}
+	i := p.index[e.Position()]
+	x := extent{first: i, last: i}
+	switch e := e.(type) {
+	case *parser.Binary:
+		x = extent{first: p.extent(e.Left).first, last: p.extent(e.Right).last}
+	case *parser.Unary:
+		x.last = p.extent(e.Operand).last
+	case *parser.Call:
+		x = extent{first: p.extent(e.Function).first, last: p.match[i]}
+	case *parser.Subscript:
+		x.first = p.extent(e.Container).first
+		if p.tokens[i].token == DOT || p.tokens[i].token == OPTDOT {
+			x.last = i + 1
+		} else {
+			x.last = p.match[i]
+		}
+	case *parser.Slice:
+		x = extent{first: p.extent(e.Container).first, last: p.match[i]}
+	case *parser.List, *parser.Map:
+		x.last = p.match[i]
+	case *parser.Spread:
+		x = p.extent(e.Value)
+		x.last++
+		x.parens = false
+	case *parser.FunctionExpression:
+		if result := p.lambda(e); result != nil {
+			x.last = p.extent(result).last
+		} else {
+			x.last = p.match[p.match[i+1]+1]
+		}
+	case *parser.Literal:
+		if p.tokens[i].token == MINUS {
+			x.last = i + 1
+		}
+	}
+	for x.first > 0 && p.tokens[x.first-1].token == LPAREN &&
+		p.match[x.first-1] == x.last+1 && !p.callParen(x.first-1) {
+		x.first--
+		x.last++
+		x.parens = true
+	}
+	p.extents[e] = x
+	return x
+}
+
+// Report whether the ( at token index i starts a call's arguments rather
+// than grouping an expression, which it does if it follows an operand
+func (p *printer) callParen(i int) bool {
+	if i == 0 {
+		return false
+	}
+	switch p.tokens[i-1].token {
+	case NAME, INT, FLOAT, STR, TRUE, FALSE, NIL, RPAREN, RBRACKET, RBRACE:
+		return true
+	}
+	return false
+}
+
+// Precedence levels of expressions, from the lowest (which needs
+// parentheses in the most places) to the highest
+const (
+	precLambda   = iota // func(x): x*2, whose body extends as far as it can
+	precOr              // or
+	precAnd             // and
+	precNot             // not
+	precEquality        // == !=
+	precCompare         // < <= > >= in
+	precAdd             // + -
+	precMultiply        // * / %
+	precNegative        // unary -
+	precCall            // calls, subscripts, and slices
+	precPrimary         // names, literals, and so on
+)
+
+var binaryPrecedence = map[Token]int{
+	OR:       precOr,
+	AND:      precAnd,
+	EQUAL:    precEquality,
+	NOTEQUAL: precEquality,
+	LT:       precCompare,
+	LTE:      precCompare,
+	GT:       precCompare,
+	GTE:      precCompare,
+	IN:       precCompare,
+	PLUS:     precAdd,
+	MINUS:    precAdd,
+	TIMES:    precMultiply,
+	DIVIDE:   precMultiply,
+	MODULO:   precMultiply,
+}
+
+func (p *printer) precedence(e parser.Expression) int {
+	switch e := e.(type) {
+	case *parser.Binary:
+		return binaryPrecedence[e.Operator]
+	case *parser.Unary:
+		if e.Operator == NOT {
+			return precNot
+		}
+		return precNegative
+	case *parser.Call, *parser.Subscript, *parser.Slice:
+		return precCall
+	case *parser.FunctionExpression:
+		if p.lambda(e) != nil {
+			return precLambda
+		}
+	}
+	return precPrimary
+}
+
+// If e was written in the short "func(x): x*2" form, return its result
+// expression, otherwise return nil
+func (p *printer) lambda(e *parser.FunctionExpression) parser.Expression {
+	if len(e.Body) != 1 {
+		return nil
+	}
+	r, ok := e.Body[0].(*parser.Return)
+	if !ok || p.tokens[p.index[r.Position()]].token == RETURN {
+		return nil
+	}
+	return r.Result
+}
+
+// Write expression e, in parentheses if it had them in the source or if
+// its precedence is lower than minPrec
+func (p *printer) expr(e parser.Expression, minPrec int) {
+	if p.extent(e).parens || p.precedence(e) < minPrec {
+		p.write("(")
+		defer p.write(")")
+	}
+	switch e := e.(type) {
+	case *parser.Binary:
+		p.binary(e)
+	case *parser.Unary:
+		if e.Operator == NOT {
+			p.write("not ")
+			p.expr(e.Operand, precNot)
+		} else {
+			p.write("-")
+			p.expr(e.Operand, precNegative)
+		}
+	case *parser.Call:
+		p.expr(e.Function, precCall)
+		var items []func()
+		var starts []Position
+		for _, arg := range e.Arguments {
+			arg := arg
+			items = append(items, func() { p.expr(arg, 0) })
+			starts = append(starts, p.expressionStart(arg))
+		}
+		for _, keyword := range e.Keywords {
+			keyword := keyword
+			items = append(items, func() {
+				p.write(keyword.Name + "=")
+				p.expr(keyword.Value, 0)
+			})
+			// Keyword argument starts with the name before the =
+			starts = append(starts, p.tokens[p.extent(keyword.Value).first-2].pos)
+		}
+		p.list(e.Position(), "(", ")", items, starts)
+	case *parser.Literal:
+		i := p.index[e.Position()]
+		switch p.tokens[i].token {
+		case INT, FLOAT, STR:
+			// Keep number and string literals as they were written
+			p.write(p.tokens[i].lexeme)
+		case MINUS:
+			// Negative number in a match pattern
+			p.write("-" + p.tokens[i+1].lexeme)
+		default:
+			p.write(e.String())
+		}
+	case *parser.List:
+		var items []func()
+		var starts []Position
+		for _, value := range e.Values {
+			value := value
+			items = append(items, func() { p.expr(value, 0) })
+			starts = append(starts, p.expressionStart(value))
+		}
+		p.list(e.Position(), "[", "]", items, starts)
+	case *parser.Map:
+		var items []func()
+		var starts []Position
+		for _, item := range e.Items {
+			item := item
+			if item.Key == nil {
+				items = append(items, func() { p.expr(item.Value, 0) })
+				starts = append(starts, p.expressionStart(item.Value))
+				continue
+			}
+			items = append(items, func() {
+				p.expr(item.Key, 0)
+				p.write(": ")
+				p.expr(item.Value, 0)
+			})
+			starts = append(starts, p.expressionStart(item.Key))
+		}
+		p.list(e.Position(), "{", "}", items, starts)
+	case *parser.Spread:
+		p.expr(e.Value, 0)
+		p.write("...")
+	case *parser.FunctionExpression:
+		p.write("func" + params(e.Parameters, e.Ellipsis))
+		if result := p.lambda(e); result != nil {
+			p.write(": ")
+			p.expr(result, 0)
+			break
+		}
+		p.write(" ")
+		p.functionBody(e.Body, p.match[p.index[e.Position()]+1]+1)
+	case *parser.Subscript:
+		p.expr(e.Container, precCall)
+		tok := p.tokens[p.index[e.Position()]]
+		switch tok.token {
+		case DOT:
+			p.write("." + e.Subscript.(*parser.Literal).Value.(string))
+		case OPTDOT:
+			p.write("?." + e.Subscript.(*parser.Literal).Value.(string))
+		default:
+			p.write(tok.lexeme)
+			p.expr(e.Subscript, 0)
+			p.write("]")
+		}
+	case *parser.Slice:
+		p.expr(e.Container, precCall)
+		p.write("[")
+		if e.Start != nil {
+			p.expr(e.Start, 0)
+		}
+		p.write(":")
+		if e.End != nil {
+			p.expr(e.End, 0)
+		}
+		p.write("]")
+	case *parser.Variable:
+		p.write(e.Name)
+	}
+}
+
+func (p *printer) binary(e *parser.Binary) {
+	prec := binaryPrecedence[e.Operator]
+	i := p.index[e.Position()]
+	op, prev, next := p.tokens[i], p.tokens[i-1], p.tokens[i+1]
+
+	// Arithmetic operators may be written without spaces, as in "n-1",
+	// but other operators always have spaces around them
+	space := " "
+	if prec >= precAdd && prev.offset+len(prev.lexeme) == op.offset && op.offset+len(op.lexeme) == next.offset {
+		space = ""
+	}
+	p.expr(e.Left, prec)
+	p.write(space + e.Operator.String())
+	if next.pos.Line > op.pos.Line {
+		// Keep line break after operator, indenting the continuation line
+		p.indent += p.continueIndent
+		p.newline()
+		p.expr(e.Right, prec+1)
+		p.indent -= p.continueIndent
+		return
+	}
+	p.write(space)
+	p.expr(e.Right, prec+1)
+}
+
+// Write the items of a list, map, or call's arguments between the open and
+// close brackets, where pos is the position of the open bracket and starts
+// has the source position of each item. If the first item is on a later
+// line than the open bracket, write the items on separate lines (keeping
+// the line breaks between items from the source) with a comma after each,
+// otherwise write them all on one line.
+func (p *printer) list(pos Position, open, close string, items []func(), starts []Position) {
+	p.write(open)
+	i := p.index[pos]
+	if len(items) == 0 || p.tokens[i+1].pos.Line == pos.Line {
+		for j, item := range items {
+			if j > 0 {
+				p.write(", ")
+			}
+			item()
+		}
+		p.write(close)
+		return
+	}
+	defer p.setContinueIndent(1)()
+	p.indent++
+	p.blockStart = true
+	for j, item := range items {
+		if p.commentsBefore(starts[j]) || starts[j].Line > p.tokens[p.index[starts[j]]-1].pos.Line {
+			p.flushComments(starts[j])
+			p.startLine(starts[j].Line)
+		} else {
+			p.write(" ")
+		}
+		item()
+		p.write(",")
+	}
+	p.flushComments(p.closing(i))
+	p.indent--
+	p.newline()
+	p.write(close)
+}
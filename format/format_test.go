@@ -0,0 +1,149 @@
+// Test format package
+
+package format_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benhoyt/littlelang/format"
+	"github.com/benhoyt/littlelang/internal/corpus"
+	"github.com/benhoyt/littlelang/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{``, ``},
+		{"\n\n", ``},
+
+		// Statements and spacing
+		{`x=1`, "x = 1\n"},
+		{`x = 1  y = 2`, "x = 1\ny = 2\n"},
+		{`y = a  +  b`, "y = a + b\n"},
+		{`x=1+2*(3+4)`, "x = 1+2*(3+4)\n"},
+		{`x = a==b and not c`, "x = a == b and not c\n"},
+		{`t = not (a and b) or c`, "t = not (a and b) or c\n"},
+		{`outer  x=1`, "outer x = 1\n"},
+		{"x = 1\n\n\n\ny = 2\n", "x = 1\n\ny = 2\n"},
+		{"  x = a +\n  b\n", "x = a +\n    b\n"},
+
+		// Blocks
+		{`if x{print(x)}`, "if x {\n    print(x)\n}\n"},
+		{`if x {print(x)} else if y {print(y)} else {print(z)}`,
+			"if x {\n    print(x)\n} else if y {\n    print(y)\n} else {\n    print(z)\n}\n"},
+		{`if x {} else {}`, "if x {} else {}\n"},
+		{`while x > 0 { x = x - 1 }`, "while x > 0 {\n    x = x - 1\n}\n"},
+		{`for k,v in m {print(k,v)}`, "for k, v in m {\n    print(k, v)\n}\n"},
+		{"if x {\n\n    print(x)\n}", "if x {\n    print(x)\n}\n"},
+
+		// Functions
+		{`func add(a,b){return a+b}`, "func add(a, b) { return a+b }\n"},
+		{"func add(a, b) {\n  return a + b\n}", "func add(a, b) {\n    return a + b\n}\n"},
+		{`func f(a, b...) { if a { return b } }`, "func f(a, b...) {\n    if a {\n        return b\n    }\n}\n"},
+		{`f = func(x):x*2`, "f = func(x): x*2\n"},
+		{`print(f(x)(y))`, "print(f(x)(y))\n"},
+		{`(func() { print(1) })()`, "(func() { print(1) })()\n"},
+
+		// Lists, maps, and calls
+		{`nums = [1,2,3,]`, "nums = [1, 2, 3]\n"},
+		{"nums = [1,2,\n  3]", "nums = [1, 2, 3]\n"},
+		{"nums = [\n1,2,\n3]", "nums = [\n    1, 2,\n    3,\n]\n"},
+		{`m = {"a":1, "b" :2,}`, "m = {\"a\": 1, \"b\": 2}\n"},
+		{"m = {\n\"a\": [\n1,\n],\n}", "m = {\n    \"a\": [\n        1,\n    ],\n}\n"},
+		{"print(\n1,\n2)", "print(\n    1,\n    2,\n)\n"},
+		{`print(x, sep="")`, "print(x, sep=\"\")\n"},
+
+		// Comments
+		{"// comment\nx = 1", "// comment\nx = 1\n"},
+		{"x = 1  // one  \n// two", "x = 1 // one\n// two\n"},
+		{"if x {\n// nothing\n}", "if x {\n    // nothing\n}\n"},
+		{"if x {\n} else {\n    // nothing\n}", "if x {} else {\n    // nothing\n}\n"},
+		{"z = [\n    1,  // one\n    2\n]", "z = [\n    1, // one\n    2,\n]\n"},
+
+		// Literals and "#!" line
+		{`x = [1e3, 1.50, "a\tb", ` + "`raw`" + `]`, "x = [1e3, 1.50, \"a\\tb\", `raw`]\n"},
+		{"#!/usr/bin/env littlelang\nprint( 1 )", "#!/usr/bin/env littlelang\nprint(1)\n"},
+	}
+	for _, test := range tests {
+		testName := test.source
+		if len(testName) > 50 {
+			testName = testName[:50]
+		}
+		t.Run(testName, func(t *testing.T) {
+			output, err := format.Source([]byte(test.source))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(output) != test.output {
+				t.Errorf("expected %q, got %q", test.output, string(output))
+			}
+		})
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := format.Source([]byte("x = (1"))
+	e, ok := err.(parser.Error)
+	if !ok {
+		t.Fatalf("expected parser.Error, got %v", err)
+	}
+	if e.Position.Line != 1 || e.Position.Column != 7 {
+		t.Errorf("expected error at 1:7, got %d:%d", e.Position.Line, e.Position.Column)
+	}
+}
+
+// Formatting must not change what a program means, and formatting the
+// output again must not change it further
+func TestRoundTrip(t *testing.T) {
+	sources := map[string]string{}
+	for i, test := range corpus.Tests {
+		sources[fmt.Sprintf("corpus test %d", i)] = test.Source
+	}
+	filenames, err := filepath.Glob("../examples/*.ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames = append(filenames, "../littlelang.ll")
+	stdFilenames, err := filepath.Glob("../std/*.ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range append(filenames, stdFilenames...) {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filename] = string(source)
+	}
+
+	for name, source := range sources {
+		prog, err := parser.ParseProgram([]byte(source))
+		if err != nil {
+			continue
+		}
+		output, err := format.Source([]byte(source))
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", name, err)
+			continue
+		}
+		formatted, err := parser.ParseProgram(output)
+		if err != nil {
+			t.Errorf("%s: formatted source doesn't parse: %v\n%s", name, err, output)
+			continue
+		}
+		if formatted.String() != prog.String() {
+			t.Errorf("%s: formatted program differs:\n%s", name, output)
+			continue
+		}
+		again, err := format.Source(output)
+		if err != nil || string(again) != string(output) {
+			t.Errorf("%s: formatting isn't idempotent:\n%s", name, strings.TrimSpace(string(again)))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package highlight classifies the parts of littlelang source code for
+// syntax highlighting.
+//
+// Call Classify(source) to get a list of classified spans in source order.
+// Spans are found using the tokenizer plus a little lookahead and
+// lookbehind to find function names, so they're suitable for things like
+// LSP semantic tokens even when the source has parse errors.
+//
+package highlight
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Kind is the classification of a span of source code
+type Kind int
+
+const (
+	Keyword  Kind = iota // keywords like "if" and "func", including true, false, and nil
+	String               // string literals, including the quotes
+	Number               // int and float literals
+	Comment              // // comments, not including the newline
+	Function             // name of a function being defined or called
+	Builtin              // name of a builtin function
+)
+
+var kindNames = map[Kind]string{
+	Keyword:  "keyword",
+	String:   "string",
+	Number:   "number",
+	Comment:  "comment",
+	Function: "function",
+	Builtin:  "builtin",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Span is a classified piece of source code. Offset and Length are in
+// bytes; Position is the line and column (in characters) of the start of
+// the span.
+type Span struct {
+	Kind     Kind
+	Offset   int
+	Length   int
+	Position Position
+}
+
+var builtins = make(map[string]bool)
+
+func init() {
+	for _, name := range interpreter.Builtins() {
+		builtins[name] = true
+	}
+}
+
+type token struct {
+	token  Token
+	value  string
+	offset int
+	length int
+}
+
+// Classify returns the classified spans in source, in order. Parts of the
+// source that don't need highlighting, like operators and variable names,
+// aren't included. If the tokenizer hits an error, classification stops at
+// that point.
+func Classify(source []byte) []Span {
+	tokens, comments := tokenize(source)
+	lines := lineOffsets(source)
+	var spans []Span
+	add := func(kind Kind, offset, length int) {
+		spans = append(spans, Span{kind, offset, length, position(source, lines, offset)})
+	}
+
+	for i, tok := range tokens {
+		// Comments occur in the gaps before tokens
+		for len(comments) > 0 && comments[0].offset < tok.offset {
+			add(Comment, comments[0].offset, comments[0].length)
+			comments = comments[1:]
+		}
+
+		switch {
+		case tok.token >= AND && tok.token <= YIELD:
+			add(Keyword, tok.offset, tok.length)
+		case tok.token == STR:
+			add(String, tok.offset, tok.length)
+		case tok.token == INT || tok.token == FLOAT:
+			add(Number, tok.offset, tok.length)
+		case tok.token == NAME:
+			// In "func Point.dist", Point isn't being defined, but dist is
+			defining := i > 0 && tokens[i-1].token == FUNC &&
+				!(i+1 < len(tokens) && tokens[i+1].token == DOT)
+			calling := i+1 < len(tokens) && tokens[i+1].token == LPAREN
+			afterDot := i > 0 && (tokens[i-1].token == DOT || tokens[i-1].token == OPTDOT)
+			switch {
+			case defining:
+				add(Function, tok.offset, tok.length)
+			case builtins[tok.value] && !afterDot:
+				add(Builtin, tok.offset, tok.length)
+			case calling:
+				add(Function, tok.offset, tok.length)
+			}
+		}
+	}
+	for _, c := range comments {
+		add(Comment, c.offset, c.length)
+	}
+	return spans
+}
+
+// Return the tokens in source (up to EOF or an error) and the comments
+// between them
+func tokenize(source []byte) (tokens, comments []token) {
+	t := NewTokenizer(source)
+	t.EmitComments()
+	for {
+		_, tok, value := t.Next()
+		switch tok {
+		case EOF, ILLEGAL:
+			return tokens, comments
+		case COMMENT:
+			comments = append(comments, token{tok, value, t.Offset(), len(t.Lexeme())})
+		default:
+			tokens = append(tokens, token{tok, value, t.Offset(), len(t.Lexeme())})
+		}
+	}
+}
+
+// Return the byte offsets of the start of each line in source
+func lineOffsets(source []byte) []int {
+	offsets := []int{0}
+	for i, b := range source {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// Return the line and column of the given byte offset
+func position(source []byte, lines []int, offset int) Position {
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+	column := utf8.RuneCount(source[lines[line]:offset]) + 1
+	return Position{Line: line + 1, Column: column}
+}
@@ -0,0 +1,47 @@
+// Test highlight package
+
+package highlight_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/benhoyt/littlelang/highlight"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{``, ``},
+		{`x = 1 + y`, `1:5 number "1"`},
+		{`if true { print("hi") }`,
+			`1:1 keyword "if", 1:4 keyword "true", 1:11 builtin "print", 1:17 string "\"hi\""`},
+		{`func add(a, b) { return a + b }`,
+			`1:1 keyword "func", 1:6 function "add", 1:18 keyword "return"`},
+		{`struct Point { x y }  func Point.len(p) { return 0 }`,
+			`1:1 keyword "struct", 1:23 keyword "func", 1:34 function "len", 1:43 keyword "return", 1:50 number "0"`},
+		{`x = add(1, 2)  f = len  m.len`,
+			`1:5 function "add", 1:9 number "1", 1:12 number "2", 1:20 builtin "len"`},
+		{"// comment\nx = 1 // trailing\n// end",
+			`1:1 comment "// comment", 2:5 number "1", 2:7 comment "// trailing", 3:1 comment "// end"`},
+		{`s = "“smart”"  n = 42`, `1:5 string "\"“smart”\"", 1:20 number "42"`},
+		{"x = 1\ny = \"unterminated // not a comment", `1:5 number "1"`},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			var parts []string
+			for _, span := range highlight.Classify([]byte(test.source)) {
+				text := test.source[span.Offset : span.Offset+span.Length]
+				parts = append(parts, fmt.Sprintf("%d:%d %s %q",
+					span.Position.Line, span.Position.Column, span.Kind, text))
+			}
+			output := strings.Join(parts, ", ")
+			if output != test.output {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.output, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Bytes type and bytes builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Bytes value created by the bytes() and readbytes() builtins. It's an
+// immutable sequence of bytes like str, but it's a distinct type so that
+// binary data isn't mixed up with text: subscripting and iterating give
+// ints from 0 to 255 rather than one-character strs.
+type byteString string
+
+func (b byteString) String() string {
+	strs := make([]string, len(b))
+	for i := 0; i < len(b); i++ {
+		strs[i] = toString(int(b[i]), false)
+	}
+	return "bytes([" + strings.Join(strs, ", ") + "])"
+}
+
+func bytesFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "bytes", args, 1)
+	switch arg := args[0].(type) {
+	case byteString:
+		return Value(arg)
+	case string:
+		return Value(byteString(arg))
+	case *[]Value:
+		b := make([]byte, len(*arg))
+		for i, v := range *arg {
+			n, ok := v.(int)
+			if !ok || n < 0 || n > 255 {
+				panic(valueError(pos, "bytes() list elements must be ints from 0 to 255"))
+			}
+			b[i] = byte(n)
+		}
+		return Value(byteString(b))
+	}
+	panic(argTypeError(pos, "bytes", 1, "a str, bytes, or list", args[0]))
+}
+
+func decodeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "decode", args, 1)
+	if b, ok := args[0].(byteString); ok {
+		return Value(string(b))
+	}
+	panic(argTypeError(pos, "decode", 1, "bytes", args[0]))
+}
+
+// Return the data in a str or bytes argument, raising an error for other
+// types
+func ensureData(pos Position, name string, index int, arg Value) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return []byte(arg)
+	case byteString:
+		return []byte(arg)
+	}
+	panic(argTypeError(pos, name, index, "a str or bytes", arg))
+}
+
+func gunzipFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "gunzip", args, 1)
+	reader, err := gzip.NewReader(bytes.NewReader(ensureData(pos, "gunzip", 1, args[0])))
+	if err != nil {
+		panic(valueError(pos, "gunzip() error: %v", err))
+	}
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		panic(valueError(pos, "gunzip() error: %v", err))
+	}
+	return Value(byteString(b))
+}
+
+func gzipFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "gzip", args, 1)
+	data := ensureData(pos, "gzip", 1, args[0])
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write(data)
+	writer.Close()
+	return Value(byteString(buf.Bytes()))
+}
+
+func hexFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "hex", args, 1)
+	return Value(hex.EncodeToString(ensureData(pos, "hex", 1, args[0])))
+}
+
+func readbytesFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) > 1 {
+		panic(typeError(pos, "readbytes() requires 0 or 1 args, got %d", len(args)))
+	}
+	var b []byte
+	var err error
+	if len(args) == 0 {
+		interp.blockingRead(func() {
+			b, err = ioutil.ReadAll(interp.stdin)
+		})
+	} else {
+		filename, ok := args[0].(string)
+		if !ok {
+			panic(argTypeError(pos, "readbytes", 1, "a str", args[0]))
+		}
+		interp.ensureFS(pos, "readbytes")
+		interp.blockingRead(func() {
+			b, err = interp.readFile(filename)
+		})
+	}
+	if err != nil {
+		panic(runtimeError(pos, "readbytes() error: %v", err))
+	}
+	return Value(byteString(b))
+}
+
+func writeFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "write() requires 1 or 2 args, got %d", len(args)))
+	}
+	data := ensureData(pos, "write", 1, args[0])
+	var err error
+	if len(args) == 1 {
+		_, err = interp.stdout.Write(data)
+	} else {
+		filename, ok := args[1].(string)
+		if !ok {
+			panic(argTypeError(pos, "write", 2, "a str", args[1]))
+		}
+		interp.ensureFS(pos, "write")
+		err = interp.writeFile(filename, data)
+	}
+	if err != nil {
+		panic(runtimeError(pos, "write() error: %v", err))
+	}
+	return Value(nil)
+}
+
+func appendfileFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "appendfile", args, 2)
+	filename := ensureStr(pos, "appendfile", 1, args[0])
+	data := ensureData(pos, "appendfile", 2, args[1])
+	interp.ensureFS(pos, "appendfile")
+	err := interp.appendFile(filename, data)
+	if err != nil {
+		panic(runtimeError(pos, "appendfile() error: %v", err))
+	}
+	return Value(nil)
+}
+
+func unhexFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "unhex", args, 1)
+	str, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "unhex", 1, "a str", args[0]))
+	}
+	b, err := hex.DecodeString(str)
+	if err != nil {
+		panic(valueError(pos, "unhex() argument is not valid hex"))
+	}
+	return Value(byteString(b))
+}
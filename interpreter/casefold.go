@@ -0,0 +1,148 @@
+// Unicode case folding for casefold() builtin
+
+package interpreter
+
+import (
+	"strings"
+	"unicode"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+func casefoldFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "casefold", args, 1)
+	if s, ok := args[0].(string); ok {
+		return Value(casefold(s))
+	}
+	panic(argTypeError(pos, "casefold", 1, "a str", args[0]))
+}
+
+// Return s with Unicode full case folding applied, so that strings that
+// differ only in case fold to the same string. Characters whose folded
+// form is more than one character (like "ß" to "ss") are looked up in
+// fullFolds. Others are uppercased and then lowercased, which maps
+// characters with several lowercase forms (like Greek final sigma) to the
+// same one. (Unlike CaseFolding.txt, this folds Cherokee to lowercase
+// rather than uppercase, but it still folds both cases to the same one.)
+func casefold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if folded, ok := fullFolds[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+		}
+	}
+	return b.String()
+}
+
+// Characters that fold to more than one character (the "F" mappings in the
+// Unicode CaseFolding.txt file), and dotless i, which doesn't fold to i
+// even though it uppercases to I
+var fullFolds = map[rune]string{
+	0x00DF: "ss",                 // LATIN SMALL LETTER SHARP S
+	0x0130: "i\u0307",            // LATIN CAPITAL LETTER I WITH DOT ABOVE
+	0x0131: "\u0131",             // LATIN SMALL LETTER DOTLESS I
+	0x0149: "\u02BCn",            // LATIN SMALL LETTER N PRECEDED BY APOSTROPHE
+	0x01F0: "j\u030C",            // LATIN SMALL LETTER J WITH CARON
+	0x0390: "\u03B9\u0308\u0301", // GREEK SMALL LETTER IOTA WITH DIALYTIKA AND TONOS
+	0x03B0: "\u03C5\u0308\u0301", // GREEK SMALL LETTER UPSILON WITH DIALYTIKA AND TONOS
+	0x0587: "\u0565\u0582",       // ARMENIAN SMALL LIGATURE ECH YIWN
+	0x1E96: "h\u0331",            // LATIN SMALL LETTER H WITH LINE BELOW
+	0x1E97: "t\u0308",            // LATIN SMALL LETTER T WITH DIAERESIS
+	0x1E98: "w\u030A",            // LATIN SMALL LETTER W WITH RING ABOVE
+	0x1E99: "y\u030A",            // LATIN SMALL LETTER Y WITH RING ABOVE
+	0x1E9A: "a\u02BE",            // LATIN SMALL LETTER A WITH RIGHT HALF RING
+	0x1E9E: "ss",                 // LATIN CAPITAL LETTER SHARP S
+	0x1F50: "\u03C5\u0313",       // GREEK SMALL LETTER UPSILON WITH PSILI
+	0x1F52: "\u03C5\u0313\u0300", // GREEK SMALL LETTER UPSILON WITH PSILI AND VARIA
+	0x1F54: "\u03C5\u0313\u0301", // GREEK SMALL LETTER UPSILON WITH PSILI AND OXIA
+	0x1F56: "\u03C5\u0313\u0342", // GREEK SMALL LETTER UPSILON WITH PSILI AND PERISPOMENI
+	0x1F80: "\u1F00\u03B9",       // GREEK SMALL LETTER ALPHA WITH PSILI AND YPOGEGRAMMENI
+	0x1F81: "\u1F01\u03B9",       // GREEK SMALL LETTER ALPHA WITH DASIA AND YPOGEGRAMMENI
+	0x1F82: "\u1F02\u03B9",       // GREEK SMALL LETTER ALPHA WITH PSILI AND VARIA AND YPOGEGRAMMENI
+	0x1F83: "\u1F03\u03B9",       // GREEK SMALL LETTER ALPHA WITH DASIA AND VARIA AND YPOGEGRAMMENI
+	0x1F84: "\u1F04\u03B9",       // GREEK SMALL LETTER ALPHA WITH PSILI AND OXIA AND YPOGEGRAMMENI
+	0x1F85: "\u1F05\u03B9",       // GREEK SMALL LETTER ALPHA WITH DASIA AND OXIA AND YPOGEGRAMMENI
+	0x1F86: "\u1F06\u03B9",       // GREEK SMALL LETTER ALPHA WITH PSILI AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1F87: "\u1F07\u03B9",       // GREEK SMALL LETTER ALPHA WITH DASIA AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1F88: "\u1F00\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH PSILI AND PROSGEGRAMMENI
+	0x1F89: "\u1F01\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH DASIA AND PROSGEGRAMMENI
+	0x1F8A: "\u1F02\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH PSILI AND VARIA AND PROSGEGRAMMENI
+	0x1F8B: "\u1F03\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH DASIA AND VARIA AND PROSGEGRAMMENI
+	0x1F8C: "\u1F04\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH PSILI AND OXIA AND PROSGEGRAMMENI
+	0x1F8D: "\u1F05\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH DASIA AND OXIA AND PROSGEGRAMMENI
+	0x1F8E: "\u1F06\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH PSILI AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1F8F: "\u1F07\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH DASIA AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1F90: "\u1F20\u03B9",       // GREEK SMALL LETTER ETA WITH PSILI AND YPOGEGRAMMENI
+	0x1F91: "\u1F21\u03B9",       // GREEK SMALL LETTER ETA WITH DASIA AND YPOGEGRAMMENI
+	0x1F92: "\u1F22\u03B9",       // GREEK SMALL LETTER ETA WITH PSILI AND VARIA AND YPOGEGRAMMENI
+	0x1F93: "\u1F23\u03B9",       // GREEK SMALL LETTER ETA WITH DASIA AND VARIA AND YPOGEGRAMMENI
+	0x1F94: "\u1F24\u03B9",       // GREEK SMALL LETTER ETA WITH PSILI AND OXIA AND YPOGEGRAMMENI
+	0x1F95: "\u1F25\u03B9",       // GREEK SMALL LETTER ETA WITH DASIA AND OXIA AND YPOGEGRAMMENI
+	0x1F96: "\u1F26\u03B9",       // GREEK SMALL LETTER ETA WITH PSILI AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1F97: "\u1F27\u03B9",       // GREEK SMALL LETTER ETA WITH DASIA AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1F98: "\u1F20\u03B9",       // GREEK CAPITAL LETTER ETA WITH PSILI AND PROSGEGRAMMENI
+	0x1F99: "\u1F21\u03B9",       // GREEK CAPITAL LETTER ETA WITH DASIA AND PROSGEGRAMMENI
+	0x1F9A: "\u1F22\u03B9",       // GREEK CAPITAL LETTER ETA WITH PSILI AND VARIA AND PROSGEGRAMMENI
+	0x1F9B: "\u1F23\u03B9",       // GREEK CAPITAL LETTER ETA WITH DASIA AND VARIA AND PROSGEGRAMMENI
+	0x1F9C: "\u1F24\u03B9",       // GREEK CAPITAL LETTER ETA WITH PSILI AND OXIA AND PROSGEGRAMMENI
+	0x1F9D: "\u1F25\u03B9",       // GREEK CAPITAL LETTER ETA WITH DASIA AND OXIA AND PROSGEGRAMMENI
+	0x1F9E: "\u1F26\u03B9",       // GREEK CAPITAL LETTER ETA WITH PSILI AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1F9F: "\u1F27\u03B9",       // GREEK CAPITAL LETTER ETA WITH DASIA AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1FA0: "\u1F60\u03B9",       // GREEK SMALL LETTER OMEGA WITH PSILI AND YPOGEGRAMMENI
+	0x1FA1: "\u1F61\u03B9",       // GREEK SMALL LETTER OMEGA WITH DASIA AND YPOGEGRAMMENI
+	0x1FA2: "\u1F62\u03B9",       // GREEK SMALL LETTER OMEGA WITH PSILI AND VARIA AND YPOGEGRAMMENI
+	0x1FA3: "\u1F63\u03B9",       // GREEK SMALL LETTER OMEGA WITH DASIA AND VARIA AND YPOGEGRAMMENI
+	0x1FA4: "\u1F64\u03B9",       // GREEK SMALL LETTER OMEGA WITH PSILI AND OXIA AND YPOGEGRAMMENI
+	0x1FA5: "\u1F65\u03B9",       // GREEK SMALL LETTER OMEGA WITH DASIA AND OXIA AND YPOGEGRAMMENI
+	0x1FA6: "\u1F66\u03B9",       // GREEK SMALL LETTER OMEGA WITH PSILI AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1FA7: "\u1F67\u03B9",       // GREEK SMALL LETTER OMEGA WITH DASIA AND PERISPOMENI AND YPOGEGRAMMENI
+	0x1FA8: "\u1F60\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH PSILI AND PROSGEGRAMMENI
+	0x1FA9: "\u1F61\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH DASIA AND PROSGEGRAMMENI
+	0x1FAA: "\u1F62\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH PSILI AND VARIA AND PROSGEGRAMMENI
+	0x1FAB: "\u1F63\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH DASIA AND VARIA AND PROSGEGRAMMENI
+	0x1FAC: "\u1F64\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH PSILI AND OXIA AND PROSGEGRAMMENI
+	0x1FAD: "\u1F65\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH DASIA AND OXIA AND PROSGEGRAMMENI
+	0x1FAE: "\u1F66\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH PSILI AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1FAF: "\u1F67\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH DASIA AND PERISPOMENI AND PROSGEGRAMMENI
+	0x1FB2: "\u1F70\u03B9",       // GREEK SMALL LETTER ALPHA WITH VARIA AND YPOGEGRAMMENI
+	0x1FB3: "\u03B1\u03B9",       // GREEK SMALL LETTER ALPHA WITH YPOGEGRAMMENI
+	0x1FB4: "\u03AC\u03B9",       // GREEK SMALL LETTER ALPHA WITH OXIA AND YPOGEGRAMMENI
+	0x1FB6: "\u03B1\u0342",       // GREEK SMALL LETTER ALPHA WITH PERISPOMENI
+	0x1FB7: "\u03B1\u0342\u03B9", // GREEK SMALL LETTER ALPHA WITH PERISPOMENI AND YPOGEGRAMMENI
+	0x1FBC: "\u03B1\u03B9",       // GREEK CAPITAL LETTER ALPHA WITH PROSGEGRAMMENI
+	0x1FC2: "\u1F74\u03B9",       // GREEK SMALL LETTER ETA WITH VARIA AND YPOGEGRAMMENI
+	0x1FC3: "\u03B7\u03B9",       // GREEK SMALL LETTER ETA WITH YPOGEGRAMMENI
+	0x1FC4: "\u03AE\u03B9",       // GREEK SMALL LETTER ETA WITH OXIA AND YPOGEGRAMMENI
+	0x1FC6: "\u03B7\u0342",       // GREEK SMALL LETTER ETA WITH PERISPOMENI
+	0x1FC7: "\u03B7\u0342\u03B9", // GREEK SMALL LETTER ETA WITH PERISPOMENI AND YPOGEGRAMMENI
+	0x1FCC: "\u03B7\u03B9",       // GREEK CAPITAL LETTER ETA WITH PROSGEGRAMMENI
+	0x1FD2: "\u03B9\u0308\u0300", // GREEK SMALL LETTER IOTA WITH DIALYTIKA AND VARIA
+	0x1FD3: "\u03B9\u0308\u0301", // GREEK SMALL LETTER IOTA WITH DIALYTIKA AND OXIA
+	0x1FD6: "\u03B9\u0342",       // GREEK SMALL LETTER IOTA WITH PERISPOMENI
+	0x1FD7: "\u03B9\u0308\u0342", // GREEK SMALL LETTER IOTA WITH DIALYTIKA AND PERISPOMENI
+	0x1FE2: "\u03C5\u0308\u0300", // GREEK SMALL LETTER UPSILON WITH DIALYTIKA AND VARIA
+	0x1FE3: "\u03C5\u0308\u0301", // GREEK SMALL LETTER UPSILON WITH DIALYTIKA AND OXIA
+	0x1FE4: "\u03C1\u0313",       // GREEK SMALL LETTER RHO WITH PSILI
+	0x1FE6: "\u03C5\u0342",       // GREEK SMALL LETTER UPSILON WITH PERISPOMENI
+	0x1FE7: "\u03C5\u0308\u0342", // GREEK SMALL LETTER UPSILON WITH DIALYTIKA AND PERISPOMENI
+	0x1FF2: "\u1F7C\u03B9",       // GREEK SMALL LETTER OMEGA WITH VARIA AND YPOGEGRAMMENI
+	0x1FF3: "\u03C9\u03B9",       // GREEK SMALL LETTER OMEGA WITH YPOGEGRAMMENI
+	0x1FF4: "\u03CE\u03B9",       // GREEK SMALL LETTER OMEGA WITH OXIA AND YPOGEGRAMMENI
+	0x1FF6: "\u03C9\u0342",       // GREEK SMALL LETTER OMEGA WITH PERISPOMENI
+	0x1FF7: "\u03C9\u0342\u03B9", // GREEK SMALL LETTER OMEGA WITH PERISPOMENI AND YPOGEGRAMMENI
+	0x1FFC: "\u03C9\u03B9",       // GREEK CAPITAL LETTER OMEGA WITH PROSGEGRAMMENI
+	0xFB00: "ff",                 // LATIN SMALL LIGATURE FF
+	0xFB01: "fi",                 // LATIN SMALL LIGATURE FI
+	0xFB02: "fl",                 // LATIN SMALL LIGATURE FL
+	0xFB03: "ffi",                // LATIN SMALL LIGATURE FFI
+	0xFB04: "ffl",                // LATIN SMALL LIGATURE FFL
+	0xFB05: "st",                 // LATIN SMALL LIGATURE LONG S T
+	0xFB06: "st",                 // LATIN SMALL LIGATURE ST
+	0xFB13: "\u0574\u0576",       // ARMENIAN SMALL LIGATURE MEN NOW
+	0xFB14: "\u0574\u0565",       // ARMENIAN SMALL LIGATURE MEN ECH
+	0xFB15: "\u0574\u056B",       // ARMENIAN SMALL LIGATURE MEN INI
+	0xFB16: "\u057E\u0576",       // ARMENIAN SMALL LIGATURE VEW NOW
+	0xFB17: "\u0574\u056D",       // ARMENIAN SMALL LIGATURE MEN XEH
+}
@@ -0,0 +1,54 @@
+// CSV builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"encoding/csv"
+	"strings"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+func parsecsvFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "parsecsv", args, 1)
+	str, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "parsecsv", 1, "a str", args[0]))
+	}
+	reader := csv.NewReader(strings.NewReader(str))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if e, ok := err.(*csv.ParseError); ok {
+		panic(valueError(pos, "parsecsv() error on line %d: %v", e.Line, e.Err))
+	} else if err != nil {
+		panic(valueError(pos, "parsecsv() error: %v", err))
+	}
+	rows := make([]Value, len(records))
+	for i, record := range records {
+		rows[i] = stringsToList(record)
+	}
+	return Value(&rows)
+}
+
+func csvFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "csv", args, 1)
+	rows, ok := args[0].(*[]Value)
+	if !ok {
+		panic(argTypeError(pos, "csv", 1, "a list", args[0]))
+	}
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	for _, row := range *rows {
+		fields, ok := row.(*[]Value)
+		if !ok {
+			panic(typeError(pos, "csv() rows must be lists, not %s", typeName(row)))
+		}
+		record := make([]string, len(*fields))
+		for i, field := range *fields {
+			record[i] = valueString(field, false, nil, interp.strHook(pos))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return Value(b.String())
+}
@@ -10,80 +10,264 @@ import (
 
 // Error is the error type returned by Evaluate and Execute. Each error holds
 // the position of the error in the source and the error message, which can be
-// queried on the type or via Error().
+// queried on the type or via Error(), as well as the stack of function calls
+// active when the error occurred.
 type Error interface {
 	error
 	Position() Position
+	Stack() []Frame
+}
+
+// Frame is one function call in an error's call stack.
+type Frame struct {
+	Function string   // function name, for example "<func add>"
+	Position Position // position of the call
 }
 
 // TypeError is returned for invalid types and wrong number of arguments.
 type TypeError struct {
 	Message string
 	pos     Position
+	stack   []Frame
 }
 
 func (e TypeError) Error() string {
-	return fmt.Sprintf("type error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("type error at %s: %s", e.pos, e.Message)
 }
 
 func (e TypeError) Position() Position {
 	return e.pos
 }
 
+func (e TypeError) Stack() []Frame {
+	return e.stack
+}
+
 func typeError(pos Position, format string, args ...interface{}) error {
-	return TypeError{fmt.Sprintf(format, args...), pos}
+	return TypeError{fmt.Sprintf(format, args...), pos, nil}
 }
 
 // ValueError is returned for invalid values (out of bounds index, etc).
 type ValueError struct {
 	Message string
 	pos     Position
+	stack   []Frame
 }
 
 func (e ValueError) Error() string {
-	return fmt.Sprintf("value error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("value error at %s: %s", e.pos, e.Message)
 }
 
 func (e ValueError) Position() Position {
 	return e.pos
 }
 
+func (e ValueError) Stack() []Frame {
+	return e.stack
+}
+
 func valueError(pos Position, format string, args ...interface{}) error {
-	return ValueError{fmt.Sprintf(format, args...), pos}
+	return ValueError{fmt.Sprintf(format, args...), pos, nil}
 }
 
 // NameError is returned when a variable is not found.
 type NameError struct {
 	Message string
 	pos     Position
+	stack   []Frame
 }
 
 func (e NameError) Error() string {
-	return fmt.Sprintf("name error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("name error at %s: %s", e.pos, e.Message)
 }
 
 func (e NameError) Position() Position {
 	return e.pos
 }
 
+func (e NameError) Stack() []Frame {
+	return e.stack
+}
+
 func nameError(pos Position, format string, args ...interface{}) error {
-	return NameError{fmt.Sprintf(format, args...), pos}
+	return NameError{fmt.Sprintf(format, args...), pos, nil}
 }
 
 // RuntimeError is returned for other or internal runtime errors.
 type RuntimeError struct {
 	Message string
 	pos     Position
+	stack   []Frame
 }
 
 func (e RuntimeError) Error() string {
-	return fmt.Sprintf("runtime error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("runtime error at %s: %s", e.pos, e.Message)
 }
 
 func (e RuntimeError) Position() Position {
 	return e.pos
 }
 
+func (e RuntimeError) Stack() []Frame {
+	return e.stack
+}
+
 func runtimeError(pos Position, format string, args ...interface{}) error {
-	return RuntimeError{fmt.Sprintf(format, args...), pos}
+	return RuntimeError{fmt.Sprintf(format, args...), pos, nil}
+}
+
+// UserError is returned when the program calls the throw() builtin and
+// the error isn't caught.
+type UserError struct {
+	Message string
+	Value   Value // value passed as throw()'s second argument, or nil
+	pos     Position
+	stack   []Frame
+}
+
+func (e UserError) Error() string {
+	return fmt.Sprintf("error at %s: %s", e.pos, e.Message)
+}
+
+func (e UserError) Position() Position {
+	return e.pos
+}
+
+func (e UserError) Stack() []Frame {
+	return e.stack
+}
+
+// AssertionError is returned when the condition passed to the assert()
+// builtin is false and the error isn't caught.
+type AssertionError struct {
+	Message string
+	pos     Position
+	stack   []Frame
+}
+
+func (e AssertionError) Error() string {
+	return fmt.Sprintf("assertion error at %s: %s", e.pos, e.Message)
+}
+
+func (e AssertionError) Position() Position {
+	return e.pos
+}
+
+func (e AssertionError) Stack() []Frame {
+	return e.stack
+}
+
+// TimeoutError is returned when execution is stopped because the context in
+// Config.Context was canceled or its deadline passed.
+type TimeoutError struct {
+	Message string
+	pos     Position
+	stack   []Frame
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("%s at %s", e.Message, e.pos)
+}
+
+func (e TimeoutError) Position() Position {
+	return e.pos
+}
+
+func (e TimeoutError) Stack() []Frame {
+	return e.stack
+}
+
+// ExitError is returned when the program calls the exit() builtin and
+// Config.ReturnExit is true.
+type ExitError struct {
+	Status int // status passed to exit(), or 0 if not given
+	pos    Position
+	stack  []Frame
+}
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit(%d) at %s", e.Status, e.pos)
+}
+
+func (e ExitError) Position() Position {
+	return e.pos
+}
+
+func (e ExitError) Stack() []Frame {
+	return e.stack
+}
+
+// Convert err to the value assigned to the error name in a try statement's
+// catch block: a map with keys "kind" ("type", "value", "name", or
+// "runtime", "error" if raised by throw(), or "assertion" if raised by
+// assert()), "message", "line", "column", "value" (throw()'s value
+// argument, nil for other errors), and "filename" (the name of the source
+// file the error occurred in, nil if the code wasn't parsed from a named
+// file, for example with ParseProgram). Return false if err can't be caught
+// (TimeoutError and ExitError can't be, so that a timeout or exit() always
+// stops execution).
+func caughtValue(err Error) (map[string]Value, bool) {
+	var kind, message string
+	var payload Value
+	switch e := err.(type) {
+	case TypeError:
+		kind, message = "type", e.Message
+	case ValueError:
+		kind, message = "value", e.Message
+	case NameError:
+		kind, message = "name", e.Message
+	case RuntimeError:
+		kind, message = "runtime", e.Message
+	case UserError:
+		kind, message, payload = "error", e.Message, e.Value
+	case AssertionError:
+		kind, message = "assertion", e.Message
+	default:
+		return nil, false
+	}
+	pos := err.Position()
+	var filename Value
+	if pos.Filename != "" {
+		filename = pos.Filename
+	}
+	value := map[string]Value{
+		"kind":     kind,
+		"message":  message,
+		"line":     pos.Line,
+		"column":   pos.Column,
+		"value":    payload,
+		"filename": filename,
+	}
+	return value, true
+}
+
+// Return a copy of err with the given call stack
+func withStack(err Error, stack []Frame) Error {
+	switch e := err.(type) {
+	case TypeError:
+		e.stack = stack
+		return e
+	case ValueError:
+		e.stack = stack
+		return e
+	case NameError:
+		e.stack = stack
+		return e
+	case RuntimeError:
+		e.stack = stack
+		return e
+	case UserError:
+		e.stack = stack
+		return e
+	case AssertionError:
+		e.stack = stack
+		return e
+	case TimeoutError:
+		e.stack = stack
+		return e
+	case ExitError:
+		e.stack = stack
+		return e
+	}
+	return err
 }
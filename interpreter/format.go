@@ -0,0 +1,205 @@
+// format() builtin for littlelang interpreter
+
+package interpreter
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Parsed form of the spec after the ":" in a format() placeholder like
+// {:>8.2f}, which is [align][0][width][.precision][verb]
+type formatSpec struct {
+	align     byte // '<', '>', '^', or 0 for the default
+	zero      bool
+	width     int
+	precision int  // -1 if not given
+	verb      byte // 'd', 'x', 'f', 's', or 0 for any type
+}
+
+func parseFormatSpec(spec string) (formatSpec, bool) {
+	f := formatSpec{precision: -1}
+	i := 0
+	digits := func() (int, bool) {
+		start := i
+		for i < len(spec) && spec[i] >= '0' && spec[i] <= '9' {
+			i++
+		}
+		n, err := strconv.Atoi(spec[start:i])
+		return n, err == nil
+	}
+	if i < len(spec) && strings.IndexByte("<>^", spec[i]) >= 0 {
+		f.align = spec[i]
+		i++
+	}
+	if i < len(spec) && spec[i] == '0' {
+		f.zero = true
+		i++
+	}
+	if i < len(spec) && spec[i] >= '1' && spec[i] <= '9' {
+		width, ok := digits()
+		if !ok {
+			return f, false
+		}
+		f.width = width
+	}
+	if i < len(spec) && spec[i] == '.' {
+		i++
+		precision, ok := digits()
+		if !ok {
+			return f, false
+		}
+		f.precision = precision
+	}
+	if i < len(spec) && strings.IndexByte("dxfs", spec[i]) >= 0 {
+		f.verb = spec[i]
+		i++
+	}
+	return f, i == len(spec)
+}
+
+// Format a single value according to spec; placeholder is the whole
+// placeholder text, for error messages
+func (interp *interpreter) formatValue(pos Position, placeholder string, spec formatSpec, value Value) string {
+	var s string
+	numeric := false
+	switch spec.verb {
+	case 'd', 'x':
+		n, ok := value.(int)
+		if !ok {
+			panic(typeError(pos, "format() %s requires an int, not %s", placeholder, typeName(value)))
+		}
+		base := 10
+		if spec.verb == 'x' {
+			base = 16
+		}
+		s = strconv.FormatInt(int64(n), base)
+		numeric = true
+	case 'f':
+		f, ok := toFloat(value)
+		if !ok {
+			panic(typeError(pos, "format() %s requires a number, not %s", placeholder, typeName(value)))
+		}
+		precision := spec.precision
+		if precision < 0 {
+			precision = 6
+		}
+		s = strconv.FormatFloat(f, 'f', precision, 64)
+		numeric = true
+	case 's':
+		str, ok := value.(string)
+		if !ok {
+			panic(typeError(pos, "format() %s requires a str, not %s", placeholder, typeName(value)))
+		}
+		s = truncateRunes(str, spec.precision)
+	default:
+		switch v := value.(type) {
+		case int:
+			s = strconv.Itoa(v)
+			numeric = true
+		case float64:
+			if spec.precision >= 0 {
+				s = strconv.FormatFloat(v, 'f', spec.precision, 64)
+			} else {
+				s = formatFloat(v)
+			}
+			numeric = true
+		case string:
+			s = truncateRunes(v, spec.precision)
+		default:
+			s = valueString(value, false, nil, interp.strHook(pos))
+		}
+	}
+
+	pad := spec.width - utf8.RuneCountInString(s)
+	if pad <= 0 {
+		return s
+	}
+	if spec.zero && numeric {
+		sign := ""
+		if strings.HasPrefix(s, "-") {
+			sign, s = "-", s[1:]
+		}
+		return sign + strings.Repeat("0", pad) + s
+	}
+	align := spec.align
+	if align == 0 {
+		align = '<'
+		if numeric {
+			align = '>'
+		}
+	}
+	switch align {
+	case '<':
+		return s + strings.Repeat(" ", pad)
+	case '>':
+		return strings.Repeat(" ", pad) + s
+	default:
+		left := pad / 2
+		return strings.Repeat(" ", left) + s + strings.Repeat(" ", pad-left)
+	}
+}
+
+// Return the first n characters of s, or all of s if n is negative
+func truncateRunes(s string, n int) string {
+	if n < 0 {
+		return s
+	}
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func formatFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) < 1 {
+		panic(typeError(pos, "format() requires at least 1 arg, got %d", len(args)))
+	}
+	format, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "format", 1, "a str", args[0]))
+	}
+	values := args[1:]
+	var b strings.Builder
+	n := 0 // number of placeholders so far
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case (c == '{' || c == '}') && i+1 < len(format) && format[i+1] == c:
+			b.WriteByte(c)
+			i++
+		case c == '}':
+			panic(valueError(pos, "format() string has } without matching {"))
+		case c == '{':
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				panic(valueError(pos, "format() string has { without matching }"))
+			}
+			placeholder := format[i : i+end+1]
+			inner := placeholder[1 : len(placeholder)-1]
+			spec, ok := parseFormatSpec(strings.TrimPrefix(inner, ":"))
+			if !ok || (inner != "" && inner[0] != ':') {
+				panic(valueError(pos, "format() placeholder %s is invalid", placeholder))
+			}
+			if n < len(values) {
+				b.WriteString(interp.formatValue(pos, placeholder, spec, values[n]))
+			}
+			n++
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if n > len(values) {
+		panic(valueError(pos, "format() string has more placeholders than args"))
+	} else if n < len(values) {
+		panic(valueError(pos, "format() got more args than placeholders"))
+	}
+	return Value(b.String())
+}
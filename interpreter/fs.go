@@ -0,0 +1,148 @@
+// Filesystem builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Return the filename argument of a filesystem builtin, raising an error if
+// it's not a str or the filesystem can't be accessed
+func (interp *interpreter) ensurePath(pos Position, name string, args []Value) string {
+	ensureNumArgs(pos, name, args, 1)
+	path := ensureStr(pos, name, 1, args[0])
+	interp.ensureFS(pos, name)
+	return path
+}
+
+func existsFunc(interp *interpreter, pos Position, args []Value) Value {
+	path := interp.ensurePath(pos, "exists", args)
+	_, err := interp.stat(path)
+	if os.IsNotExist(err) {
+		return Value(false)
+	} else if err != nil {
+		panic(runtimeError(pos, "exists() error: %v", err))
+	}
+	return Value(true)
+}
+
+func listdirFunc(interp *interpreter, pos Position, args []Value) Value {
+	path := interp.ensurePath(pos, "listdir", args)
+	infos, err := interp.readDir(path)
+	if err != nil {
+		panic(runtimeError(pos, "listdir() error: %v", err))
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return stringsToList(names)
+}
+
+func mkdirFunc(interp *interpreter, pos Position, args []Value) Value {
+	path := interp.ensurePath(pos, "mkdir", args)
+	err := interp.mkdir(path)
+	if err != nil {
+		panic(runtimeError(pos, "mkdir() error: %v", err))
+	}
+	return Value(nil)
+}
+
+func removeFunc(interp *interpreter, pos Position, args []Value) Value {
+	path := interp.ensurePath(pos, "remove", args)
+	err := interp.remove(path)
+	if err != nil {
+		panic(runtimeError(pos, "remove() error: %v", err))
+	}
+	return Value(nil)
+}
+
+func statFunc(interp *interpreter, pos Position, args []Value) Value {
+	path := interp.ensurePath(pos, "stat", args)
+	info, err := interp.stat(path)
+	if err != nil {
+		panic(runtimeError(pos, "stat() error: %v", err))
+	}
+	return Value(map[string]Value{
+		"name":    info.Name(),
+		"size":    int(info.Size()),
+		"isdir":   info.IsDir(),
+		"mode":    int(info.Mode().Perm()),
+		"modtime": timeValue(info.ModTime()),
+	})
+}
+
+// Append data to the named file, creating it if it doesn't exist (the
+// default for Config.AppendFile)
+func appendFile(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Read the named source file for include(), searching each directory in
+// the include path in turn if it's relative and not found relative to the
+// current directory. Return the source and the name of the file read.
+func (interp *interpreter) readInclude(filename string) ([]byte, string, error) {
+	source, err := interp.readFile(filename)
+	if !os.IsNotExist(err) || filepath.IsAbs(filename) {
+		return source, filename, err
+	}
+	for _, dir := range interp.includes {
+		dirFilename := filepath.Join(dir, filename)
+		dirSource, dirErr := interp.readFile(dirFilename)
+		if !os.IsNotExist(dirErr) {
+			return dirSource, dirFilename, dirErr
+		}
+	}
+	return nil, filename, err
+}
+
+// Read up to n bytes from reader, returning fewer only at the end of input
+func readChunk(reader io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	m, err := io.ReadFull(reader, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return b[:m], err
+}
+
+// Read the next chunk of up to n bytes from the named file, opening it on
+// the first call. The file is closed when a read returns nothing, so the
+// next read starts again from the beginning.
+func (interp *interpreter) readFileChunk(filename string, n int) ([]byte, error) {
+	file := interp.openFiles[filename]
+	if file == nil {
+		var err error
+		file, err = interp.openFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		interp.openFiles[filename] = file
+	}
+	b, err := readChunk(file, n)
+	if err != nil || (len(b) == 0 && n > 0) {
+		delete(interp.openFiles, filename)
+		file.Close()
+	}
+	return b, err
+}
+
+// Close any files left open by chunked reads
+func (interp *interpreter) closeFiles() {
+	for filename, file := range interp.openFiles {
+		file.Close()
+		delete(interp.openFiles, filename)
+	}
+}
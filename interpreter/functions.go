@@ -4,12 +4,16 @@ package interpreter
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/std"
 	. "github.com/benhoyt/littlelang/tokenizer"
 )
 
@@ -24,6 +28,8 @@ type userFunction struct {
 	Ellipsis   bool
 	Body       parser.Block
 	Closure    map[string]Value
+	Defined    Position
+	Generator  bool
 }
 
 func ensureNumArgs(pos Position, name string, args []Value, required int) {
@@ -36,14 +42,190 @@ func ensureNumArgs(pos Position, name string, args []Value, required int) {
 	}
 }
 
+// Raise a type error saying which argument of a builtin had the wrong
+// type; index is 1-based and want is like "an int" or "a str or list"
+func argTypeError(pos Position, name string, index int, want string, arg Value) error {
+	return typeError(pos, "%s() argument %d must be %s, not %s", name, index, want, typeName(arg))
+}
+
+func ensureStr(pos Position, name string, index int, arg Value) string {
+	s, ok := arg.(string)
+	if !ok {
+		panic(argTypeError(pos, name, index, "a str", arg))
+	}
+	return s
+}
+
+func ensureFunction(pos Position, name string, index int, arg Value) functionType {
+	f, ok := arg.(functionType)
+	if !ok {
+		panic(argTypeError(pos, name, index, "a func", arg))
+	}
+	return f
+}
+
+// Return the function's signature, for example "add(a, b)" or
+// "func(x, rest...)" for an anonymous function
+func (f *userFunction) signature() string {
+	name := f.Name
+	if name == "" {
+		name = "func"
+	}
+	ellipsis := ""
+	if f.Ellipsis {
+		ellipsis = "..."
+	}
+	return fmt.Sprintf("%s(%s%s)", name, strings.Join(f.Parameters, ", "), ellipsis)
+}
+
+// Raise an error if the user function was called with the wrong number of
+// arguments, including the parameter list and where it was defined
+func (f *userFunction) ensureNumArgs(pos Position, args []Value) {
+	required := len(f.Parameters)
+	atLeast := ""
+	if f.Ellipsis {
+		required--
+		if len(args) >= required {
+			return
+		}
+		atLeast = "at least "
+	} else if len(args) == required {
+		return
+	}
+	plural := ""
+	if required != 1 {
+		plural = "s"
+	}
+	panic(typeError(pos, "%s requires %s%d arg%s, got %d (defined at %s)",
+		f.signature(), atLeast, required, plural, len(args), f.Defined))
+}
+
+// Return the arguments for calling f with the given positional and keyword
+// arguments, with each keyword value at the position of the parameter it
+// names. The ... parameter of a variadic function can't be given by name.
+func bindKeywords(pos Position, f functionType, args []Value, names []string, values []Value) []Value {
+	var params []string
+	var variadic string
+	var signature string
+	var defined Position
+	switch f := f.(type) {
+	case *userFunction:
+		params, signature, defined = f.Parameters, f.signature(), f.Defined
+		if f.Ellipsis {
+			params, variadic = params[:len(params)-1], params[len(params)-1]
+		}
+	case boundMethod:
+		// The receiver is given by the method call, not by name
+		params, signature, defined = f.function.Parameters[1:], f.function.signature(), f.function.Defined
+		if f.function.Ellipsis {
+			params, variadic = params[:len(params)-1], params[len(params)-1]
+		}
+	case *structType:
+		params, signature, defined = f.Fields, f.signature(), f.Defined
+	case builtinFunction:
+		if allowed, ok := builtinKeywords[f.Name]; ok {
+			return append(args, bindBuiltinKeywords(pos, f, allowed, names, values))
+		}
+		panic(typeError(pos, "%s doesn't accept keyword arguments", f.name()))
+	default:
+		panic(typeError(pos, "%s doesn't accept keyword arguments", f.name()))
+	}
+	bound := make([]Value, len(params))
+	given := make([]bool, len(params))
+	for i := 0; i < len(args) && i < len(params); i++ {
+		bound[i] = args[i]
+		given[i] = true
+	}
+	for i, name := range names {
+		index := -1
+		for j, param := range params {
+			if param == name {
+				index = j
+				break
+			}
+		}
+		switch {
+		case index < 0 && name == variadic:
+			panic(typeError(pos, "%s variadic parameter %s can't be passed by keyword (defined at %s)",
+				signature, name, defined))
+		case index < 0:
+			panic(typeError(pos, "%s has no parameter %s (defined at %s)",
+				signature, name, defined))
+		case given[index]:
+			panic(typeError(pos, "%s got multiple values for %s (defined at %s)",
+				signature, name, defined))
+		}
+		bound[index] = values[i]
+		given[index] = true
+	}
+	for i, param := range params {
+		if !given[i] {
+			panic(typeError(pos, "%s missing argument %s (defined at %s)",
+				signature, param, defined))
+		}
+	}
+	return bound
+}
+
+// Keyword arguments given to a builtin that accepts them, passed as the
+// builtin's last argument. Programs can't create values of this type, so
+// it can't be confused with a positional argument.
+type keywordArgs map[string]Value
+
+// Names of the keyword arguments accepted by builtins that take any
+var builtinKeywords = map[string][]string{
+	"print": {"sep", "end"},
+	"sort":  {"reverse"},
+}
+
+func bindBuiltinKeywords(pos Position, f builtinFunction, allowed []string, names []string, values []Value) keywordArgs {
+	keywords := make(keywordArgs, len(names))
+	for i, name := range names {
+		found := false
+		for _, a := range allowed {
+			if a == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			panic(typeError(pos, "%s has no parameter %s", f.name(), name))
+		}
+		keywords[name] = values[i]
+	}
+	return keywords
+}
+
+// Remove the keyword arguments from the end of args (if there are any) and
+// return them separately
+func splitKeywords(args []Value) ([]Value, keywordArgs) {
+	if len(args) > 0 {
+		if keywords, ok := args[len(args)-1].(keywordArgs); ok {
+			return args[:len(args)-1], keywords
+		}
+	}
+	return args, nil
+}
+
 func (f *userFunction) call(interp *interpreter, pos Position, args []Value) Value {
+	f.ensureNumArgs(pos, args)
 	if f.Ellipsis {
 		ellipsisArgs := args[len(f.Parameters)-1:]
 		newArgs := make([]Value, 0, len(f.Parameters)+1)
 		newArgs = append(newArgs, args[:len(f.Parameters)-1]...)
 		args = append(newArgs, Value(&ellipsisArgs))
 	}
-	ensureNumArgs(pos, f.Name, args, len(f.Parameters))
+	if f.Generator {
+		interp.stats.UserCalls++
+		return newGenerator(interp, f, pos, args)
+	}
+	f.execute(interp, args)
+	return Value(nil)
+}
+
+// Execute the function's body in a new scope with the given arguments
+// (after ... arguments have been collected into a list)
+func (f *userFunction) execute(interp *interpreter, args []Value) {
 	interp.pushScope(f.Closure)
 	defer interp.popScope()
 	interp.pushScope(make(map[string]Value))
@@ -53,7 +235,6 @@ func (f *userFunction) call(interp *interpreter, pos Position, args []Value) Val
 	}
 	interp.stats.UserCalls++
 	interp.executeBlock(f.Body)
-	return Value(nil)
 }
 
 func (f *userFunction) name() string {
@@ -78,36 +259,178 @@ func (f builtinFunction) name() string {
 }
 
 var builtins = map[string]builtinFunction{
-	"append": {appendFunc, "append"},
-	"args":   {argsFunc, "args"},
-	"char":   {charFunc, "char"},
-	"exit":   {exitFunc, "exit"},
-	"find":   {findFunc, "find"},
-	"int":    {intFunc, "int"},
-	"join":   {joinFunc, "join"},
-	"len":    {lenFunc, "len"},
-	"lower":  {lowerFunc, "lower"},
-	"print":  {printFunc, "print"},
-	"range":  {rangeFunc, "range"},
-	"read":   {readFunc, "read"},
-	"rune":   {runeFunc, "rune"},
-	"slice":  {sliceFunc, "slice"},
-	"sort":   {sortFunc, "sort"},
-	"split":  {splitFunc, "split"},
-	"str":    {strFunc, "str"},
-	"type":   {typeFunc, "type"},
-	"upper":  {upperFunc, "upper"},
+	"abs":        {absFunc, "abs"},
+	"append":     {appendFunc, "append"},
+	"appendfile": {appendfileFunc, "appendfile"},
+	"args":       {argsFunc, "args"},
+	"assert":     {assertFunc, "assert"},
+	"bool":       {boolFunc, "bool"},
+	"bytes":      {bytesFunc, "bytes"},
+	"casefold":   {casefoldFunc, "casefold"},
+	"ceil":       {ceilFunc, "ceil"},
+	"chan":       {chanFunc, "chan"},
+	"char":       {charFunc, "char"},
+	"chars":      {charsFunc, "chars"},
+	"csv":        {csvFunc, "csv"},
+	"decode":     {decodeFunc, "decode"},
+	"delete":     {deleteFunc, "delete"},
+	"exists":     {existsFunc, "exists"},
+	"exit":       {exitFunc, "exit"},
+	"filter":     {filterFunc, "filter"},
+	"find":       {findFunc, "find"},
+	"float":      {floatFunc, "float"},
+	"floor":      {floorFunc, "floor"},
+	"format":     {formatFunc, "format"},
+	"formattime": {formattimeFunc, "formattime"},
+	"funcinfo":   {funcinfoFunc, "funcinfo"},
+	"globals":    {globalsFunc, "globals"},
+	"gunzip":     {gunzipFunc, "gunzip"},
+	"gzip":       {gzipFunc, "gzip"},
+	"hex":        {hexFunc, "hex"},
+	"insert":     {insertFunc, "insert"},
+	"int":        {intFunc, "int"},
+	"intersect":  {intersectFunc, "intersect"},
+	"join":       {joinFunc, "join"},
+	"keys":       {keysFunc, "keys"},
+	"len":        {lenFunc, "len"},
+	"listdir":    {listdirFunc, "listdir"},
+	"locals":     {localsFunc, "locals"},
+	"lock":       {lockFunc, "lock"},
+	"lower":      {lowerFunc, "lower"},
+	"map":        {mapFunc, "map"},
+	"max":        {maxFunc, "max"},
+	"min":        {minFunc, "min"},
+	"mkdir":      {mkdirFunc, "mkdir"},
+	"now":        {nowFunc, "now"},
+	"parsecsv":   {parsecsvFunc, "parsecsv"},
+	"parsequery": {parsequeryFunc, "parsequery"},
+	"parsetime":  {parsetimeFunc, "parsetime"},
+	"pop":        {popFunc, "pop"},
+	"pow":        {powFunc, "pow"},
+	"print":      {printFunc, "print"},
+	"range":      {rangeFunc, "range"},
+	"read":       {readFunc, "read"},
+	"readbytes":  {readbytesFunc, "readbytes"},
+	"readline":   {readlineFunc, "readline"},
+	"recv":       {recvFunc, "recv"},
+	"reduce":     {reduceFunc, "reduce"},
+	"remove":     {removeFunc, "remove"},
+	"replace":    {replaceFunc, "replace"},
+	"repr":       {reprFunc, "repr"},
+	"round":      {roundFunc, "round"},
+	"rune":       {runeFunc, "rune"},
+	"runelen":    {runelenFunc, "runelen"},
+	"send":       {sendFunc, "send"},
+	"set":        {setFunc, "set"},
+	"slice":      {sliceFunc, "slice"},
+	"sort":       {sortFunc, "sort"},
+	"spawn":      {spawnFunc, "spawn"},
+	"split":      {splitFunc, "split"},
+	"splitlines": {splitlinesFunc, "splitlines"},
+	"sqrt":       {sqrtFunc, "sqrt"},
+	"stat":       {statFunc, "stat"},
+	"str":        {strFunc, "str"},
+	"sum":        {sumFunc, "sum"},
+	"throw":      {throwFunc, "throw"},
+	"trap":       {trapFunc, "trap"},
+	"type":       {typeFunc, "type"},
+	"unhex":      {unhexFunc, "unhex"},
+	"union":      {unionFunc, "union"},
+	"unique":     {uniqueFunc, "unique"},
+	"unlock":     {unlockFunc, "unlock"},
+	"upper":      {upperFunc, "upper"},
+	"urldecode":  {urldecodeFunc, "urldecode"},
+	"urlencode":  {urlencodeFunc, "urlencode"},
+	"urlparse":   {urlparseFunc, "urlparse"},
+	"urlquery":   {urlqueryFunc, "urlquery"},
+	"withlock":   {withlockFunc, "withlock"},
+	"write":      {writeFunc, "write"},
+}
+
+func init() {
+	// include() runs code that looks up builtins, so it's added here to
+	// avoid an initialization cycle
+	builtins["include"] = builtinFunction{includeFunc, "include"}
+}
+
+// Builtins that can be called as methods of each type with dot syntax,
+// keyed by type name. A call like s.upper() calls the builtin with the
+// value as its first argument, so it's the same as upper(s).
+var methods = map[string]map[string]bool{
+	"str":   setOf("bytes", "casefold", "chars", "find", "float", "format", "hex", "int", "len", "lower", "replace", "rune", "runelen", "slice", "split", "splitlines", "unhex", "upper"),
+	"bytes": setOf("decode", "gunzip", "gzip", "hex", "len"),
+	"list":  setOf("append", "delete", "find", "insert", "join", "len", "max", "min", "pop", "slice", "sort", "sum", "unique"),
+	"map":   setOf("delete", "keys", "len"),
+	"set":   setOf("append", "intersect", "len", "max", "min", "sum", "union"),
+}
+
+func setOf(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// Report whether name is a method of value's type. A map's keys take
+// precedence over its methods, so m.len() calls the function in m["len"]
+// if there is one.
+func hasMethod(value Value, name string) bool {
+	switch v := value.(type) {
+	case *record:
+		return false
+	case map[string]Value:
+		if _, ok := v[name]; ok {
+			return false
+		}
+	}
+	return methods[typeName(value)][name]
+}
+
+// Wrap a BuiltinFunc from Config.Builtins as a builtinFunction
+func externalBuiltin(name string, f BuiltinFunc) builtinFunction {
+	function := func(interp *interpreter, pos Position, args []Value) Value {
+		result, err := f(args)
+		if err != nil {
+			panic(runtimeError(pos, "%s() error: %v", name, err))
+		}
+		return result
+	}
+	return builtinFunction{function, name}
+}
+
+// Builtins returns the names of the builtin functions in sorted order.
+func Builtins() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func appendFunc(interp *interpreter, pos Position, args []Value) Value {
 	if len(args) < 1 {
 		panic(typeError(pos, "append() requires at least 1 arg, got %d", len(args)))
 	}
-	if list, ok := args[0].(*[]Value); ok {
-		*list = append(*list, args[1:]...)
+	switch container := args[0].(type) {
+	case *[]Value:
+		if len(args) > 1 {
+			*container = append(*container, args[1:]...)
+			interp.modified(container)
+		}
+		return Value(nil)
+	case *set:
+		size := len(container.elems)
+		for _, v := range args[1:] {
+			container.add(pos, v)
+		}
+		if len(container.elems) != size {
+			interp.modified(container)
+		}
 		return Value(nil)
 	}
-	panic(typeError(pos, "append() requires first argument to be list"))
+	panic(argTypeError(pos, "append", 1, "a list or set", args[0]))
 }
 
 func stringsToList(strings []string) Value {
@@ -123,12 +446,110 @@ func argsFunc(interp *interpreter, pos Position, args []Value) Value {
 	return stringsToList(interp.args)
 }
 
+// Report whether v is "truthy": everything is true except nil, false, zero
+// numbers, and empty strs, bytes, lists, maps, and sets
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case byteString:
+		return v != ""
+	case *[]Value:
+		return len(*v) != 0
+	case map[string]Value:
+		return len(v) != 0
+	case *set:
+		return len(v.elems) != 0
+	default:
+		return true
+	}
+}
+
+func assertFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "assert() requires 1 or 2 args, got %d", len(args)))
+	}
+	var ok bool
+	if interp.truthy {
+		ok = truthy(args[0])
+	} else {
+		b, isBool := args[0].(bool)
+		if !isBool {
+			panic(argTypeError(pos, "assert", 1, "a bool", args[0]))
+		}
+		ok = b
+	}
+	message := "assertion failed"
+	if len(args) == 2 {
+		message = ensureStr(pos, "assert", 2, args[1])
+	}
+	if !ok {
+		panic(AssertionError{message, pos, nil})
+	}
+	return Value(nil)
+}
+
+func boolFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "bool", args, 1)
+	return Value(truthy(args[0]))
+}
+
 func charFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "char", args, 1)
 	if code, ok := args[0].(int); ok {
-		return string(code)
+		return string(rune(code))
 	}
-	panic(typeError(pos, "char() requires an int, not %s", typeName(args[0])))
+	panic(argTypeError(pos, "char", 1, "an int", args[0]))
+}
+
+func charsFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "chars", args, 1)
+	str, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "chars", 1, "a str", args[0]))
+	}
+	chars := []string{}
+	for _, r := range str {
+		chars = append(chars, string(r))
+	}
+	return stringsToList(chars)
+}
+
+func deleteFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "delete", args, 2)
+	switch container := args[0].(type) {
+	case map[string]Value:
+		key, ok := args[1].(string)
+		if !ok {
+			panic(argTypeError(pos, "delete", 2, "a str", args[1]))
+		}
+		if _, ok := container[key]; !ok {
+			panic(valueError(pos, "key not found: %q", key))
+		}
+		delete(container, key)
+		interp.modified(container)
+		return Value(nil)
+	case *[]Value:
+		index, ok := args[1].(int)
+		if !ok {
+			panic(argTypeError(pos, "delete", 2, "an int", args[1]))
+		}
+		if index < 0 || index >= len(*container) {
+			panic(valueError(pos, "subscript %d out of range", index))
+		}
+		*container = append((*container)[:index], (*container)[index+1:]...)
+		interp.modified(container)
+		return Value(nil)
+	}
+	panic(argTypeError(pos, "delete", 1, "a list or map", args[0]))
 }
 
 func exitFunc(interp *interpreter, pos Position, args []Value) Value {
@@ -139,14 +560,46 @@ func exitFunc(interp *interpreter, pos Position, args []Value) Value {
 	if len(args) > 0 {
 		arg, ok := args[0].(int)
 		if !ok {
-			panic(typeError(pos, "exit() requires an int, not %s", typeName(args[0])))
+			panic(argTypeError(pos, "exit", 1, "an int", args[0]))
 		}
 		code = arg
 	}
-	interp.exit(code)
+	interp.exitProgram(pos, code)
 	return Value(nil)
 }
 
+// Exit the program with the given status, or stop it with an ExitError if
+// Config.ReturnExit is set
+func (interp *interpreter) exitProgram(pos Position, status int) {
+	if interp.returnExit {
+		panic(ExitError{status, pos, nil})
+	}
+	interp.closeGenerators()
+	interp.exit(status)
+}
+
+func filterFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "filter", args, 2)
+	f := ensureFunction(pos, "filter", 1, args[0])
+	values := []Value{}
+	iterator, done := interp.loopIterator(pos, args[1])
+	defer done()
+	for iterator.HasNext() {
+		v := iterator.Value()
+		result := interp.callFunction(pos, f, []Value{v})
+		keep, ok := result.(bool)
+		if interp.truthy {
+			keep = truthy(result)
+		} else if !ok {
+			panic(typeError(pos, "filter() function must return a bool, not %s", typeName(result)))
+		}
+		if keep {
+			values = append(values, v)
+		}
+	}
+	return Value(&values)
+}
+
 func findFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "find", args, 2)
 	switch haystack := args[0].(type) {
@@ -154,7 +607,7 @@ func findFunc(interp *interpreter, pos Position, args []Value) Value {
 		if needle, ok := args[1].(string); ok {
 			return Value(strings.Index(haystack, needle))
 		}
-		panic(typeError(pos, "find() on str requires second argument to be a str"))
+		panic(argTypeError(pos, "find", 2, "a str", args[1]))
 	case *[]Value:
 		needle := args[1]
 		for i, v := range *haystack {
@@ -164,8 +617,145 @@ func findFunc(interp *interpreter, pos Position, args []Value) Value {
 		}
 		return Value(-1)
 	default:
-		panic(typeError(pos, "find() requires first argument to be a str or list"))
+		panic(argTypeError(pos, "find", 1, "a str or list", args[0]))
+	}
+}
+
+func floatFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "float", args, 1)
+	switch arg := args[0].(type) {
+	case int:
+		return Value(float64(arg))
+	case float64:
+		return args[0]
+	case string:
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return Value(nil)
+		}
+		return Value(f)
+	default:
+		panic(argTypeError(pos, "float", 1, "a number or str", args[0]))
+	}
+}
+
+func funcinfoFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "funcinfo", args, 1)
+	var name Value
+	var params []string
+	variadic, builtin := false, false
+	switch f := args[0].(type) {
+	case *userFunction:
+		if f.Name != "" {
+			name = f.Name
+		}
+		params, variadic = f.Parameters, f.Ellipsis
+	case boundMethod:
+		// The receiver is already bound, so it's not a parameter
+		name, params, variadic = f.function.Name, f.function.Parameters[1:], f.function.Ellipsis
+	case *structType:
+		name, params = f.Name, f.Fields
+	case builtinFunction:
+		name, builtin = f.Name, true
+	case nativeFunction:
+		name, builtin = f.Name, true
+	default:
+		panic(argTypeError(pos, "funcinfo", 1, "a func", args[0]))
+	}
+	info := map[string]Value{
+		"name":     name,
+		"params":   nil,
+		"variadic": nil,
+		"builtin":  builtin,
+	}
+	if !builtin {
+		// Builtins don't have named parameters, and their arity isn't
+		// known, so params and variadic are nil for them
+		info["params"] = stringsToList(params)
+		info["variadic"] = variadic
+	}
+	return Value(info)
+}
+
+// Return a copy of the given scope as a map, leaving out the builtin
+// functions (unless the program has assigned something else to the name)
+func (interp *interpreter) scopeMap(scope map[string]Value) Value {
+	m := make(map[string]Value, len(scope))
+	for name, v := range scope {
+		if f, ok := v.(builtinFunction); ok && f.Name == name && interp.builtins[name] {
+			continue
+		}
+		m[name] = v
+	}
+	return Value(m)
+}
+
+func globalsFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "globals", args, 0)
+	return interp.scopeMap(interp.vars[0])
+}
+
+func includeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "include", args, 1)
+	path := ensureStr(pos, "include", 1, args[0])
+	var source []byte
+	filename := path
+	ok := false
+	if strings.HasPrefix(path, "std/") {
+		source, ok = std.Source(strings.TrimPrefix(path, "std/"))
+	}
+	if !ok {
+		interp.ensureFS(pos, "include")
+		var err error
+		source, filename, err = interp.readInclude(path)
+		if err != nil {
+			panic(runtimeError(pos, "include() error: %v", err))
+		}
+		if interp.included != nil {
+			interp.included(filename)
+		}
+	}
+	// Positions in the included file (and so errors) give its filename
+	prog, err := parser.ParseFile(filename, source)
+	if err != nil {
+		panic(runtimeError(pos, "include() error: %v", err))
+	}
+
+	// Run the included program in the global scope, even when include()
+	// is called from inside a function
+	vars := interp.vars
+	interp.vars = []map[string]Value{vars[0]}
+	defer func() {
+		interp.vars = vars
+		if r := recover(); r != nil {
+			if result, ok := r.(returnResult); ok {
+				panic(runtimeError(result.pos, "can't return at top level"))
+			}
+			panic(r)
+		}
+	}()
+	interp.execute(prog)
+	return Value(nil)
+}
+
+func insertFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "insert", args, 3)
+	list, ok := args[0].(*[]Value)
+	if !ok {
+		panic(argTypeError(pos, "insert", 1, "a list", args[0]))
 	}
+	index, ok := args[1].(int)
+	if !ok {
+		panic(argTypeError(pos, "insert", 2, "an int", args[1]))
+	}
+	if index < 0 || index > len(*list) {
+		panic(valueError(pos, "subscript %d out of range", index))
+	}
+	*list = append(*list, nil)
+	copy((*list)[index+1:], (*list)[index:])
+	(*list)[index] = args[2]
+	interp.modified(list)
+	return Value(nil)
 }
 
 func intFunc(interp *interpreter, pos Position, args []Value) Value {
@@ -173,6 +763,12 @@ func intFunc(interp *interpreter, pos Position, args []Value) Value {
 	switch arg := args[0].(type) {
 	case int:
 		return args[0]
+	case float64:
+		// Truncate toward zero, but only if the result fits in an int
+		if math.IsNaN(arg) || arg >= math.MaxInt64 || arg < math.MinInt64 {
+			panic(valueError(pos, "int() argument %s out of range", formatFloat(arg)))
+		}
+		return Value(int(arg))
 	case string:
 		i, err := strconv.Atoi(arg)
 		if err != nil {
@@ -180,7 +776,7 @@ func intFunc(interp *interpreter, pos Position, args []Value) Value {
 		}
 		return Value(i)
 	default:
-		panic(typeError(pos, "int() requires an int or a str"))
+		panic(argTypeError(pos, "int", 1, "a number or str", args[0]))
 	}
 }
 
@@ -188,7 +784,7 @@ func joinFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "join", args, 2)
 	sep, ok := args[1].(string)
 	if !ok {
-		panic(typeError(pos, "join() requires separator to be a str"))
+		panic(argTypeError(pos, "join", 2, "a str", args[1]))
 	}
 	if list, ok := args[0].(*[]Value); ok {
 		strs := make([]string, len(*list))
@@ -202,7 +798,21 @@ func joinFunc(interp *interpreter, pos Position, args []Value) Value {
 		joined := strings.Join(strs, sep)
 		return Value(joined)
 	}
-	panic(typeError(pos, "join() requires first argument to be a list"))
+	panic(argTypeError(pos, "join", 1, "a list", args[0]))
+}
+
+func keysFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "keys", args, 1)
+	m, ok := args[0].(map[string]Value)
+	if !ok {
+		panic(argTypeError(pos, "keys", 1, "a map", args[0]))
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return stringsToList(keys)
 }
 
 func lenFunc(interp *interpreter, pos Position, args []Value) Value {
@@ -211,69 +821,302 @@ func lenFunc(interp *interpreter, pos Position, args []Value) Value {
 	switch arg := args[0].(type) {
 	case string:
 		length = len(arg)
+	case byteString:
+		length = len(arg)
 	case *[]Value:
 		length = len(*arg)
 	case map[string]Value:
 		length = len(arg)
+	case *set:
+		length = len(arg.elems)
 	default:
-		panic(typeError(pos, "len() requires a str, list, or map"))
+		panic(argTypeError(pos, "len", 1, "a str, bytes, list, map, or set", args[0]))
 	}
 	return Value(length)
 }
 
+func localsFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "locals", args, 0)
+	return interp.scopeMap(interp.vars[len(interp.vars)-1])
+}
+
 func lowerFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "lower", args, 1)
 	if s, ok := args[0].(string); ok {
 		return Value(strings.ToLower(s))
 	}
-	panic(typeError(pos, "lower() requires a str"))
+	panic(argTypeError(pos, "lower", 1, "a str", args[0]))
+}
+
+func mapFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "map", args, 2)
+	f := ensureFunction(pos, "map", 1, args[0])
+	values := []Value{}
+	iterator, done := interp.loopIterator(pos, args[1])
+	defer done()
+	for iterator.HasNext() {
+		values = append(values, interp.callFunction(pos, f, []Value{iterator.Value()}))
+	}
+	return Value(&values)
+}
+
+// Return the smallest (or largest) of args using the same comparison as
+// sort(), or of the elements of args[0] if it's the only argument
+func minMax(interp *interpreter, pos Position, name string, args []Value, largest bool) Value {
+	if len(args) < 1 {
+		panic(typeError(pos, "%s() requires at least 1 arg, got %d", name, len(args)))
+	}
+	values := args
+	if len(args) == 1 {
+		values = nil
+		iterator := interp.getIterator(pos, args[0])
+		for iterator.HasNext() {
+			values = append(values, iterator.Value())
+		}
+		if len(values) == 0 {
+			panic(valueError(pos, "%s() of empty iterable", name))
+		}
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		l, r := v, result
+		if largest {
+			l, r = result, v
+		}
+		if evalLess(pos, l, r).(bool) {
+			result = v
+		}
+	}
+	return result
+}
+
+func maxFunc(interp *interpreter, pos Position, args []Value) Value {
+	return minMax(interp, pos, "max", args, true)
+}
+
+func minFunc(interp *interpreter, pos Position, args []Value) Value {
+	return minMax(interp, pos, "min", args, false)
+}
+
+func popFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "pop() requires 1 or 2 args, got %d", len(args)))
+	}
+	list, ok := args[0].(*[]Value)
+	if !ok {
+		panic(argTypeError(pos, "pop", 1, "a list", args[0]))
+	}
+	if len(*list) == 0 {
+		panic(valueError(pos, "can't pop from empty list"))
+	}
+	index := len(*list) - 1
+	if len(args) == 2 {
+		index, ok = args[1].(int)
+		if !ok {
+			panic(argTypeError(pos, "pop", 2, "an int", args[1]))
+		}
+		if index < 0 || index >= len(*list) {
+			panic(valueError(pos, "subscript %d out of range", index))
+		}
+	}
+	value := (*list)[index]
+	*list = append((*list)[:index], (*list)[index+1:]...)
+	interp.modified(list)
+	return value
 }
 
 func printFunc(interp *interpreter, pos Position, args []Value) Value {
-	strs := make([]interface{}, len(args))
+	args, keywords := splitKeywords(args)
+	sep, end := " ", "\n"
+	if v, ok := keywords["sep"]; ok {
+		sep, ok = v.(string)
+		if !ok {
+			panic(typeError(pos, "print() sep must be a str, not %s", typeName(v)))
+		}
+	}
+	if v, ok := keywords["end"]; ok {
+		end, ok = v.(string)
+		if !ok {
+			panic(typeError(pos, "print() end must be a str, not %s", typeName(v)))
+		}
+	}
+	strs := make([]string, len(args))
 	for i, a := range args {
-		strs[i] = toString(a, false)
+		strs[i] = valueString(a, false, nil, interp.strHook(pos))
 	}
-	fmt.Fprintln(interp.stdout, strs...)
+	io.WriteString(interp.stdout, strings.Join(strs, sep)+end)
 	return Value(nil)
 }
 
 func rangeFunc(interp *interpreter, pos Position, args []Value) Value {
-	ensureNumArgs(pos, "range", args, 1)
-	if n, ok := args[0].(int); ok {
-		if n < 0 {
+	if len(args) < 1 || len(args) > 3 {
+		panic(typeError(pos, "range() requires 1 to 3 args, got %d", len(args)))
+	}
+	ints := make([]int, len(args))
+	for i, arg := range args {
+		n, ok := arg.(int)
+		if !ok {
+			panic(argTypeError(pos, "range", i+1, "an int", arg))
+		}
+		ints[i] = n
+	}
+	start, stop, step := 0, ints[0], 1
+	if len(ints) == 1 {
+		if stop < 0 {
 			panic(valueError(pos, "range() argument must not be negative"))
 		}
-		nums := make([]Value, n)
-		for i := 0; i < n; i++ {
-			nums[i] = i
+	} else {
+		start, stop = ints[0], ints[1]
+	}
+	if len(ints) == 3 {
+		step = ints[2]
+		if step == 0 {
+			panic(valueError(pos, "range() step must not be zero"))
 		}
-		return Value(&nums)
 	}
-	panic(typeError(pos, "range() requires an int"))
+	n := 0
+	if step > 0 && start < stop {
+		n = (stop-start-1)/step + 1
+	} else if step < 0 && start > stop {
+		n = (start-stop-1)/-step + 1
+	}
+	nums := make([]Value, n)
+	for i := 0; i < n; i++ {
+		nums[i] = start + i*step
+	}
+	return Value(&nums)
+}
+
+// Raise an error if the sandbox doesn't allow filesystem access
+func (interp *interpreter) ensureFS(pos Position, name string) {
+	if interp.sandbox.NoFS {
+		panic(runtimeError(pos, "%s() can't access the filesystem in sandbox mode", name))
+	}
 }
 
 func readFunc(interp *interpreter, pos Position, args []Value) Value {
-	if len(args) > 1 {
-		panic(typeError(pos, "read() requires 0 or 1 args, got %d", len(args)))
+	if len(args) > 2 {
+		panic(typeError(pos, "read() requires 0 to 2 args, got %d", len(args)))
 	}
-	var b []byte
-	var err error
-	if len(args) == 0 {
-		b, err = ioutil.ReadAll(interp.stdin)
-	} else {
-		filename, ok := args[0].(string)
+	var filename string
+	hasFilename := false
+	var limitArg Value
+	if len(args) == 1 {
+		switch arg := args[0].(type) {
+		case string:
+			filename, hasFilename = arg, true
+		case int:
+			limitArg = arg
+		default:
+			panic(argTypeError(pos, "read", 1, "a str or int", arg))
+		}
+	} else if len(args) == 2 {
+		filename, hasFilename = ensureStr(pos, "read", 1, args[0]), true
+		limitArg = args[1]
+	}
+	limit := -1
+	if limitArg != nil {
+		n, ok := limitArg.(int)
 		if !ok {
-			panic(typeError(pos, "read() argument must be a str"))
+			panic(argTypeError(pos, "read", len(args), "an int", limitArg))
+		}
+		if n < 0 {
+			panic(valueError(pos, "read() byte limit must not be negative"))
 		}
-		b, err = ioutil.ReadFile(filename)
+		limit = n
 	}
+	if hasFilename {
+		interp.ensureFS(pos, "read")
+	}
+
+	var b []byte
+	var err error
+	interp.blockingRead(func() {
+		switch {
+		case hasFilename && limit >= 0:
+			b, err = interp.readFileChunk(filename, limit)
+		case hasFilename:
+			b, err = interp.readFile(filename)
+		case limit >= 0:
+			b, err = readChunk(interp.stdin, limit)
+		default:
+			b, err = ioutil.ReadAll(interp.stdin)
+		}
+	})
 	if err != nil {
 		panic(runtimeError(pos, "read() error: %v", err))
 	}
 	return Value(string(b))
 }
 
+func readlineFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "readline", args, 0)
+	var line string
+	var err error
+	interp.blockingRead(func() {
+		line, err = interp.stdin.ReadString('\n')
+	})
+	if err == io.EOF {
+		if line == "" {
+			return Value(nil)
+		}
+	} else if err != nil {
+		panic(runtimeError(pos, "readline() error: %v", err))
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return Value(line)
+}
+
+func reduceFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 2 && len(args) != 3 {
+		panic(typeError(pos, "reduce() requires 2 or 3 args, got %d", len(args)))
+	}
+	f := ensureFunction(pos, "reduce", 1, args[0])
+	iterator, done := interp.loopIterator(pos, args[1])
+	defer done()
+	var acc Value
+	if len(args) == 3 {
+		acc = args[2]
+	} else if iterator.HasNext() {
+		acc = iterator.Value()
+	} else {
+		panic(valueError(pos, "reduce() of empty iterable requires an initial value"))
+	}
+	for iterator.HasNext() {
+		acc = interp.callFunction(pos, f, []Value{acc, iterator.Value()})
+	}
+	return acc
+}
+
+func replaceFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 3 && len(args) != 4 {
+		panic(typeError(pos, "replace() requires 3 or 4 args, got %d", len(args)))
+	}
+	strs := make([]string, 3)
+	for i := range strs {
+		s, ok := args[i].(string)
+		if !ok {
+			panic(argTypeError(pos, "replace", i+1, "a str", args[i]))
+		}
+		strs[i] = s
+	}
+	count := -1
+	if len(args) == 4 {
+		n, ok := args[3].(int)
+		if !ok {
+			panic(argTypeError(pos, "replace", 4, "an int", args[3]))
+		}
+		count = n
+	}
+	return Value(strings.Replace(strs[0], strs[1], strs[2], count))
+}
+
+func reprFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "repr", args, 1)
+	return Value(valueString(args[0], true, nil, interp.strHook(pos)))
+}
+
 func runeFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "rune", args, 1)
 	if s, ok := args[0].(string); ok {
@@ -283,15 +1126,26 @@ func runeFunc(interp *interpreter, pos Position, args []Value) Value {
 		}
 		return Value(int(runes[0]))
 	}
-	panic(typeError(pos, "rune() requires a str"))
+	panic(argTypeError(pos, "rune", 1, "a str", args[0]))
+}
+
+func runelenFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "runelen", args, 1)
+	if s, ok := args[0].(string); ok {
+		return Value(utf8.RuneCountInString(s))
+	}
+	panic(argTypeError(pos, "runelen", 1, "a str", args[0]))
 }
 
 func sliceFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "slice", args, 3)
-	start, sok := args[1].(int)
-	end, eok := args[2].(int)
-	if !sok || !eok {
-		panic(typeError(pos, "slice() requires start and end to be ints"))
+	start, ok := args[1].(int)
+	if !ok {
+		panic(argTypeError(pos, "slice", 2, "an int", args[1]))
+	}
+	end, ok := args[2].(int)
+	if !ok {
+		panic(argTypeError(pos, "slice", 3, "an int", args[2]))
 	}
 	switch s := args[0].(type) {
 	case string:
@@ -307,30 +1161,56 @@ func sliceFunc(interp *interpreter, pos Position, args []Value) Value {
 		copy(result, (*s)[start:end])
 		return Value(&result)
 	default:
-		panic(typeError(pos, "slice() requires first argument to be a str or list"))
+		panic(argTypeError(pos, "slice", 1, "a str or list", args[0]))
 	}
 }
 
 func sortFunc(interp *interpreter, pos Position, args []Value) Value {
-	if len(args) != 1 && len(args) != 2 {
-		panic(typeError(pos, "sort() requires 1 or 2 args, got %d", len(args)))
+	args, keywords := splitKeywords(args)
+	if len(args) < 1 || len(args) > 3 {
+		panic(typeError(pos, "sort() requires 1 to 3 args, got %d", len(args)))
 	}
 	list, ok := args[0].(*[]Value)
 	if !ok {
-		panic(typeError(pos, "sort() requires first argument to be a list"))
+		panic(argTypeError(pos, "sort", 1, "a list", args[0]))
+	}
+	var keyFunc functionType
+	if len(args) >= 2 && args[1] != nil {
+		keyFunc = ensureFunction(pos, "sort", 2, args[1])
+	}
+	reverse := false
+	if len(args) == 3 {
+		reverse, ok = args[2].(bool)
+		if !ok {
+			panic(argTypeError(pos, "sort", 3, "a bool", args[2]))
+		}
+	}
+	if v, ok := keywords["reverse"]; ok {
+		if len(args) == 3 {
+			panic(typeError(pos, "sort() got multiple values for reverse"))
+		}
+		reverse, ok = v.(bool)
+		if !ok {
+			panic(typeError(pos, "sort() reverse must be a bool, not %s", typeName(v)))
+		}
 	}
 	if len(*list) <= 1 {
 		return Value(nil)
 	}
-	if len(args) == 1 {
+	interp.modified(list)
+	// Swap the arguments to evalLess to sort in reverse order, so that
+	// equal elements still stay in their original order
+	less := func(l, r Value) bool {
+		if reverse {
+			l, r = r, l
+		}
+		return evalLess(pos, l, r).(bool)
+	}
+	if keyFunc == nil {
 		sort.SliceStable(*list, func(i, j int) bool {
-			return evalLess(pos, (*list)[i], (*list)[j]).(bool)
+			return less((*list)[i], (*list)[j])
 		})
 	} else {
-		keyFunc, ok := args[1].(functionType)
-		if !ok {
-			panic(typeError(pos, "sort() requires second argument to be a function"))
-		}
 		// Decorate, sort, undecorate (so we only call key function
 		// once per element)
 		type pair struct {
@@ -343,7 +1223,7 @@ func sortFunc(interp *interpreter, pos Position, args []Value) Value {
 			pairs[i] = pair{v, key}
 		}
 		sort.SliceStable(pairs, func(i, j int) bool {
-			return evalLess(pos, pairs[i].key, pairs[j].key).(bool)
+			return less(pairs[i].key, pairs[j].key)
 		})
 		values := make([]Value, len(pairs))
 		for i, p := range pairs {
@@ -360,7 +1240,7 @@ func splitFunc(interp *interpreter, pos Position, args []Value) Value {
 	}
 	str, ok := args[0].(string)
 	if !ok {
-		panic(typeError(pos, "split() requires first argument to be a str"))
+		panic(argTypeError(pos, "split", 1, "a str", args[0]))
 	}
 	var parts []string
 	if len(args) == 1 || args[1] == nil {
@@ -368,12 +1248,48 @@ func splitFunc(interp *interpreter, pos Position, args []Value) Value {
 	} else if sep, ok := args[1].(string); ok {
 		parts = strings.Split(str, sep)
 	} else {
-		panic(typeError(pos, "split() requires separator to be a str or nil"))
+		panic(argTypeError(pos, "split", 2, "a str or nil", args[1]))
 	}
 	return stringsToList(parts)
 }
 
+func splitlinesFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "splitlines", args, 1)
+	str, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "splitlines", 1, "a str", args[0]))
+	}
+	lines := []string{}
+	start := 0
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '\r':
+			lines = append(lines, str[start:i])
+			if i+1 < len(str) && str[i+1] == '\n' {
+				i++
+			}
+			start = i + 1
+		case '\n':
+			lines = append(lines, str[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(str) {
+		lines = append(lines, str[start:])
+	}
+	return stringsToList(lines)
+}
+
 func toString(value Value, quoteStr bool) string {
+	return valueString(value, quoteStr, nil, nil)
+}
+
+// Return the string representation of value. Lists and maps that are
+// already being converted (that is, that contain themselves) are shown as
+// [...] or {...} to avoid infinite recursion. If strHook is non-nil, it's
+// called for each map, and if it returns ok, its result is used as the
+// map's string.
+func valueString(value Value, quoteStr bool, converting map[uintptr]bool, strHook func(map[string]Value) (string, bool)) string {
 	var s string
 	switch v := value.(type) {
 	case nil:
@@ -386,6 +1302,8 @@ func toString(value Value, quoteStr bool) string {
 		}
 	case int:
 		s = fmt.Sprintf("%d", v)
+	case float64:
+		s = formatFloat(v)
 	case string:
 		if quoteStr {
 			s = fmt.Sprintf("%q", v)
@@ -393,21 +1311,67 @@ func toString(value Value, quoteStr bool) string {
 			s = v
 		}
 	case *[]Value:
+		id := containerID(v)
+		if converting[id] {
+			return "[...]"
+		}
+		if converting == nil {
+			converting = make(map[uintptr]bool)
+		}
+		converting[id] = true
+		defer delete(converting, id)
 		strs := make([]string, len(*v))
 		for i, v := range *v {
-			strs[i] = toString(v, true)
+			strs[i] = valueString(v, true, converting, strHook)
 		}
 		s = fmt.Sprintf("[%s]", strings.Join(strs, ", "))
 	case map[string]Value:
+		if strHook != nil {
+			if s, ok := strHook(v); ok {
+				return s
+			}
+		}
+		id := containerID(v)
+		if converting[id] {
+			return "{...}"
+		}
+		if converting == nil {
+			converting = make(map[uintptr]bool)
+		}
+		converting[id] = true
+		defer delete(converting, id)
 		strs := make([]string, 0, len(v))
 		for k, v := range v {
-			item := fmt.Sprintf("%q: %s", k, toString(v, true))
+			item := fmt.Sprintf("%q: %s", k, valueString(v, true, converting, strHook))
 			strs = append(strs, item)
 		}
 		sort.Strings(strs) // Ensure str(output) is consistent
 		s = fmt.Sprintf("{%s}", strings.Join(strs, ", "))
+	case *record:
+		id := containerID(v)
+		if converting[id] {
+			return v.typ.Name + "(...)"
+		}
+		if converting == nil {
+			converting = make(map[uintptr]bool)
+		}
+		converting[id] = true
+		defer delete(converting, id)
+		strs := make([]string, len(v.typ.Fields))
+		for i, field := range v.typ.Fields {
+			strs[i] = fmt.Sprintf("%s=%s", field, valueString(v.fields[field], true, converting, strHook))
+		}
+		s = fmt.Sprintf("%s(%s)", v.typ.Name, strings.Join(strs, ", "))
 	case functionType:
 		s = v.name()
+	case *generator:
+		s = v.name()
+	case channel:
+		s = "<chan>"
+	case *set:
+		s = v.String()
+	case byteString:
+		s = v.String()
 	default:
 		// Interpreter should never give us this
 		panic(fmt.Sprintf("str() got unexpected type %T", v))
@@ -415,20 +1379,46 @@ func toString(value Value, quoteStr bool) string {
 	return s
 }
 
+// Format a float in the shortest form that round-trips, but ensure it
+// doesn't look like an int (1.0 rather than 1)
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 func strFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "str", args, 1)
-	return Value(toString(args[0], false))
+	return Value(valueString(args[0], false, nil, interp.strHook(pos)))
+}
+
+func sumFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "sum", args, 1)
+	total := Value(0)
+	iterator := interp.getIterator(pos, args[0])
+	for iterator.HasNext() {
+		v := iterator.Value()
+		if _, ok := toFloat(v); !ok {
+			panic(typeError(pos, "sum() requires numbers, got %s", typeName(v)))
+		}
+		total = evalPlus(pos, total, v)
+	}
+	return total
 }
 
 func typeName(v Value) string {
 	var t string
-	switch v.(type) {
+	switch v := v.(type) {
 	case nil:
 		t = "nil"
 	case bool:
 		t = "bool"
 	case int:
 		t = "int"
+	case float64:
+		t = "float"
 	case string:
 		t = "str"
 	case *[]Value:
@@ -437,6 +1427,16 @@ func typeName(v Value) string {
 		t = "map"
 	case functionType:
 		t = "func"
+	case *generator:
+		t = "generator"
+	case channel:
+		t = "chan"
+	case *set:
+		t = "set"
+	case byteString:
+		t = "bytes"
+	case *record:
+		t = v.typ.Name
 	default:
 		// Interpreter should never give us this
 		panic(fmt.Sprintf("type() got unexpected type %T", v))
@@ -444,15 +1444,50 @@ func typeName(v Value) string {
 	return t
 }
 
+func throwFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "throw() requires 1 or 2 args, got %d", len(args)))
+	}
+	message, ok := args[0].(string)
+	if !ok {
+		panic(argTypeError(pos, "throw", 1, "a str", args[0]))
+	}
+	var value Value
+	if len(args) == 2 {
+		value = args[1]
+	}
+	panic(UserError{message, value, pos, nil})
+}
+
 func typeFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "type", args, 1)
 	return Value(typeName(args[0]))
 }
 
+func uniqueFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "unique", args, 1)
+	values := []Value{}
+	iterator := interp.getIterator(pos, args[0])
+	for iterator.HasNext() {
+		v := iterator.Value()
+		found := false
+		for _, u := range values {
+			if evalEqual(pos, u, v).(bool) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, v)
+		}
+	}
+	return Value(&values)
+}
+
 func upperFunc(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "upper", args, 1)
 	if s, ok := args[0].(string); ok {
 		return Value(strings.ToUpper(s))
 	}
-	panic(typeError(pos, "upper() requires a str"))
+	panic(argTypeError(pos, "upper", 1, "a str", args[0]))
 }
@@ -0,0 +1,232 @@
+// Generators (functions that yield) for littlelang interpreter
+
+package interpreter
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Calling a function that contains a yield statement returns a generator,
+// which runs the function body lazily as it's iterated over. The body runs
+// in its own goroutine, but only one of the generator and its caller runs
+// at a time: resuming the generator blocks the caller until the body yields
+// the next value or finishes.
+//
+// The body's goroutine only refers to the generatorRun, not the generator
+// value the program holds, so when the program drops its last reference to
+// a generator that's paused at a yield, a finalizer arranges for the body to
+// be unwound and its goroutine to exit.
+type generator struct {
+	*generatorRun
+}
+
+type generatorRun struct {
+	interp   *interpreter // interpreter (main program or task) that called it
+	function *userFunction
+	args     []Value
+	pos      Position // where the generator function was called
+
+	vars    []map[string]Value // generator's scopes while it's paused
+	resume  chan struct{}      // closed to unwind the body instead of resuming it
+	results chan yieldResult
+
+	started bool
+	running bool // the body is running (not paused at a yield)
+	done    bool
+	fetched bool // value holds the next value, not yet returned by Value()
+	value   Value
+	index   int
+}
+
+// Sent from the generator's goroutine to its caller at each yield, and when
+// the body finishes (done is true) or raises an error (panic is non-nil)
+type yieldResult struct {
+	value Value
+	done  bool
+	panic interface{}
+}
+
+// Panicked at a paused yield to unwind the body of a generator that's being
+// closed. It's not an Error, so a try statement in the body can't catch it.
+type generatorClosed struct{}
+
+// Generators whose bodies have started but not finished, shared by the
+// main program and its tasks
+type generators struct {
+	paused map[*generatorRun]bool // started, not finished
+
+	// Generators the program no longer refers to, added by finalizers
+	// (which run on another goroutine) and closed at the next checkpoint
+	mutex     sync.Mutex
+	abandoned []*generatorRun
+	pending   int32 // length of abandoned, read atomically
+}
+
+func newGenerator(interp *interpreter, f *userFunction, pos Position, args []Value) *generator {
+	g := &generator{&generatorRun{
+		interp:   interp,
+		function: f,
+		args:     args,
+		pos:      pos,
+		vars:     append([]map[string]Value(nil), interp.vars...),
+		resume:   make(chan struct{}),
+		results:  make(chan yieldResult),
+	}}
+	gens := interp.generators
+	runtime.SetFinalizer(g, func(g *generator) {
+		gens.mutex.Lock()
+		defer gens.mutex.Unlock()
+		gens.abandoned = append(gens.abandoned, g.generatorRun)
+		atomic.StoreInt32(&gens.pending, int32(len(gens.abandoned)))
+	})
+	return g
+}
+
+func (g *generatorRun) name() string {
+	if g.function.Name == "" {
+		return "<generator>"
+	}
+	return fmt.Sprintf("<generator %s>", g.function.Name)
+}
+
+// Raise an error if the generator can't be resumed by interp: only the
+// main program or task that called the generator function can iterate
+// over it, and not from inside its own body
+func (g *generator) ensureResumable(interp *interpreter, pos Position) {
+	if g.interp != interp {
+		panic(runtimeError(pos, "%s can only be iterated by the task that created it", g.name()))
+	}
+	if g.running {
+		panic(runtimeError(pos, "%s is already running", g.name()))
+	}
+}
+
+// Switch the interpreter's scopes and call stack to the generator's, call
+// f to run the body until it yields or finishes, and switch back
+func (g *generatorRun) switchTo(f func()) yieldResult {
+	interp := g.interp
+	callerVars, callerCalls, callerGenerator := interp.vars, interp.calls, interp.generator
+	interp.vars = g.vars
+	interp.calls = append(append([]Frame(nil), callerCalls...), Frame{g.function.name(), g.pos})
+	interp.generator = g
+	g.running = true
+	f()
+	result := <-g.results
+	g.running = false
+	g.vars = interp.vars
+	interp.vars, interp.calls, interp.generator = callerVars, callerCalls, callerGenerator
+	return result
+}
+
+// Run the generator's body until it yields a value or finishes
+func (g *generatorRun) step() {
+	result := g.switchTo(func() {
+		if !g.started {
+			g.started = true
+			g.interp.generators.paused[g] = true
+			go g.run()
+		} else {
+			g.resume <- struct{}{}
+		}
+	})
+	if result.done {
+		delete(g.interp.generators.paused, g)
+	}
+	if result.panic != nil {
+		g.done = true
+		panic(result.panic)
+	}
+	g.done = result.done
+	g.value = result.value
+}
+
+// Unwind the body of a generator that's paused at a yield, so its goroutine
+// exits, and mark it done. Does nothing if the body is running.
+func (g *generatorRun) close() {
+	if g.running || g.done {
+		return
+	}
+	g.done = true
+	if !g.started {
+		return
+	}
+	delete(g.interp.generators.paused, g)
+	g.switchTo(func() {
+		close(g.resume)
+	})
+}
+
+// Body of the generator's goroutine
+func (g *generatorRun) run() {
+	interp := g.interp
+	defer func() {
+		r := recover()
+		if e, ok := r.(Error); ok && e.Stack() == nil {
+			r = withStack(e, append([]Frame(nil), interp.calls...))
+		}
+		switch r.(type) {
+		case returnResult, generatorClosed:
+			// A return statement just ends the generator
+			r = nil
+		}
+		g.results <- yieldResult{done: true, panic: r}
+	}()
+	g.function.execute(interp, g.args)
+}
+
+// Send value to the caller of the currently-running generator and wait
+// until it's resumed, or unwind the body if it's closed instead
+func (interp *interpreter) yield(value Value) {
+	g := interp.generator
+	g.results <- yieldResult{value: value}
+	if _, ok := <-g.resume; !ok {
+		panic(generatorClosed{})
+	}
+}
+
+// Close the generators that finalizers found the program no longer refers
+// to. Called at a checkpoint, so the interpreter isn't running code
+// elsewhere.
+func (interp *interpreter) closeAbandoned() {
+	gens := interp.generators
+	gens.mutex.Lock()
+	abandoned := gens.abandoned
+	gens.abandoned = nil
+	atomic.StoreInt32(&gens.pending, 0)
+	gens.mutex.Unlock()
+	for _, g := range abandoned {
+		g.close()
+	}
+}
+
+// Close all generators that are paused at a yield, when the program stops
+// or exits
+func (interp *interpreter) closeGenerators() {
+	for g := range interp.generators.paused {
+		g.close()
+	}
+}
+
+func (g *generator) HasNext() bool {
+	if !g.fetched && !g.done {
+		g.step()
+		g.fetched = true
+	}
+	return !g.done
+}
+
+func (g *generator) Value() Value {
+	g.fetched = false
+	g.index++
+	return g.value
+}
+
+func (g *generator) Pair() (Value, Value) {
+	index := g.index
+	return index, g.Value()
+}
@@ -0,0 +1,76 @@
+// Operator hooks for maps in littlelang interpreter
+
+package interpreter
+
+import (
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Map keys that, if set to a function, override a binary operator when
+// either operand is the map. The function is called with the left and
+// right operands.
+var operatorHooks = map[Token]string{
+	PLUS:     "__add",
+	MINUS:    "__sub",
+	TIMES:    "__mul",
+	DIVIDE:   "__div",
+	EQUAL:    "__eq",
+	NOTEQUAL: "__eq",
+}
+
+// Evaluate binary operator op using the left map's hook function, or the
+// right map's if the left doesn't have one. Returns false if neither
+// operand has a hook for op.
+func (interp *interpreter) evalOperatorHook(pos Position, op Token, l, r Value) (Value, bool) {
+	key, ok := operatorHooks[op]
+	if !ok {
+		return nil, false
+	}
+	f := hookFunction(l, key)
+	if f == nil {
+		f = hookFunction(r, key)
+		if f == nil {
+			return nil, false
+		}
+	}
+	result := interp.callFunction(pos, f, []Value{l, r})
+	if key != "__eq" {
+		return result, true
+	}
+	b, ok := result.(bool)
+	if !ok {
+		panic(typeError(pos, "__eq must return a bool, not %s", typeName(result)))
+	}
+	if op == NOTEQUAL {
+		b = !b
+	}
+	return Value(b), true
+}
+
+// Return the function stored under key if value is a map that has one,
+// otherwise nil
+func hookFunction(value Value, key string) functionType {
+	m, ok := value.(map[string]Value)
+	if !ok {
+		return nil
+	}
+	f, _ := m[key].(functionType)
+	return f
+}
+
+// Return a hook for valueString that calls a map's "__str" function, if it
+// has one, to get the map's string representation
+func (interp *interpreter) strHook(pos Position) func(map[string]Value) (string, bool) {
+	return func(m map[string]Value) (string, bool) {
+		f, ok := m["__str"].(functionType)
+		if !ok {
+			return "", false
+		}
+		result := interp.callFunction(pos, f, []Value{Value(m)})
+		s, ok := result.(string)
+		if !ok {
+			panic(typeError(pos, "__str must return a str, not %s", typeName(result)))
+		}
+		return s, true
+	}
+}
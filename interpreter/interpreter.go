@@ -2,20 +2,29 @@
 //
 // To interprete source code, you must first call parser.ParseExpression()
 // or parser.ParseProgram(), and then call Evaluate or Execute, respectively.
-//
+// To run several programs in the same global scope, use New() to create an
+// Interpreter and call its Execute method for each program.
 package interpreter
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"reflect"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/benhoyt/littlelang/parser"
 	. "github.com/benhoyt/littlelang/tokenizer"
 )
 
-// Value is a littlelang runtime value (nil, bool, int, str, list, map, func).
+// Value is a littlelang runtime value (nil, bool, int, float, str, bytes,
+// list, map, set, func, generator, chan).
 type Value interface{}
 
 // Config allows you to configure the interpreter's interaction with the
@@ -28,17 +37,141 @@ type Config struct {
 	// builtin.
 	Args []string
 
-	// Stdin is the interpreter's standard input, for the read() builtin.
-	// Defaults to os.Stdin if nil.
+	// Stdin is the interpreter's standard input, for the read() and readline()
+	// builtins. Defaults to os.Stdin if nil.
 	Stdin io.Reader
 
 	// Stdout is the interpreter's standard output, for the print() builtin.
 	// Defaults to os.Stdout if nil.
 	Stdout io.Writer
 
+	// Stderr is where the interpreter writes warnings. Defaults to
+	// os.Stderr if nil.
+	Stderr io.Writer
+
+	// FloorDivision makes the / and % operators round toward negative
+	// infinity, like Python's // and %, so that -7 / 2 is -4 and -7 % 2 is
+	// 1. By default they truncate toward zero, like Go's, giving -3 and -1.
+	FloorDivision bool
+
+	// Truthy lets if and while conditions be any type rather than only
+	// bool, using the same rules as the bool() builtin: nil, zero numbers,
+	// and empty strs, lists, and maps are false, everything else is true.
+	// The operands of and, or, and not can also be any type, and and and
+	// or return the operand that decided the result, as in Python, so
+	// "x or default" gives default if x is nil.
+	Truthy bool
+
+	// NoWarnings disables warnings, such as the one for assigning to a
+	// global variable with the same name as a builtin.
+	NoWarnings bool
+
 	// Exit is the function to call when the builtin exit() is called.
 	// Defaults to os.Exit if nil.
 	Exit func(int)
+
+	// ReturnExit makes exit(status) stop the program and return an
+	// ExitError holding the status from Execute, instead of calling Exit.
+	// The error can't be caught by a try statement.
+	ReturnExit bool
+
+	// ReadFile is the function the read(filename) builtin uses to read a
+	// file. Defaults to ioutil.ReadFile if nil.
+	ReadFile func(filename string) ([]byte, error)
+
+	// IncludePath is the list of directories include() searches, in order,
+	// for a relative filename that isn't found relative to the current
+	// directory.
+	IncludePath []string
+
+	// Included, if not nil, is called with the name of each file include()
+	// reads from the filesystem, after searching IncludePath.
+	Included func(filename string)
+
+	// OpenFile is the function the read(filename, n) builtin uses to open a
+	// file to read in chunks. Defaults to os.Open if nil.
+	OpenFile func(filename string) (io.ReadCloser, error)
+
+	// WriteFile is the function the write(data, filename) builtin uses to
+	// write a file. Defaults to ioutil.WriteFile (with permissions 0644)
+	// if nil.
+	WriteFile func(filename string, data []byte) error
+
+	// AppendFile is the function the appendfile(filename, data) builtin
+	// uses to append to a file, creating it if it doesn't exist. Defaults
+	// to appending using os.OpenFile (with permissions 0644) if nil.
+	AppendFile func(filename string, data []byte) error
+
+	// Stat is the function the exists() and stat() builtins use to get
+	// information about a file. Defaults to os.Stat if nil.
+	Stat func(filename string) (os.FileInfo, error)
+
+	// ReadDir is the function the listdir() builtin uses to list the
+	// entries in a directory. Defaults to ioutil.ReadDir if nil.
+	ReadDir func(dirname string) ([]os.FileInfo, error)
+
+	// Mkdir is the function the mkdir() builtin uses to create a directory
+	// and any missing parents. Defaults to os.MkdirAll (with permissions
+	// 0755) if nil.
+	Mkdir func(path string) error
+
+	// Remove is the function the remove() builtin uses to remove a file or
+	// empty directory. Defaults to os.Remove if nil.
+	Remove func(filename string) error
+
+	// Signals, if not nil, delivers signals to the program by name
+	// ("interrupt" or "terminate"). Each one calls the handler the program
+	// has set with trap(), or exits (with status 130 or 143) if it hasn't
+	// set one. Signals are handled between statements.
+	Signals <-chan string
+
+	// Trap, if not nil, is called with trapped true when the program sets
+	// a handler for a signal with trap(), and with trapped false when it
+	// removes the handler (or Stop is called). This lets the embedder only
+	// deliver a signal on Signals while the program has trapped it, and
+	// otherwise leave the signal its default effect.
+	Trap func(signal string, trapped bool)
+
+	// Now is the function the now() builtin uses to get the current time.
+	// Defaults to time.Now if nil.
+	Now func() time.Time
+
+	// Profile enables collection of per-function call counts and times in
+	// Stats.Profile.
+	Profile bool
+
+	// Cover enables collection of per-statement execution counts in
+	// Stats.Coverage.
+	Cover bool
+
+	// Context, if not nil, is checked before each statement is executed.
+	// If it's canceled or its deadline passes, execution stops with a
+	// TimeoutError.
+	Context context.Context
+
+	// Sandbox restricts what the program is allowed to do.
+	Sandbox Sandbox
+
+	// Builtins is a map of extra builtin functions to make available to
+	// the program, keyed by name. They override the standard builtins of
+	// the same name.
+	Builtins map[string]BuiltinFunc
+}
+
+// BuiltinFunc is a builtin function implemented in Go outside this package,
+// for use in Config.Builtins. It's called with the evaluated arguments, which
+// are littlelang Values: nil, bool, int, float64, string, *[]Value (list),
+// map[string]Value (map), or a function. If it returns a non-nil error,
+// execution stops with a RuntimeError like "name() error: message".
+type BuiltinFunc func(args []Value) (Value, error)
+
+// Sandbox restricts what a program is allowed to do, so that untrusted
+// programs can be run more safely. The zero value allows everything.
+type Sandbox struct {
+	// NoFS disallows builtins that access the filesystem, like
+	// read(filename), write(data, filename), appendfile(), listdir(), and
+	// remove().
+	NoFS bool
 }
 
 // Statistics about the interpreter from an Evaluate or Execute call.
@@ -46,15 +179,57 @@ type Stats struct {
 	Ops          int
 	UserCalls    int
 	BuiltinCalls int
+
+	// Profile is a map of function name (for example "<func add>" or
+	// "<builtin print>") to profiling information for that function. It's
+	// nil unless Config.Profile is true.
+	Profile map[string]*FunctionProfile
+
+	// Coverage is a map of statement position to the number of times that
+	// statement was executed. When running a program with Execute, it
+	// includes every statement in the program, even those executed zero
+	// times. It's nil unless Config.Cover is true.
+	Coverage map[Position]int
 }
 
 type interpreter struct {
-	vars   []map[string]Value
-	args   []string
-	stdin  io.Reader
-	stdout io.Writer
-	exit   func(int)
-	stats  Stats
+	vars       []map[string]Value
+	args       []string
+	stdin      *bufio.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	exit       func(int)
+	returnExit bool
+	signals    <-chan string
+	traps      map[string]functionType
+	trapNotify func(signal string, trapped bool)
+	readFile   func(string) ([]byte, error)
+	writeFile  func(string, []byte) error
+	appendFile func(string, []byte) error
+	openFile   func(string) (io.ReadCloser, error)
+	stat       func(string) (os.FileInfo, error)
+	readDir    func(string) ([]os.FileInfo, error)
+	mkdir      func(string) error
+	remove     func(string) error
+	openFiles  map[string]io.ReadCloser
+	includes   []string
+	included   func(string)
+	now        func() time.Time
+	ctx        context.Context
+	sandbox    Sandbox
+	stats      *Stats
+	tasks      *tasks
+	calls      []Frame
+	builtins   map[string]bool
+	warned     map[string]bool
+	versions   map[uintptr]*version // containers being iterated, by containerID
+
+	floorDivision bool
+	truthy        bool
+
+	generators *generators
+	generator  *generatorRun // generator whose body is running, if any
+	isTask     bool          // true if running a function started by spawn()
 }
 
 type returnResult struct {
@@ -80,51 +255,140 @@ var binaryEvalFuncs = map[Token]binaryEvalFunc{
 }
 
 func evalEqual(pos Position, l, r Value) Value {
+	return Value(valuesEqual(l, r, nil))
+}
+
+// Return an identifier for a list or map that's unique while it's alive
+func containerID(v Value) uintptr {
+	return reflect.ValueOf(v).Pointer()
+}
+
+// Pair of lists or maps being compared, to detect cycles
+type containerPair struct {
+	l, r uintptr
+}
+
+// Report whether l and r are equal. Lists and maps can contain themselves,
+// so a pair that's already being compared is assumed to be equal; if any
+// other elements differ the overall result is still false.
+func valuesEqual(l, r Value, comparing map[containerPair]bool) bool {
 	switch l := l.(type) {
 	case nil:
-		return Value(r == nil)
+		return r == nil
 	case bool:
 		if r, rok := r.(bool); rok {
-			return Value(l == r)
+			return l == r
 		}
 	case int:
-		if r, rok := r.(int); rok {
-			return Value(l == r)
+		switch r := r.(type) {
+		case int:
+			return l == r
+		case float64:
+			return float64(l) == r
+		}
+	case float64:
+		if r, rok := toFloat(r); rok {
+			return l == r
 		}
 	case string:
 		if r, rok := r.(string); rok {
-			return Value(l == r)
+			return l == r
+		}
+	case byteString:
+		if r, rok := r.(byteString); rok {
+			return l == r
 		}
 	case *[]Value:
 		if r, rok := r.(*[]Value); rok {
+			if l == r {
+				return true
+			}
 			if len(*l) != len(*r) {
-				return Value(false)
+				return false
+			}
+			pair := containerPair{containerID(l), containerID(r)}
+			if comparing[pair] {
+				return true
 			}
+			if comparing == nil {
+				comparing = make(map[containerPair]bool)
+			}
+			comparing[pair] = true
 			for i, elem := range *l {
-				if !evalEqual(pos, elem, (*r)[i]).(bool) {
-					return Value(false)
+				if !valuesEqual(elem, (*r)[i], comparing) {
+					return false
 				}
 			}
-			return Value(true)
+			return true
 		}
 	case map[string]Value:
 		if r, rok := r.(map[string]Value); rok {
 			if len(l) != len(r) {
-				return Value(false)
+				return false
 			}
+			pair := containerPair{containerID(l), containerID(r)}
+			if pair.l == pair.r || comparing[pair] {
+				return true
+			}
+			if comparing == nil {
+				comparing = make(map[containerPair]bool)
+			}
+			comparing[pair] = true
 			for k, v := range l {
-				if !evalEqual(pos, v, r[k]).(bool) {
-					return Value(false)
+				if !valuesEqual(v, r[k], comparing) {
+					return false
 				}
 			}
-			return Value(true)
+			return true
+		}
+	case *record:
+		if r, rok := r.(*record); rok {
+			if l.typ != r.typ {
+				return false
+			}
+			pair := containerPair{containerID(l), containerID(r)}
+			if pair.l == pair.r || comparing[pair] {
+				return true
+			}
+			if comparing == nil {
+				comparing = make(map[containerPair]bool)
+			}
+			comparing[pair] = true
+			for k, v := range l.fields {
+				if !valuesEqual(v, r.fields[k], comparing) {
+					return false
+				}
+			}
+			return true
+		}
+	case builtinFunction:
+		// Can't compare these with == as they contain a func, but each
+		// builtin has a unique name
+		if r, rok := r.(builtinFunction); rok {
+			return l.Name == r.Name
 		}
 	case functionType:
 		if r, rok := r.(functionType); rok {
-			return Value(l == r)
+			return l == r
+		}
+	case *generator:
+		return l == r
+	case channel:
+		return l == r
+	case *set:
+		if r, rok := r.(*set); rok {
+			if len(l.elems) != len(r.elems) {
+				return false
+			}
+			for k := range l.elems {
+				if _, ok := r.elems[k]; !ok {
+					return false
+				}
+			}
+			return true
 		}
 	}
-	return Value(false)
+	return false
 }
 
 func evalIn(pos Position, l, r Value) Value {
@@ -134,6 +398,14 @@ func evalIn(pos Position, l, r Value) Value {
 			return Value(strings.Index(r, l) >= 0)
 		}
 		panic(typeError(pos, "in str requires str on left side"))
+	case byteString:
+		switch l := l.(type) {
+		case int:
+			return Value(l >= 0 && l <= 255 && strings.IndexByte(string(r), byte(l)) >= 0)
+		case byteString:
+			return Value(strings.Index(string(r), string(l)) >= 0)
+		}
+		panic(typeError(pos, "in bytes requires int or bytes on left side"))
 	case *[]Value:
 		for _, v := range *r {
 			if evalEqual(pos, l, v).(bool) {
@@ -147,34 +419,85 @@ func evalIn(pos Position, l, r Value) Value {
 			return Value(present)
 		}
 		panic(typeError(pos, "in map requires str on left side"))
+	case *set:
+		return Value(r.contains(pos, l))
 	}
-	panic(typeError(pos, "in requires str, list, or map on right side"))
+	panic(typeError(pos, "in requires str, bytes, list, map, or set on right side"))
 }
 
 func evalLess(pos Position, l, r Value) Value {
+	return Value(valuesLess(pos, l, r, nil))
+}
+
+// Report whether l is less than r, raising an error if lists contain
+// themselves in a way that makes them impossible to order
+func valuesLess(pos Position, l, r Value, comparing map[containerPair]bool) bool {
+	if lf, rf, ok := floatOperands(l, r); ok {
+		return lf < rf
+	}
 	switch l := l.(type) {
 	case int:
 		if r, rok := r.(int); rok {
-			return Value(l < r)
+			return l < r
 		}
 	case string:
 		if r, rok := r.(string); rok {
-			return Value(l < r)
+			return l < r
+		}
+	case byteString:
+		if r, rok := r.(byteString); rok {
+			return l < r
 		}
 	case *[]Value:
 		if r, rok := r.(*[]Value); rok {
+			pair := containerPair{containerID(l), containerID(r)}
+			if comparing[pair] {
+				panic(valueError(pos, "can't compare recursive lists"))
+			}
+			if comparing == nil {
+				comparing = make(map[containerPair]bool)
+			}
+			comparing[pair] = true
 			for i := 0; i < len(*l) && i < len(*r); i++ {
-				if !evalEqual(pos, (*l)[i], (*r)[i]).(bool) {
-					return evalLess(pos, (*l)[i], (*r)[i])
+				if !valuesEqual((*l)[i], (*r)[i], nil) {
+					return valuesLess(pos, (*l)[i], (*r)[i], comparing)
 				}
 			}
-			return Value(len(*l) < len(*r))
+			return len(*l) < len(*r)
 		}
 	}
-	panic(typeError(pos, "comparison requires two ints or two strs (or lists of ints or strs)"))
+	panic(typeError(pos, "comparison requires two numbers, strs, or bytes (or lists of those)"))
+}
+
+// Return the value as a float64 if it's an int or float
+func toFloat(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// If l and r are both numbers and at least one of them is a float, return
+// them both as float64s (int op int gives an int, anything with a float
+// gives a float)
+func floatOperands(l, r Value) (float64, float64, bool) {
+	_, lint := l.(int)
+	_, rint := r.(int)
+	if lint && rint {
+		return 0, 0, false
+	}
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	return lf, rf, lok && rok
 }
 
 func evalPlus(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatOperands(l, r); ok {
+		return Value(lf + rf)
+	}
 	switch l := l.(type) {
 	case int:
 		if r, rok := r.(int); rok {
@@ -184,6 +507,10 @@ func evalPlus(pos Position, l, r Value) Value {
 		if r, rok := r.(string); rok {
 			return Value(l + r)
 		}
+	case byteString:
+		if r, rok := r.(byteString); rok {
+			return Value(l + r)
+		}
 	case *[]Value:
 		if r, rok := r.(*[]Value); rok {
 			result := make([]Value, 0, len(*l)+len(*r))
@@ -203,24 +530,30 @@ func evalPlus(pos Position, l, r Value) Value {
 			return Value(result)
 		}
 	}
-	panic(typeError(pos, "+ requires two ints, strs, lists, or maps"))
+	panic(typeError(pos, "+ requires two numbers, strs, bytes, lists, or maps"))
 }
 
 func ensureInts(pos Position, l, r Value, operation string) (int, int) {
 	li, lok := l.(int)
 	ri, rok := r.(int)
 	if !lok || !rok {
-		panic(typeError(pos, "%s requires two ints", operation))
+		panic(typeError(pos, "%s requires two numbers", operation))
 	}
 	return li, ri
 }
 
 func evalMinus(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatOperands(l, r); ok {
+		return Value(lf - rf)
+	}
 	li, ri := ensureInts(pos, l, r, "-")
 	return Value(li - ri)
 }
 
 func evalTimes(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatOperands(l, r); ok {
+		return Value(lf * rf)
+	}
 	switch l := l.(type) {
 	case int:
 		switch r := r.(type) {
@@ -257,10 +590,23 @@ func evalTimes(pos Position, l, r Value) Value {
 			return Value(&lst)
 		}
 	}
-	panic(typeError(pos, "* requires two ints or a str or list and an int"))
+	panic(typeError(pos, "* requires two numbers or a str or list and an int"))
+}
+
+// Return l and r as floats for a float division or modulo, raising an
+// error if r is zero
+func floatDivisor(pos Position, l, r Value) (float64, float64, bool) {
+	lf, rf, ok := floatOperands(l, r)
+	if ok && rf == 0 {
+		panic(valueError(pos, "can't divide by zero"))
+	}
+	return lf, rf, ok
 }
 
 func evalDivide(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatDivisor(pos, l, r); ok {
+		return Value(lf / rf)
+	}
 	li, ri := ensureInts(pos, l, r, "/")
 	if ri == 0 {
 		panic(valueError(pos, "can't divide by zero"))
@@ -269,6 +615,9 @@ func evalDivide(pos Position, l, r Value) Value {
 }
 
 func evalModulo(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatDivisor(pos, l, r); ok {
+		return Value(math.Mod(lf, rf))
+	}
 	li, ri := ensureInts(pos, l, r, "%")
 	if ri == 0 {
 		panic(valueError(pos, "can't divide by zero"))
@@ -276,6 +625,48 @@ func evalModulo(pos Position, l, r Value) Value {
 	return Value(li % ri)
 }
 
+// Division and modulo operators used when Config.FloorDivision is true
+var floorEvalFuncs = map[Token]binaryEvalFunc{
+	DIVIDE: evalFloorDivide,
+	MODULO: evalFloorModulo,
+}
+
+// Divide, rounding the result toward negative infinity (like Python's //)
+func evalFloorDivide(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatDivisor(pos, l, r); ok {
+		return Value(math.Floor(lf / rf))
+	}
+	li, ri := ensureInts(pos, l, r, "/")
+	if ri == 0 {
+		panic(valueError(pos, "can't divide by zero"))
+	}
+	q := li / ri
+	if (li%ri != 0) && ((li < 0) != (ri < 0)) {
+		q--
+	}
+	return Value(q)
+}
+
+// Modulo whose result has the same sign as the divisor (like Python's %)
+func evalFloorModulo(pos Position, l, r Value) Value {
+	if lf, rf, ok := floatDivisor(pos, l, r); ok {
+		m := math.Mod(lf, rf)
+		if m != 0 && ((m < 0) != (rf < 0)) {
+			m += rf
+		}
+		return Value(m)
+	}
+	li, ri := ensureInts(pos, l, r, "%")
+	if ri == 0 {
+		panic(valueError(pos, "can't divide by zero"))
+	}
+	m := li % ri
+	if m != 0 && ((m < 0) != (ri < 0)) {
+		m += ri
+	}
+	return Value(m)
+}
+
 type unaryEvalFunc func(pos Position, v Value) Value
 
 var unaryEvalFuncs = map[Token]unaryEvalFunc{
@@ -291,12 +682,19 @@ func evalNot(pos Position, v Value) Value {
 }
 
 func evalNegative(pos Position, v Value) Value {
-	if v, ok := v.(int); ok {
+	switch v := v.(type) {
+	case int:
+		return Value(-v)
+	case float64:
 		return Value(-v)
 	}
-	panic(typeError(pos, "unary - requires an int"))
+	panic(typeError(pos, "unary - requires a number"))
 }
 
+// Only suggest similar keys for maps up to this size, as finding the
+// closest key is linear in the number of keys
+const maxSuggestKeys = 100
+
 func evalSubscript(pos Position, container, subscript Value) Value {
 	switch c := container.(type) {
 	case string:
@@ -307,6 +705,14 @@ func evalSubscript(pos Position, container, subscript Value) Value {
 			return Value(string([]byte{c[s]}))
 		}
 		panic(typeError(pos, "str subscript must be an int"))
+	case byteString:
+		if s, ok := subscript.(int); ok {
+			if s < 0 || s >= len(c) {
+				panic(valueError(pos, "subscript %d out of range", s))
+			}
+			return Value(int(c[s]))
+		}
+		panic(typeError(pos, "bytes subscript must be an int"))
 	case *[]Value:
 		if s, ok := subscript.(int); ok {
 			if s < 0 || s >= len(*c) {
@@ -320,16 +726,66 @@ func evalSubscript(pos Position, container, subscript Value) Value {
 			if value, ok := c[s]; ok {
 				return value
 			}
+			if len(c) <= maxSuggestKeys {
+				if key := closestName(s, []map[string]Value{c}); key != "" {
+					panic(valueError(pos, "key not found: %q, did you mean %q?", s, key))
+				}
+			}
 			panic(valueError(pos, "key not found: %q", s))
 		}
 		panic(typeError(pos, "map subscript must be a str"))
+	case *record:
+		if s, ok := subscript.(string); ok {
+			if value, ok := c.get(pos, s); ok {
+				return value
+			}
+			panic(valueError(pos, "%s has no field or method %q", c.typ.Name, s))
+		}
+		panic(typeError(pos, "%s subscript must be a str", c.typ.Name))
 	default:
-		panic(typeError(pos, "can only subscript str, list, or map"))
+		panic(typeError(pos, "can only subscript str, bytes, list, map, or record"))
 	}
 }
 
+// Like evalSubscript, but return nil if container is nil or the subscript
+// is a missing key or out of range (other errors are still raised)
+func evalOptionalSubscript(pos Position, container, subscript Value) Value {
+	switch c := container.(type) {
+	case nil:
+		return nil
+	case string:
+		if s, ok := subscript.(int); ok && (s < 0 || s >= len(c)) {
+			return nil
+		}
+	case byteString:
+		if s, ok := subscript.(int); ok && (s < 0 || s >= len(c)) {
+			return nil
+		}
+	case *[]Value:
+		if s, ok := subscript.(int); ok && (s < 0 || s >= len(*c)) {
+			return nil
+		}
+	case map[string]Value:
+		if s, ok := subscript.(string); ok {
+			return c[s]
+		}
+	case *record:
+		if s, ok := subscript.(string); ok {
+			value, _ := c.get(pos, s)
+			return value
+		}
+	}
+	return evalSubscript(pos, container, subscript)
+}
+
 func (interp *interpreter) evalAnd(pos Position, le, re parser.Expression) Value {
 	l := interp.evaluate(le)
+	if interp.truthy {
+		if !truthy(l) {
+			return l
+		}
+		return interp.evaluate(re)
+	}
 	if l, ok := l.(bool); ok {
 		if !l {
 			// Short circuit: don't evaluate right if left false
@@ -348,6 +804,12 @@ func (interp *interpreter) evalAnd(pos Position, le, re parser.Expression) Value
 
 func (interp *interpreter) evalOr(pos Position, le, re parser.Expression) Value {
 	l := interp.evaluate(le)
+	if interp.truthy {
+		if truthy(l) {
+			return l
+		}
+		return interp.evaluate(re)
+	}
 	if l, ok := l.(bool); ok {
 		if l {
 			// Short circuit: don't evaluate right if left true
@@ -365,8 +827,18 @@ func (interp *interpreter) evalOr(pos Position, le, re parser.Expression) Value
 }
 
 func (interp *interpreter) callFunction(pos Position, f functionType, args []Value) (ret Value) {
+	if interp.stats.Profile != nil {
+		defer interp.startProfile(f.name())()
+	}
+	interp.calls = append(interp.calls, Frame{f.name(), pos})
 	defer func() {
-		if r := recover(); r != nil {
+		r := recover()
+		if e, ok := r.(Error); ok && e.Stack() == nil {
+			// Record call stack in innermost function the error passes through
+			r = withStack(e, append([]Frame(nil), interp.calls...))
+		}
+		interp.calls = interp.calls[:len(interp.calls)-1]
+		if r != nil {
 			if result, ok := r.(returnResult); ok {
 				ret = result.value
 			} else {
@@ -377,38 +849,92 @@ func (interp *interpreter) callFunction(pos Position, f functionType, args []Val
 	return f.call(interp, pos, args)
 }
 
+// Evaluate the function part of a call like x.name(...). If name is a
+// method of x's type, return the builtin and x as the receiver to pass as
+// its first argument; otherwise return x.name and a nil receiver.
+func (interp *interpreter) evalCallee(s *parser.Subscript) (Value, Value) {
+	interp.stats.Ops++
+	container := interp.evaluate(s.Container)
+	subscript := interp.evaluate(s.Subscript)
+	if name, ok := subscript.(string); ok {
+		if hasMethod(container, name) {
+			return builtins[name], container
+		}
+		switch container.(type) {
+		case nil, map[string]Value, *record:
+		default:
+			panic(typeError(s.Subscript.Position(), "%s has no method %s", typeName(container), name))
+		}
+	}
+	if s.Optional {
+		return evalOptionalSubscript(s.Subscript.Position(), container, subscript), nil
+	}
+	return evalSubscript(s.Subscript.Position(), container, subscript), nil
+}
+
 func (interp *interpreter) evaluate(expr parser.Expression) Value {
 	interp.stats.Ops++
 	switch e := expr.(type) {
 	case *parser.Binary:
-		if f, ok := binaryEvalFuncs[e.Operator]; ok {
-			return f(e.Position(), interp.evaluate(e.Left), interp.evaluate(e.Right))
-		} else if e.Operator == AND {
+		if e.Operator == AND {
 			return interp.evalAnd(e.Position(), e.Left, e.Right)
 		} else if e.Operator == OR {
 			return interp.evalOr(e.Position(), e.Left, e.Right)
 		}
+		l, r := interp.evaluate(e.Left), interp.evaluate(e.Right)
+		if result, ok := interp.evalOperatorHook(e.Position(), e.Operator, l, r); ok {
+			return result
+		}
+		if interp.floorDivision {
+			if f, ok := floorEvalFuncs[e.Operator]; ok {
+				return f(e.Position(), l, r)
+			}
+		}
+		if f, ok := binaryEvalFuncs[e.Operator]; ok {
+			return f(e.Position(), l, r)
+		}
 		// Parser should never give us this
 		panic(fmt.Sprintf("unknown binary operator %v", e.Operator))
 	case *parser.Unary:
+		if interp.truthy && e.Operator == NOT {
+			return Value(!truthy(interp.evaluate(e.Operand)))
+		}
 		if f, ok := unaryEvalFuncs[e.Operator]; ok {
 			return f(e.Position(), interp.evaluate(e.Operand))
 		}
 		// Parser should never give us this
 		panic(fmt.Sprintf("unknown unary operator %v", e.Operator))
 	case *parser.Call:
-		function := interp.evaluate(e.Function)
+		var function Value
+		args := []Value{}
+		if s, ok := e.Function.(*parser.Subscript); ok {
+			var receiver Value
+			function, receiver = interp.evalCallee(s)
+			if receiver != nil {
+				args = append(args, receiver)
+			}
+		} else {
+			function = interp.evaluate(e.Function)
+		}
 		if f, ok := function.(functionType); ok {
-			args := []Value{}
 			for _, a := range e.Arguments {
+				if spread, ok := a.(*parser.Spread); ok {
+					iterator := interp.getIterator(spread.Position(), interp.evaluate(spread.Value))
+					for iterator.HasNext() {
+						args = append(args, iterator.Value())
+					}
+					continue
+				}
 				args = append(args, interp.evaluate(a))
 			}
-			if e.Ellipsis {
-				iterator := getIterator(e.Arguments[len(args)-1].Position(), args[len(args)-1])
-				args = args[:len(args)-1]
-				for iterator.HasNext() {
-					args = append(args, iterator.Value())
+			if len(e.Keywords) > 0 {
+				names := make([]string, len(e.Keywords))
+				values := make([]Value, len(e.Keywords))
+				for i, keyword := range e.Keywords {
+					names[i] = keyword.Name
+					values[i] = interp.evaluate(keyword.Value)
 				}
+				args = bindKeywords(e.Function.Position(), f, args, names, values)
 			}
 			return interp.callFunction(e.Function.Position(), f, args)
 		}
@@ -419,16 +945,37 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 		if v, ok := interp.lookup(e.Name); ok {
 			return v
 		}
+		if suggestion := interp.suggestName(e.Name); suggestion != "" {
+			panic(nameError(e.Position(), "name %q not found, did you mean %q?", e.Name, suggestion))
+		}
 		panic(nameError(e.Position(), "name %q not found", e.Name))
 	case *parser.List:
-		values := make([]Value, len(e.Values))
-		for i, v := range e.Values {
-			values[i] = interp.evaluate(v)
+		values := make([]Value, 0, len(e.Values))
+		for _, v := range e.Values {
+			if spread, ok := v.(*parser.Spread); ok {
+				iterator := interp.getIterator(spread.Position(), interp.evaluate(spread.Value))
+				for iterator.HasNext() {
+					values = append(values, iterator.Value())
+				}
+				continue
+			}
+			values = append(values, interp.evaluate(v))
 		}
 		return Value(&values)
 	case *parser.Map:
 		value := make(map[string]Value)
 		for _, item := range e.Items {
+			if item.Key == nil {
+				spread := item.Value.(*parser.Spread)
+				m, ok := interp.evaluate(spread.Value).(map[string]Value)
+				if !ok {
+					panic(typeError(spread.Position(), "can only use ... with a map in a map literal"))
+				}
+				for k, v := range m {
+					value[k] = v
+				}
+				continue
+			}
 			key := interp.evaluate(item.Key)
 			if k, ok := key.(string); ok {
 				value[k] = interp.evaluate(item.Value)
@@ -440,10 +987,23 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 	case *parser.Subscript:
 		container := interp.evaluate(e.Container)
 		subscript := interp.evaluate(e.Subscript)
+		if e.Optional {
+			return evalOptionalSubscript(e.Subscript.Position(), container, subscript)
+		}
 		return evalSubscript(e.Subscript.Position(), container, subscript)
+	case *parser.Slice:
+		container := interp.evaluate(e.Container)
+		var start, end Value
+		if e.Start != nil {
+			start = interp.evaluate(e.Start)
+		}
+		if e.End != nil {
+			end = interp.evaluate(e.End)
+		}
+		return evalSlice(e.Position(), container, start, end)
 	case *parser.FunctionExpression:
 		closure := interp.vars[len(interp.vars)-1]
-		return &userFunction{"", e.Parameters, e.Ellipsis, e.Body, closure}
+		return &userFunction{"", e.Parameters, e.Ellipsis, e.Body, closure, e.Position(), e.Generator}
 	default:
 		// Parser should never give us this
 		panic(fmt.Sprintf("unexpected expression type %T", expr))
@@ -462,6 +1022,29 @@ func (interp *interpreter) assign(name string, value Value) {
 	interp.vars[len(interp.vars)-1][name] = value
 }
 
+// Assign to the variable in the nearest enclosing scope that already
+// defines it (not the current scope), returning false if there isn't one
+func (interp *interpreter) assignOuter(name string, value Value) bool {
+	for i := len(interp.vars) - 2; i >= 0; i-- {
+		if _, ok := interp.vars[i][name]; ok {
+			interp.vars[i][name] = value
+			return true
+		}
+	}
+	return false
+}
+
+// Warn (once per name) if a global variable or function is about to
+// shadow the builtin with the given name
+func (interp *interpreter) warnShadow(pos Position, what, name string) {
+	if interp.warned == nil || len(interp.vars) > 1 || !interp.builtins[name] || interp.warned[name] {
+		return
+	}
+	interp.warned[name] = true
+	fmt.Fprintf(interp.stderr, "warning at %s: %s %s shadows builtin %s()\n",
+		pos, what, name, name)
+}
+
 func (interp *interpreter) lookup(name string) (Value, bool) {
 	for i := len(interp.vars) - 1; i >= 0; i-- {
 		thisVars := interp.vars[i]
@@ -472,23 +1055,221 @@ func (interp *interpreter) lookup(name string) (Value, bool) {
 	return nil, false
 }
 
+// Return the name in scope (including builtins) closest to the given
+// unknown name, or "" if none are close enough to be a likely typo
+func (interp *interpreter) suggestName(name string) string {
+	return closestName(name, interp.vars)
+}
+
+// Return the key in the given maps closest to name, or "" if none are
+// close enough to be a likely typo
+func closestName(name string, maps []map[string]Value) string {
+	if len(name) < 3 {
+		return ""
+	}
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, m := range maps {
+		for candidate := range m {
+			d := editDistance(name, candidate)
+			if d < bestDistance || (d == bestDistance && candidate < best) {
+				best, bestDistance = candidate, d
+			}
+		}
+	}
+	return best
+}
+
+// Return the Levenshtein distance between a and b (in bytes)
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost // substitution
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1 // deletion
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1 // insertion
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
 func (interp *interpreter) executeBlock(block parser.Block) {
 	for _, s := range block {
 		interp.executeStatement(s)
 	}
 }
 
+// Report whether value matches the given match statement pattern, adding
+// the names it binds to bindings
+func matchPattern(pattern parser.Expression, value Value, bindings map[string]Value) bool {
+	switch p := pattern.(type) {
+	case *parser.Variable:
+		bindings[p.Name] = value
+		return true
+	case *parser.Literal:
+		return valuesEqual(p.Value, value, nil)
+	case *parser.List:
+		list, ok := value.(*[]Value)
+		if !ok {
+			return false
+		}
+		patterns := p.Values
+		var rest *parser.Variable
+		if n := len(patterns); n > 0 {
+			if spread, ok := patterns[n-1].(*parser.Spread); ok {
+				rest = spread.Value.(*parser.Variable)
+				patterns = patterns[:n-1]
+			}
+		}
+		if len(*list) < len(patterns) || (rest == nil && len(*list) != len(patterns)) {
+			return false
+		}
+		for i, elem := range patterns {
+			if !matchPattern(elem, (*list)[i], bindings) {
+				return false
+			}
+		}
+		if rest != nil {
+			values := append([]Value(nil), (*list)[len(patterns):]...)
+			if values == nil {
+				values = []Value{}
+			}
+			bindings[rest.Name] = &values
+		}
+		return true
+	case *parser.Map:
+		m, ok := value.(map[string]Value)
+		if !ok {
+			return false
+		}
+		for _, item := range p.Items {
+			v, ok := m[item.Key.(*parser.Literal).Value.(string)]
+			if !ok || !matchPattern(item.Value, v, bindings) {
+				return false
+			}
+		}
+		return true
+	default:
+		// Parser should never give us this
+		panic(fmt.Sprintf("unexpected pattern type %T", pattern))
+	}
+}
+
+// Evaluate an if or while condition, converting it to a bool if
+// Config.Truthy is set
+func (interp *interpreter) condition(expr parser.Expression) Value {
+	cond := interp.evaluate(expr)
+	if interp.truthy {
+		return Value(truthy(cond))
+	}
+	return cond
+}
+
+// Execute the body of a try statement, returning the caught error value if
+// it raised an error (or nil if it didn't)
+func (interp *interpreter) executeTry(body parser.Block) (caught map[string]Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(Error)
+			if ok {
+				caught, ok = caughtValue(e)
+			}
+			if !ok {
+				panic(r)
+			}
+		}
+	}()
+	interp.executeBlock(body)
+	return nil
+}
+
 type iteratorType interface {
 	HasNext() bool
 	Value() Value
+	Pair() (Value, Value)
 }
 
 type listIterator struct {
 	values []Value
 	index  int
+	check  func() // if non-nil, raises an error if the container was modified
+	pair   func(index int, v Value) (Value, Value)
+}
+
+// Modification counter for a list, map, or set that's being iterated over.
+// It's only kept while a loop is iterating the container, as nothing else
+// needs to know when it's modified.
+type version struct {
+	count     int // incremented each time the container is modified
+	iterators int // number of loops iterating the container
+}
+
+// Record that container (a list, map, or set) was modified, so that loops
+// iterating over it raise an error at their next step
+func (interp *interpreter) modified(container Value) {
+	if len(interp.versions) == 0 {
+		return
+	}
+	if v := interp.versions[containerID(container)]; v != nil {
+		v.count++
+	}
+}
+
+// Return an iterator over value for a loop that runs littlelang code on
+// each step, and a function to call when the loop is done. If the code
+// modifies the list, map, or set being iterated (other than by assigning
+// to an existing element or key), the iterator raises an error.
+func (interp *interpreter) loopIterator(pos Position, value Value) (iteratorType, func()) {
+	iterator := interp.getIterator(pos, value)
+	switch value.(type) {
+	case *[]Value, map[string]Value, *set:
+	default:
+		return iterator, func() {}
+	}
+	id := containerID(value)
+	v := interp.versions[id]
+	if v == nil {
+		v = &version{}
+		interp.versions[id] = v
+	}
+	v.iterators++
+	count := v.count
+	iterator.(*listIterator).check = func() {
+		if v.count != count {
+			panic(runtimeError(pos, "%s modified during iteration", typeName(value)))
+		}
+	}
+	done := func() {
+		v.iterators--
+		if v.iterators == 0 {
+			delete(interp.versions, id)
+		}
+	}
+	return iterator, done
 }
 
 func (li *listIterator) HasNext() bool {
+	if li.check != nil {
+		li.check()
+	}
 	return li.index < len(li.values)
 }
 
@@ -498,16 +1279,44 @@ func (li *listIterator) Value() Value {
 	return v
 }
 
-func getIterator(pos Position, value Value) iteratorType {
+// Pair returns the next two loop variables: the index and element for a
+// list or str, or the key and value for a map
+func (li *listIterator) Pair() (Value, Value) {
+	i := li.index
+	return li.pair(i, li.Value())
+}
+
+func (interp *interpreter) getIterator(pos Position, value Value) iteratorType {
 	switch iterable := value.(type) {
 	case string:
+		// Like Go, the index of each character is its byte offset
 		strs := []Value{}
-		for _, r := range iterable {
+		offsets := []int{}
+		for i, r := range iterable {
 			strs = append(strs, string(r))
+			offsets = append(offsets, i)
+		}
+		pair := func(index int, v Value) (Value, Value) {
+			return offsets[index], v
 		}
-		return &listIterator{strs, 0}
+		return &listIterator{strs, 0, nil, pair}
+	case byteString:
+		values := make([]Value, len(iterable))
+		for i := 0; i < len(iterable); i++ {
+			values[i] = int(iterable[i])
+		}
+		pair := func(index int, v Value) (Value, Value) {
+			return index, v
+		}
+		return &listIterator{values, 0, nil, pair}
 	case *[]Value:
-		return &listIterator{*iterable, 0}
+		// Iterate over the list's current elements (assigning to an
+		// element changes the value seen, as they share an array)
+		values := *iterable
+		pair := func(index int, v Value) (Value, Value) {
+			return index, v
+		}
+		return &listIterator{values, 0, nil, pair}
 	case map[string]Value:
 		keys := make([]Value, len(iterable))
 		i := 0
@@ -515,12 +1324,65 @@ func getIterator(pos Position, value Value) iteratorType {
 			keys[i] = key
 			i++
 		}
-		return &listIterator{keys, 0}
+		pair := func(index int, key Value) (Value, Value) {
+			return key, iterable[key.(string)]
+		}
+		return &listIterator{keys, 0, nil, pair}
+	case *generator:
+		iterable.ensureResumable(interp, pos)
+		return iterable
+	case *set:
+		values := iterable.sorted()
+		pair := func(index int, v Value) (Value, Value) {
+			return index, v
+		}
+		return &listIterator{values, 0, nil, pair}
 	default:
-		panic(typeError(pos, "expected iterable (str, list, or map), got %s", typeName(value)))
+		panic(typeError(pos, "expected iterable (str, bytes, list, map, set, or generator), got %s", typeName(value)))
 	}
 }
 
+// Return container[start:end] for a str, bytes, or list. A nil start or end means
+// the start or end of the container (like an omitted one).
+func evalSlice(pos Position, container, start, end Value) Value {
+	var length int
+	switch c := container.(type) {
+	case string:
+		length = len(c)
+	case byteString:
+		length = len(c)
+	case *[]Value:
+		length = len(*c)
+	default:
+		panic(typeError(pos, "can only slice str, bytes, or list, not %s", typeName(container)))
+	}
+	s, e := 0, length
+	if start != nil {
+		var ok bool
+		if s, ok = start.(int); !ok {
+			panic(typeError(pos, "slice start must be an int, not %s", typeName(start)))
+		}
+	}
+	if end != nil {
+		var ok bool
+		if e, ok = end.(int); !ok {
+			panic(typeError(pos, "slice end must be an int, not %s", typeName(end)))
+		}
+	}
+	if s < 0 || e > length || s > e {
+		panic(valueError(pos, "slice [%d:%d] out of range", s, e))
+	}
+	switch c := container.(type) {
+	case string:
+		return Value(c[s:e])
+	case byteString:
+		return Value(c[s:e])
+	}
+	result := make([]Value, e-s)
+	copy(result, (*container.(*[]Value))[s:e])
+	return Value(&result)
+}
+
 func (interp *interpreter) assignSubscript(pos Position, container, subscript, value Value) {
 	switch c := container.(type) {
 	case *[]Value:
@@ -534,21 +1396,74 @@ func (interp *interpreter) assignSubscript(pos Position, container, subscript, v
 		}
 	case map[string]Value:
 		if s, ok := subscript.(string); ok {
+			if _, ok := c[s]; !ok {
+				interp.modified(c)
+			}
 			c[s] = value
 		} else {
 			panic(typeError(pos, "map subscript must be a str"))
 		}
+	case *record:
+		if s, ok := subscript.(string); ok {
+			if !c.typ.hasField(s) {
+				panic(valueError(pos, "%s has no field %q", c.typ.Name, s))
+			}
+			c.fields[s] = value
+		} else {
+			panic(typeError(pos, "%s subscript must be a str", c.typ.Name))
+		}
 	default:
-		panic(typeError(pos, "can only assign to subscript of list or map"))
+		panic(typeError(pos, "can only assign to subscript of list, map, or record"))
+	}
+}
+
+// Return the error to stop execution with when Config.Context is done
+func (interp *interpreter) timeoutError(pos Position) Error {
+	message := "execution canceled"
+	if interp.ctx.Err() == context.DeadlineExceeded {
+		message = "execution timed out"
+	}
+	return TimeoutError{message, pos, nil}
+}
+
+// Raise an error if Config.Context is done, give spawned tasks a turn,
+// handle any signal that has arrived, and close generators the program no
+// longer refers to. This is done before each statement, and on each
+// iteration of a loop with an empty body.
+func (interp *interpreter) checkpoint(pos Position) {
+	if interp.ctx != nil {
+		select {
+		case <-interp.ctx.Done():
+			panic(interp.timeoutError(pos))
+		default:
+		}
+	}
+	if interp.tasks.count > 0 || interp.tasks.err != nil {
+		interp.switchTasks()
+	}
+	if interp.signals != nil {
+		select {
+		case name := <-interp.signals:
+			interp.handleSignal(pos, name)
+		default:
+		}
+	}
+	if atomic.LoadInt32(&interp.generators.pending) != 0 {
+		interp.closeAbandoned()
 	}
 }
 
 func (interp *interpreter) executeStatement(s parser.Statement) {
 	interp.stats.Ops++
+	if interp.stats.Coverage != nil {
+		interp.stats.Coverage[s.Position()]++
+	}
+	interp.checkpoint(s.Position())
 	switch s := s.(type) {
 	case *parser.Assign:
 		switch target := s.Target.(type) {
 		case *parser.Variable:
+			interp.warnShadow(target.Position(), "assignment to", target.Name)
 			interp.assign(target.Name, interp.evaluate(s.Value))
 		case *parser.Subscript:
 			container := interp.evaluate(target.Container)
@@ -559,8 +1474,13 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 			// Parser should never get us here
 			panic("can only assign to variable or subscript")
 		}
+	case *parser.OuterAssign:
+		value := interp.evaluate(s.Value)
+		if !interp.assignOuter(s.Name, value) {
+			panic(nameError(s.Position(), "name %q not found in an outer scope", s.Name))
+		}
 	case *parser.If:
-		cond := interp.evaluate(s.Condition)
+		cond := interp.condition(s.Condition)
 		if c, ok := cond.(bool); ok {
 			if c {
 				interp.executeBlock(s.Body)
@@ -572,11 +1492,15 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 		}
 	case *parser.While:
 		for {
-			cond := interp.evaluate(s.Condition)
+			cond := interp.condition(s.Condition)
 			if c, ok := cond.(bool); ok {
 				if !c {
 					break
 				}
+				if len(s.Body) == 0 {
+					// There are no statements to check at
+					interp.checkpoint(s.Position())
+				}
 				interp.executeBlock(s.Body)
 			} else {
 				panic(typeError(s.Condition.Position(), "while condition must be bool, got %T", cond))
@@ -584,19 +1508,71 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 		}
 	case *parser.For:
 		iterable := interp.evaluate(s.Iterable)
-		iterator := getIterator(s.Iterable.Position(), iterable)
+		iterator, done := interp.loopIterator(s.Iterable.Position(), iterable)
+		defer done()
+		if s.ValueName != "" {
+			for iterator.HasNext() {
+				if len(s.Body) == 0 {
+					interp.checkpoint(s.Position())
+				}
+				key, value := iterator.Pair()
+				interp.assign(s.Name, key)
+				interp.assign(s.ValueName, value)
+				interp.executeBlock(s.Body)
+			}
+			break
+		}
 		for iterator.HasNext() {
+			if len(s.Body) == 0 {
+				interp.checkpoint(s.Position())
+			}
 			interp.assign(s.Name, iterator.Value())
 			interp.executeBlock(s.Body)
 		}
+	case *parser.Try:
+		if caught := interp.executeTry(s.Body); caught != nil {
+			interp.assign(s.ErrorName, caught)
+			interp.executeBlock(s.Catch)
+		}
+	case *parser.Match:
+		value := interp.evaluate(s.Value)
+		for _, c := range s.Cases {
+			bindings := make(map[string]Value)
+			if matchPattern(c.Pattern, value, bindings) {
+				for name, v := range bindings {
+					interp.assign(name, v)
+				}
+				interp.executeBlock(c.Body)
+				break
+			}
+		}
 	case *parser.ExpressionStatement:
 		interp.evaluate(s.Expression)
 	case *parser.FunctionDefinition:
 		closure := interp.vars[len(interp.vars)-1]
-		interp.assign(s.Name, &userFunction{s.Name, s.Parameters, s.Ellipsis, s.Body, closure})
+		if s.Struct != "" {
+			value, ok := interp.lookup(s.Struct)
+			if !ok {
+				panic(nameError(s.Position(), "name %q not found", s.Struct))
+			}
+			st, ok := value.(*structType)
+			if !ok {
+				panic(typeError(s.Position(), "%s is not a struct", s.Struct))
+			}
+			name := s.Struct + "." + s.Name
+			st.addMethod(s.Position(), &userFunction{name, s.Parameters, s.Ellipsis, s.Body, closure, s.Position(), s.Generator})
+			break
+		}
+		interp.warnShadow(s.Position(), "function", s.Name)
+		interp.assign(s.Name, &userFunction{s.Name, s.Parameters, s.Ellipsis, s.Body, closure, s.Position(), s.Generator})
+	case *parser.StructDefinition:
+		interp.warnShadow(s.Position(), "struct", s.Name)
+		interp.assign(s.Name, &structType{s.Name, s.Fields, make(map[string]*userFunction), s.Position()})
 	case *parser.Return:
 		result := interp.evaluate(s.Result)
 		panic(returnResult{result, s.Position()})
+	case *parser.Yield:
+		interp.yield(interp.evaluate(s.Value))
 	default:
 		// Parser should never get us here
 		panic(fmt.Sprintf("unexpected statement type %T", s))
@@ -611,50 +1587,185 @@ func (interp *interpreter) execute(prog *parser.Program) {
 
 func newInterpreter(config *Config) *interpreter {
 	interp := new(interpreter)
+	interp.stats = new(Stats)
+	interp.tasks = &tasks{locks: make(map[uintptr]*mutex)}
+	interp.versions = make(map[uintptr]*version)
+	interp.generators = &generators{paused: make(map[*generatorRun]bool)}
 	interp.pushScope(make(map[string]Value))
+	interp.builtins = make(map[string]bool)
 	for k, v := range builtins {
 		interp.assign(k, v)
+		interp.builtins[k] = true
+	}
+	for k, f := range config.Builtins {
+		interp.assign(k, externalBuiltin(k, f))
+		interp.builtins[k] = true
 	}
 	for k, v := range config.Vars {
 		interp.assign(k, v)
 	}
 	interp.args = config.Args
-	interp.stdin = config.Stdin
-	if interp.stdin == nil {
-		interp.stdin = os.Stdin
+	stdin := config.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
 	}
+	interp.stdin = bufio.NewReader(stdin)
 	interp.stdout = config.Stdout
 	if interp.stdout == nil {
 		interp.stdout = os.Stdout
 	}
+	interp.stderr = config.Stderr
+	if interp.stderr == nil {
+		interp.stderr = os.Stderr
+	}
+	interp.floorDivision = config.FloorDivision
+	interp.truthy = config.Truthy
+	if !config.NoWarnings {
+		interp.warned = make(map[string]bool)
+	}
+	interp.returnExit = config.ReturnExit
+	interp.signals = config.Signals
+	interp.traps = make(map[string]functionType)
+	interp.trapNotify = config.Trap
 	interp.exit = config.Exit
 	if interp.exit == nil {
 		interp.exit = os.Exit
 	}
+	interp.readFile = config.ReadFile
+	if interp.readFile == nil {
+		interp.readFile = ioutil.ReadFile
+	}
+	interp.includes = config.IncludePath
+	interp.included = config.Included
+	interp.writeFile = config.WriteFile
+	if interp.writeFile == nil {
+		interp.writeFile = func(filename string, data []byte) error {
+			return ioutil.WriteFile(filename, data, 0644)
+		}
+	}
+	interp.openFile = config.OpenFile
+	if interp.openFile == nil {
+		interp.openFile = func(filename string) (io.ReadCloser, error) {
+			return os.Open(filename)
+		}
+	}
+	interp.openFiles = make(map[string]io.ReadCloser)
+	interp.appendFile = config.AppendFile
+	if interp.appendFile == nil {
+		interp.appendFile = appendFile
+	}
+	interp.stat = config.Stat
+	if interp.stat == nil {
+		interp.stat = os.Stat
+	}
+	interp.readDir = config.ReadDir
+	if interp.readDir == nil {
+		interp.readDir = ioutil.ReadDir
+	}
+	interp.mkdir = config.Mkdir
+	if interp.mkdir == nil {
+		interp.mkdir = func(path string) error {
+			return os.MkdirAll(path, 0755)
+		}
+	}
+	interp.remove = config.Remove
+	if interp.remove == nil {
+		interp.remove = os.Remove
+	}
+	interp.now = config.Now
+	if interp.now == nil {
+		interp.now = time.Now
+	}
+	if config.Profile {
+		interp.stats.Profile = make(map[string]*FunctionProfile)
+	}
+	if config.Cover {
+		interp.stats.Coverage = make(map[Position]int)
+	}
+	interp.ctx = config.Context
+	interp.sandbox = config.Sandbox
 	return interp
 }
 
-// Evaluate takes a parsed Expression and interpreter config and evaluates the
-// expression, returning the Value of the expression, interpreter statistics,
-// and an error which is nil on success or an interpreter.Error if there's an
-// error.
-func Evaluate(expr parser.Expression, config *Config) (v Value, stats *Stats, err error) {
+// Interpreter is an interpreter whose global scope persists across calls to
+// its Execute and Evaluate methods, for example to run library programs
+// before a main program. Use New() to create an Interpreter.
+type Interpreter struct {
+	interp *interpreter
+}
+
+// New returns a new Interpreter with the given config.
+func New(config *Config) *Interpreter {
+	return &Interpreter{newInterpreter(config)}
+}
+
+// Evaluate evaluates the given parsed Expression in the interpreter's global
+// scope, returning the Value of the expression and an error which is nil on
+// success or an interpreter.Error if there's an error.
+func (i *Interpreter) Evaluate(expr parser.Expression) (v Value, err error) {
+	i.interp.tasks.acquire()
+	defer i.interp.tasks.release()
 	defer func() {
 		if r := recover(); r != nil {
 			// Convert to interpreter.Error or re-panic
+			if e, ok := r.(taskError); ok {
+				r = e.err
+			}
 			err = r.(Error)
 		}
 	}()
-	interp := newInterpreter(config)
-	v = interp.evaluate(expr)
-	stats = &interp.stats
-	return
+	return i.interp.evaluate(expr), nil
 }
 
-// Execute takes a parsed Program and interpreter config and interprets the
-// program. Return interpreter statistics, and an error which is nil on
-// success or an interpreter.Error if there's an error.
-func Execute(prog *parser.Program, config *Config) (stats *Stats, err error) {
+// Globals returns a copy of the interpreter's global variables, not
+// including builtins, as the globals() builtin does.
+func (i *Interpreter) Globals() map[string]Value {
+	i.interp.tasks.acquire()
+	defer i.interp.tasks.release()
+	return i.interp.scopeMap(i.interp.vars[0]).(map[string]Value)
+}
+
+// Repr returns v formatted as the repr() builtin formats it, for example
+// to show the result of Evaluate. It returns an interpreter.Error if a
+// __str hook raises an error.
+func (i *Interpreter) Repr(v Value) (s string, err error) {
+	i.interp.tasks.acquire()
+	defer i.interp.tasks.release()
+	defer func() {
+		if r := recover(); r != nil {
+			// Convert to interpreter.Error or re-panic
+			err = r.(Error)
+		}
+	}()
+	return valueString(v, true, nil, i.interp.strHook(Position{})), nil
+}
+
+// Execute interprets the given parsed Program in the interpreter's global
+// scope. Return an error which is nil on success or an interpreter.Error if
+// there's an error. Functions started with spawn() keep running after
+// Execute returns, until they finish or one of them raises an error.
+func (i *Interpreter) Execute(prog *parser.Program) error {
+	return i.execute(prog, false)
+}
+
+// Execute prog, stopping any spawned functions before returning if
+// stopTasks is true
+func (i *Interpreter) execute(prog *parser.Program, stopTasks bool) (err error) {
+	t := i.interp.tasks
+	t.acquire()
+	defer t.release()
+	defer func() {
+		if err == nil && t.err != nil {
+			// A task raised an error after the last statement
+			err, t.err = t.err, nil
+		}
+		if stopTasks {
+			if stopErr := t.shutdown(); err == nil {
+				err = stopErr
+			}
+			i.interp.closeGenerators()
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			switch e := r.(type) {
@@ -662,13 +1773,69 @@ func Execute(prog *parser.Program, config *Config) (stats *Stats, err error) {
 				err = e
 			case returnResult:
 				err = runtimeError(e.pos, "can't return at top level")
+			case taskError:
+				err = e.err
 			default:
 				panic(r)
 			}
 		}
 	}()
-	interp := newInterpreter(config)
+	interp := i.interp
+	if interp.stats.Coverage != nil {
+		parser.Walk(prog.Statements, func(node parser.Node) bool {
+			if s, ok := node.(parser.Statement); ok {
+				interp.stats.Coverage[s.Position()] += 0
+			}
+			return true
+		})
+	}
 	interp.execute(prog)
-	stats = &interp.stats
-	return
+	return nil
+}
+
+// Stop stops any functions started with spawn() that are still running,
+// and any generators paused at a yield, and removes the program's signal
+// handlers. It returns an interpreter.Error if the spawned functions are
+// all waiting for locks, as that's a deadlock.
+func (i *Interpreter) Stop() error {
+	t := i.interp.tasks
+	t.acquire()
+	defer t.release()
+	defer i.interp.closeGenerators()
+	defer i.interp.untrapAll()
+	return t.shutdown()
+}
+
+// Stats returns the interpreter statistics accumulated over all calls to
+// Execute and Evaluate.
+func (i *Interpreter) Stats() *Stats {
+	return i.interp.stats
+}
+
+// Evaluate takes a parsed Expression and interpreter config and evaluates the
+// expression, returning the Value of the expression, interpreter statistics,
+// and an error which is nil on success or an interpreter.Error if there's an
+// error.
+func Evaluate(expr parser.Expression, config *Config) (Value, *Stats, error) {
+	interp := New(config)
+	v, err := interp.Evaluate(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, interp.Stats(), nil
+}
+
+// Execute takes a parsed Program and interpreter config and interprets the
+// program. Return interpreter statistics, and an error which is nil on
+// success or an interpreter.Error if there's an error. Functions started
+// with spawn() that are still running when the program finishes are
+// stopped.
+func Execute(prog *parser.Program, config *Config) (*Stats, error) {
+	interp := New(config)
+	defer interp.interp.closeFiles()
+	err := interp.execute(prog, true)
+	if err != nil {
+		return nil, err
+	}
+	return interp.Stats(), nil
 }
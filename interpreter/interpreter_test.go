@@ -4,19 +4,30 @@
 // (default) and the littlelang version, use a command line like:
 //
 // go test littlelang/interpreter -exe ~/go/src/littlelang/littlelang -interp ~/go/src/littlelang/littlelang.ll
+//
+// The test programs themselves are in the internal/corpus package, so that
+// "littlelang selftest" can run them too.
 
 package interpreter_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/benhoyt/littlelang/internal/corpus"
 	"github.com/benhoyt/littlelang/interpreter"
 	"github.com/benhoyt/littlelang/parser"
 )
@@ -34,381 +45,25 @@ func TestMain(m *testing.M) {
 }
 
 func TestExecute(t *testing.T) {
-	tests := []struct {
-		source string
-		errpos string
-		output string
-	}{
-		// Miscellaneous inputs
-		{``, "", ``},
-
-		// == binary operator
-		{`print(nil==nil, nil==true, nil==false, nil==0, nil==1, nil=="", nil=="foo", nil==[], nil==[1], nil=={}, nil=={"a": 1})`, "",
-			`true false false false false false false false false false false`},
-		{`print(true==nil, true==true, true==false, true==0, true==1, true=="", true=="foo", true==[], true==[1], true=={}, true=={"a": 1})`, "",
-			`false true false false false false false false false false false`},
-		{`print(false==nil, false==true, false==false, false==0, false==1, false=="", false=="foo", false==[], false==[1], false=={}, false=={"a": 1})`, "",
-			`false false true false false false false false false false false`},
-		{`print(nil==nil, nil==true, nil==false, nil==0, nil==1, nil=="", nil=="foo", nil==[], nil==[1], nil=={}, nil=={"a": 1})`, "",
-			`true false false false false false false false false false false`},
-		{`print(0==nil, 0==true, 0==false, 0==0, 0==1, 0=="", 0=="foo", 0==[], 0==[1], 0=={}, 0=={"a": 1})`, "",
-			`false false false true false false false false false false false`},
-		{`print(1==nil, 1==true, 1==false, 1==0, 1==1, 1=="", 1=="foo", 1==[], 1==[1], 1=={}, 1=={"a": 1})`, "",
-			`false false false false true false false false false false false`},
-		{`print(1234==1234, 1234==4321, 0==-1, 0==0, 1==0, 0==1, 1==1)`, "",
-			`true false false true false false true`},
-		{`print(""=="", ""=="foo", "foo"=="", "foo"=="foo", "Foo"=="foo", "foo"=="bar")`, "",
-			`true false false true false false`},
-		{`print([]==[], []==[nil], [1]==[0], [1]==[1], [{"foo": 1}]==[{"foo": 1}], [["bar"], 1]==[["foo"], 1])`, "",
-			`true false false true true false`},
-		{`x = []  y = []  print(x==y)  append(y, 42)  print(x==y)  append(x, 42)  print(x==y)`, "",
-			"true\nfalse\ntrue"},
-		{`print({}=={}, {}=={"a": nil}, {"a": 1}=={"b": 2}, {"a": 1}=={"a": 1}, {"a": [1]}=={"a": [1]}, {"a": [1]}=={"a": [2]})`, "",
-			`true false false true true false`},
-		{`x = {}  y = {}  print(x==y)  y.a=42  print(x==y)  x.a=42  print(x==y)`, "",
-			"true\nfalse\ntrue"},
-		{`func f() {}  func g() {}  print(f==g, f==f, g==g)`, "", `false true true`},
-
-		// "in" binary operator
-		{`print("foo" in "foobar", "foo" in "bar", "" in "", "" in "foo", "foo" in "Foobar")`, "",
-			`true false true true false`},
-		{`1234 in "foo"`, "type error at 1:6", "in str requires str on left side"},
-		{`"foo" in 1234`, "type error at 1:7", "in requires str, list, or map on right side"},
-		{`print(nil in [], nil in [nil], 1 in [], 1 in [1], 1 in [1, 1, 1], 1 in [0, 1, 2], [1] in [0, 1, 2], [1] in [0, [1], 2])`, "",
-			`false true false true true true false true`},
-		{`print(1234 in {})`, "type error at 1:12", "in map requires str on left side"},
-		{`print("" in {}, "" in {"": 1}, "a" in {}, "a" in {"a": 1}, "a" in {"b": 2, "a": 1}, "a" in {"A": 1, "B": []})`, "",
-			`false true false true true false`},
-
-		// comparison binary operators
-		{`print(nil < "")`, "type error at 1:11", "comparison requires two ints or two strs (or lists of ints or strs)"},
-		{`print(1 < "foo")`, "type error at 1:9", "comparison requires two ints or two strs (or lists of ints or strs)"},
-		{`print(0 < 1, 1 < 1234, 1 < 1, 1 < 2, 0 < 0, -1 < 0, -1 < 1, 1 < -1)`, "",
-			`true true false true false true true false`},
-		{`print("a" < "b", "foo" < "foo", "foo" < "foobar", "foo" < "Foo", "bar" < "foo", "foo" < "bar", "abc" < "defghi")`, "",
-			`true false true false true false true`},
-		{`print([] < [], [1] < [1, 2], [1, 2] < [1], [[1], [2]] < [[1], [3]])`, "",
-			`false true false true`},
-		{`print(1 <= 0, 1 <= 1, 1 <= 2)`, "", "false true true"},
-		{`print(1 > 0, 1 > 1, 1 > 2)`, "", "true false false"},
-		{`print(1 >= 0, 1 >= 1, 1 >= 2)`, "", "true true false"},
-
-		// + binary operator
-		{`print(1 + 2, -3 + 4, 3 + -4, 1 + 2*3, (1+2)*3)`, "", "3 1 -1 7 9"},
-		{`print(1 + "foo")`, "type error at 1:9", "+ requires two ints, strs, lists, or maps"},
-		{`s="foo"  print(s + "bar", s)`, "", "foobar foo"},
-		{`x=[1, 2]  y=[3, 4]  print(x+y, x, y)`, "", "[1, 2, 3, 4] [1, 2] [3, 4]"},
-		{`x={"a": 1}  y={"b": 2}  print(x+y, x, y)`, "", `{"a": 1, "b": 2} {"a": 1} {"b": 2}`},
-		{`print({"a": 1} + {"a": 2, "b": 3})`, "", `{"a": 2, "b": 3}`},
-
-		// - binary operator
-		{`print(1 - 2, -3 - 4, 3 - -4)`, "", "-1 -7 7"},
-		{`print(1 - "foo")`, "type error at 1:9", "- requires two ints"},
-
-		// * binary operator
-		{`print(2 * 3, 3 * 4, -1 * 7, 3 * -4)`, "", "6 12 -7 -12"},
-		{`print(3 * "foo", "ba" * 3)`, "", "foofoofoo bababa"},
-		{`lst=[1,2]  print([]*3, lst*3, 3*lst)`, "", "[] [1, 2, 1, 2, 1, 2] [1, 2, 1, 2, 1, 2]"},
-		{`print(1 * true)`, "type error at 1:9", "* requires two ints or a str or list and an int"},
-
-		// / binary operator
-		{`print(9 / 3, 10 / 3, 10 / 2, 10 / -2, -10 / 2)`, "", "3 3 5 -5 -5"},
-		{`print(1 / "foo")`, "type error at 1:9", "/ requires two ints"},
-		{`print(3 / 0)`, "value error at 1:9", "can't divide by zero"},
-
-		{`print(9 % 3, 10 % 3, 10 % -3, -10 % 3)`, "", "0 1 1 -1"},
-		{`print(1 % "foo")`, "type error at 1:9", "% requires two ints"},
-		{`print(3 % 0)`, "value error at 1:9", "can't divide by zero"},
-
-		// Unary operators
-		{`print(not true, not false, not not true, not 1==0)`, "", "false true true true"},
-		{`print(not nil)`, "type error at 1:7", "not requires a bool"},
-		{`print(-3, --4, ---4, -0)`, "", "-3 4 -4 0"},
-		{`print(-"foo")`, "type error at 1:7", "unary - requires an int"},
-
-		// Logical and
-		{`print(print("a") == nil and print("b") == nil)`, "", "a\nb\ntrue"},
-		{`print(print("a") == nil and print("b") != nil)`, "", "a\nb\nfalse"},
-		{`print(print("a") != nil and print("b") == nil)`, "", "a\nfalse"},
-		{`print(print("a") != nil and print("b") != nil)`, "", "a\nfalse"},
-
-		// Logical or
-		{`print(print("a") == nil or print("b") == nil)`, "", "a\ntrue"},
-		{`print(print("a") == nil or print("b") != nil)`, "", "a\ntrue"},
-		{`print(print("a") != nil or print("b") == nil)`, "", "a\nb\ntrue"},
-		{`print(print("a") != nil or print("b") != nil)`, "", "a\nb\nfalse"},
-
-		// Subscript
-		{`s = "foo"  print(s[0], s[1], s[2])`, "", "f o o"},
-		{`s = "“smart quotes”"  print([s[0], s[1], s[2], s[3]])`, "", `["\xe2", "\x80", "\x9c", "s"]`},
-		{`s = "foo"  print(s[-1])`, "value error at 1:20", "subscript -1 out of range"},
-		{`s = "foo"  print(s[3])`, "value error at 1:20", "subscript 3 out of range"},
-		{`s = "foo"  print(s[nil])`, "type error at 1:20", "str subscript must be an int"},
-		{`lst = [1,2,3]  print(lst[0], lst[1], lst[2])`, "", "1 2 3"},
-		{`lst = [1,2,3]  print(lst[-1])`, "value error at 1:26", "subscript -1 out of range"},
-		{`lst = [1,2,3]  print(lst[3])`, "value error at 1:26", "subscript 3 out of range"},
-		{`lst = [1,2,3]  print(lst[nil])`, "type error at 1:26", "list subscript must be an int"},
-		{`m = {"a": 1, "b": 2}  print(m["a"], m.a, m["b"], m.b)`, "", `1 1 2 2`},
-		{`m = {"a": 1, "b": 2}  print(m["x"])`, "value error at 1:31", `key not found: "x"`},
-		{`m = {"a": 1, "b": 2}  print(m[1])`, "type error at 1:31", `map subscript must be a str`},
-
-		// Function calls
-		{`print(print(1), print(2))`, "", "1\n2\nnil nil"},
-		{`f = print  f()  f(1)  f(1, 2)`, "", "\n1\n1 2"},
-		{`func add(a, b) { return a+b }  print(add(2, 7))`, "", "9"},
-		{`n = func(){ return 1 + 2 }()  print(n)`, "", "3"},
-		{`print(1, 2, [3, 4])`, "", "1 2 [3, 4]"},
-		{`print(1, 2, [3, 4]...)`, "", "1 2 3 4"},
-		{`print(nil, 0, true, false, "s", [1, 2], {"a": 3})`, "", `nil 0 true false s [1, 2] {"a": 3}`},
-		{`print([]...)`, "", ""},
-		{`print([1]...)`, "", "1"},
-		{`x = [1, 2, 3]  print(x...)`, "", "1 2 3"},
-		{`x=0  func f() { x=1 }  f()  print(x)`, "", "0"},
-		{`x=[0]  func f() { x[0]=1 }  f()  print(x[0])`, "", "1"},
-		{`
-func make_adder(n) {
-    func adder(x) {
-        return x + n
-    }
-    return adder
-}
-add5 = make_adder(5)
-add3 = make_adder(3)
-print(add5(1), add5(2), add3(10), add3(20))
-`, "", "6 7 13 23"},
-		{`
-func make_counter() {
-    i = [0]
-    func count() {
-        i[0] = i[0] + 1
-        print(i[0])
-    }
-    return count
-}
-counter = make_counter()
-counter()
-counter()
-counter()
-`, "", "1\n2\n3"},
-		{`f = 1234  f()`, "type error at 1:11", "can't call non-function type int"},
-		{`func add(nums...) { sum = 0  for n in nums { sum = sum + n }  return sum }  print(add(), add(42), add(3, 4, 5), add(range(10)...))`, "",
-			"0 42 12 45"},
-		{`return 1`, "runtime error at 1:1", "can't return at top level"},
-
-		// Literals
-		{`print(1234)`, "", `1234`},
-		{`print("foo")`, "", `foo`},
-		{`print(true)`, "", `true`},
-		{`print(false)`, "", `false`},
-		{`print(nil)`, "", `nil`},
-		{`print([1,2,3], {"a": 1, "b": 2})`, "", `[1, 2, 3] {"a": 1, "b": 2}`},
-
-		// Variables
-		{`a=1  b=2  a=a+b+1  print(a, b)`, "", "4 2"},
-		{`asdf`, "name error at 1:1", `name "asdf" not found`},
-		{`func f() { return a }  f()`, "name error at 1:19", `name "a" not found`},
-		{`func f() { return a }  a=42  print(f())`, "", `42`},
-
-		// Function expression
-		{`print(func() {})`, "", "<func>"},
-		{`n = ["z", "A", "b", "a"]  sort(n, func(x) { return lower(x) })  print(n)`, "", `["A", "a", "b", "z"]`},
-		{`a=40  b=2  func foo() { return func() { return a+b } }  print(foo()())`, "", "42"},
-
-		// Assign
-		{`x = 4  print(x)`, "", "4"},
-		{`x = 4  func f() { x = 8  print(x) }  print(x)  f()  print(x)`, "", "4\n8\n4"},
-		{`func add(a, b) { a = a  b = b  return a + b }  print(add(3, 4))`, "", "7"},
-		{`func f() { x = 4}  print(x)`, "name error at 1:26", `name "x" not found`},
-		{`x = [1,2,3]  x[0] = 3  x[2] = 1  print(x)`, "", "[3, 2, 1]"},
-		{`x = [1,2,3]  x[-1]`, "value error at 1:16", "subscript -1 out of range"},
-		{`x = [1,2,3]  x[3]`, "value error at 1:16", "subscript 3 out of range"},
-		{`x = [1,2,3]  x["a"]`, "type error at 1:16", "list subscript must be an int"},
-		{`m = {"a": 1}  m["a"] = 2  m.b = 3  print(m)`, "", `{"a": 2, "b": 3}`},
-		{`m = {"a": 1}  m[0] = 2`, "type error at 1:17", `map subscript must be a str`},
-		{`lst = [1,2,3]  func f() { return lst }  func g() { return 1 }  f()[g()] = 2+2+2  print(lst)`, "", `[1, 6, 3]`},
-		{`n = 1234  n[0] = 42`, "type error at 1:13", "can only assign to subscript of list or map"},
-
-		// If
-		{`if true { print(1) }`, "", "1"},
-		{`if false { print(1) }`, "", ""},
-		{`if true { print(1) } else { print(0) }`, "", "1"},
-		{`if false { print(1) } else { print(0) }`, "", "0"},
-		{`if 1==0 { print(1) } else if 0==1 { print(2) } else { print(3) }`, "", "3"},
-		{`if 1234 { print(1) }`, "type error at 1:4", "if condition must be bool, got int"},
-
-		// While
-		{`i = 0  while i < 5 { print(i)  i=i+1 }  print("DONE", i)`, "", "0\n1\n2\n3\n4\nDONE 5"},
-		{`print("S")  while false { print("hi") }  print("F")`, "", "S\nF"},
-
-		// For
-		{`i="foo"  for i in range(5) { print(i) }  print(i)`, "", "0\n1\n2\n3\n4\n4"},
-		{`i="foo"  for i in range(5) { print(i) }  print(i)`, "", "0\n1\n2\n3\n4\n4"},
-		{`s = "“foo”"  for c in s { print(c) }  print(c)`, "", "“\nf\no\no\n”\n”"},
-		{`lst = [1,2,3]  for x in lst { print(x) }  print(lst)`, "", "1\n2\n3\n[1, 2, 3]"},
-		{`lst = []  for x in lst { print(x) }  print(lst)`, "", "[]"},
-		{`m = {"a": 1, "b": 2}  keys = []  for k in m { append(keys, k) }  sort(keys)  print(keys)`, "",
-			`["a", "b"]`},
-		{`for x in {"a": 1} { print(x) }`, "", "a"},
-		{`for x in {} { print(x) }`, "", ""},
-
-		// ExpressionStatement
-		{`1234  print("x")  4321  print(print)`, "", "x\n<builtin print>"},
-
-		// append() builtin
-		{`x=[0]  append(x, 1)  append(x, 2, 3, 4)  print(x)`, "", `[0, 1, 2, 3, 4]`},
-		{`x=[0]  y=[1,2,3]  append(x, y)  print(x, y)`, "", `[0, [1, 2, 3]] [1, 2, 3]`},
-		{`x=[0]  y=[1,2,3]  append(x, y...)  print(x, y)`, "", `[0, 1, 2, 3] [1, 2, 3]`},
-		{`x=[0]  y=[]  append(x, y...)  print(x, y)`, "", `[0] []`},
-		{`x=[0]  append(x)  print(x)`, "", `[0]`},
-		{`x=0  append(x, 1234)`, "type error at 1:6", `append() requires first argument to be list`},
-
-		// args() builtin
-		{`print(args())`, "", `["one", "2", "THREE"]`},
-		{`args(1)`, "type error at 1:1", "args() requires 0 args, got 1"},
-
-		// char() builtin
-		{`print(char(123))`, "", `{`},
-		{`print(char(8220))`, "", `“`},
-		{`char(1, 2)`, "type error at 1:1", "char() requires 1 arg, got 2"},
-		{`char("x")`, "type error at 1:1", "char() requires an int, not str"},
-
-		// exit() builtin
-		// Skip these for now as they exit the littlelang.ll version:
-		// {`exit()`, "", "exit(0)"},
-		// {`exit(42)`, "", "exit(42)"},
-		{`exit(1, 2)`, "type error at 1:1", "exit() requires 0 or 1 args, got 2"},
-		{`exit("x")`, "type error at 1:1", "exit() requires an int, not str"},
-
-		// find() builtin
-		{`print(find("", ""), find("", "foo"), find("foo", ""), find("foo", "foo"), find("foo", "o"), find("foz", "z"), find("foo", "bar"))`, "", "0 -1 0 0 1 2 -1"},
-		{`find("foo", 1)`, "type error at 1:1", "find() on str requires second argument to be a str"},
-		{`print(find([1,2,3], 2), find([1,2,3], 1), find([1,2,3], 3), find([1,2,3], 4), find([], 0))`, "", "1 0 2 -1 -1"},
-		{`print(find([[1], [2], [3]], [2]), find([[1], [2], [3]], 2))`, "", "1 -1"},
-		{`print(find([1, 2, 3], nil), find([1, nil, 3], nil))`, "", "-1 1"},
-		{`print(find())`, "type error at 1:7", "find() requires 2 args, got 0"},
-		{`print(find(1234, 1))`, "type error at 1:7", "find() requires first argument to be a str or list"},
-
-		// int() builtin
-		{`print(int(1234), type(int(1234)))`, "", "1234 int"},
-		{`print(int("1234"), type(int("1234")))`, "", "1234 int"},
-		{`print(int("abc"), type(int("abc")))`, "", "nil nil"},
-		{`print(int(nil))`, "type error at 1:7", "int() requires an int or a str"},
-		{`print(int())`, "type error at 1:7", "int() requires 1 arg, got 0"},
-
-		// join() builtin
-		{`print(join(["abc", "de", "f", "", "."], "|"))`, "", "abc|de|f||."},
-		{`print(join(["abc", "de", "f", "", "."], ""))`, "", "abcdef."},
-		{`print(join([], "|"))`, "", ""},
-		{`print(join([], ""))`, "", ""},
-		{`print(join(["x", 1], ""))`, "type error at 1:7", "join() requires all list elements to be strs"},
-		{`print(join("", ""))`, "type error at 1:7", "join() requires first argument to be a list"},
-		{`print(join())`, "type error at 1:7", "join() requires 2 args, got 0"},
-
-		// len() builtin
-		{`print(len("foo"), len("“smart quotes”"), len(""))`, "", "3 18 0"},
-		{`print(len([]), len([1, 2, 3]))`, "", "0 3"},
-		{`print(len({}), len({"a": 1, "b": 2, "c": 3}))`, "", "0 3"},
-		{`print(len(42))`, "type error at 1:7", "len() requires a str, list, or map"},
-		{`print(len())`, "type error at 1:7", "len() requires 1 arg, got 0"},
-
-		// lower() builtin
-		{`print(lower(""), lower("abc"), lower("FoO"), lower("BAR"))`, "", " abc foo bar"},
-		{`print(lower(42))`, "type error at 1:7", "lower() requires a str"},
-		{`print(lower())`, "type error at 1:7", "lower() requires 1 arg, got 0"},
-
-		// print() builtin
-		{`print()  print("foo")  print("x", 42)  print([1, 2, 3]...)`, "", "\nfoo\nx 42\n1 2 3"},
-		{`print(nil, true, false, 1, "x", ["y"], {"z": 2}, func() {})`, "", `nil true false 1 x ["y"] {"z": 2} <func>`},
-
-		// range() builtin
-		{`print(range(0), range(5))`, "", "[] [0, 1, 2, 3, 4]"},
-		{`range(-1)`, "value error at 1:1", "range() argument must not be negative"},
-		{`range(nil)`, "type error at 1:1", "range() requires an int"},
-
-		// read() builtin
-		{`print(read())`, "", "dummy stdin"},
-		{`read(1)`, "type error at 1:1", "read() argument must be a str"},
-		{`read("x", "y")`, "type error at 1:1", "read() requires 0 or 1 args, got 2"},
-
-		// rune() builtin
-		{`print(rune("A"), rune(" "), rune("“"))`, "", "65 32 8220"},
-		{`print(rune(42))`, "type error at 1:7", "rune() requires a str"},
-		{`print(rune("ab"))`, "value error at 1:7", "rune() requires a 1-character str"},
-		{`print(rune())`, "type error at 1:7", "rune() requires 1 arg, got 0"},
-
-		// slice() builtin
-		{`print(slice("abc", 0, 3), slice("abc", 1, 3), slice("abc", 0, 2))`, "", "abc bc ab"},
-		{`print(slice("foo", 0, 0), slice("", 0, 0), slice("“", 0, 3))`, "", "  “"},
-		{`print(slice([1,2,3], 0, 3), slice([1,2,3], 1, 3), slice([1,2,3], 0, 2))`, "", "[1, 2, 3] [2, 3] [1, 2]"},
-		{`x=[1,2,3]  y=slice(x, 0, 1)  print(x, y)  y[0]=4  print(x, y)`, "", "[1, 2, 3] [1]\n[1, 2, 3] [4]"},
-		{`slice("foo", -1, 0)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`slice("foo", 3, 1)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`slice("foo", 1, 4)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`slice([1,2,3], -1, 0)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`slice([1,2,3], 3, 1)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`slice([1,2,3], 1, 4)`, "value error at 1:1", "slice() start or end out of bounds"},
-		{`print(slice(42, 0, 0))`, "type error at 1:7", "slice() requires first argument to be a str or list"},
-		{`print(slice("x", 0, "z"))`, "type error at 1:7", "slice() requires start and end to be ints"},
-		{`print(slice("x", "y", 0))`, "type error at 1:7", "slice() requires start and end to be ints"},
-
-		// sort() builtin
-		{`lst = [3,1,2]  sort(lst)  print(lst)  sort(lst)  print(lst)`, "", "[1, 2, 3]\n[1, 2, 3]"},
-		{`lst = ["y","x","Z"]  sort(lst)  print(lst)`, "", `["Z", "x", "y"]`},
-		{`lst = []  sort(lst)  print(lst)`, "", "[]"},
-		{`lst = [42]  sort(lst)  print(lst)`, "", "[42]"},
-		{`sort([1, "x"])`, "type error at 1:1", "comparison requires two ints or two strs (or lists of ints or strs)"},
-		{`func f(x) { print("KEY:", x)  return -x }  lst=[1,3,2]  sort(lst, f)  print(lst)`, "",
-			"KEY: 1\nKEY: 3\nKEY: 2\n[3, 2, 1]"},
-		{`lst = [["B", 42], ["a", 43], ["a", 42], ["z", 0]]  sort(lst)  print(lst)`, "",
-			`[["B", 42], ["a", 42], ["a", 43], ["z", 0]]`},
-		{`lst = [["B", 42], ["a", 43], ["a", 42], ["z", 0]]  sort(lst, func(x) { return x[1] })  print(lst)  sort(lst, func(x) { return lower(x[0]) })  print(lst)`, "",
-			`[["z", 0], ["B", 42], ["a", 42], ["a", 43]]
-[["a", 42], ["a", 43], ["B", 42], ["z", 0]]`},
-		{`lst = [["B", 42], ["a", 43], ["a", 42], ["z", 0]]  sort(lst, func(x) { return [lower(x[0]), x[1]] })  print(lst)`, "",
-			`[["a", 42], ["a", 43], ["B", 42], ["z", 0]]`},
-
-		// split() builtin
-		{`print(split("\tx\ry\nz ", nil), split("xyz", nil), split("", nil))`, "", `["x", "y", "z"] ["xyz"] []`},
-		{`print(split("\tx\ry\nz "), split("xyz"), split(""))`, "", `["x", "y", "z"] ["xyz"] []`},
-		{`print(split("x|y|z", "|"), split("xyz", "|"), split("", "|"))`, "", `["x", "y", "z"] ["xyz"] [""]`},
-		{`split()`, "type error at 1:1", "split() requires 1 or 2 args, got 0"},
-		{`split("x", 42)`, "type error at 1:1", "split() requires separator to be a str or nil"},
-
-		// str() builtin
-		{`print(str("foo"))  print(str("x"), str(42))  print(str([1, 2, 3]))`, "", "foo\nx 42\n[1, 2, 3]"},
-		{`print(str(nil), str(true), str(false), str(1), str("x"), str(["y"]), str({"z": 2}), str(func() {}))`, "",
-			`nil true false 1 x ["y"] {"z": 2} <func>`},
-		{`str()`, "type error at 1:1", "str() requires 1 arg, got 0"},
-
-		// type() builtin
-		{`print(type(nil), type(true), type(false), type(0), type("x"), type([]), type({}), type(func() {}))`, "",
-			"nil bool bool int str list map func"},
-		{`type()`, "type error at 1:1", "type() requires 1 arg, got 0"},
-
-		// upper() builtin
-		{`print(upper(""), upper("abc"), upper("FoO"), upper("BAR"))`, "", " ABC FOO BAR"},
-		{`print(upper(42))`, "type error at 1:7", "upper() requires a str"},
-		{`print(upper())`, "type error at 1:7", "upper() requires 1 arg, got 0"},
-	}
-
 	// Run tests against Go interpreter
-	for _, test := range tests {
-		testName := "go_" + test.source
+	for _, test := range corpus.Tests {
+		testName := "go_" + test.Source
 		if len(testName) > 70 {
 			testName = testName[:70]
 		}
 		t.Run(testName, func(t *testing.T) {
-			prog, err := parser.ParseProgram([]byte(test.source))
+			prog, err := parser.ParseProgram([]byte(test.Source))
 			if err != nil {
 				t.Fatalf("%s", err)
 			}
-			stdin := bytes.NewBuffer([]byte("dummy stdin"))
+			stdin := bytes.NewBuffer([]byte(corpus.Stdin))
 			stdout := &bytes.Buffer{}
 			config := &interpreter.Config{
-				Args:   []string{"one", "2", "THREE"},
-				Stdin:  stdin,
-				Stdout: stdout,
-				Exit:   func(n int) { fmt.Fprintf(stdout, "exit(%d)", n) },
+				Args:       corpus.Args,
+				Stdin:      stdin,
+				Stdout:     stdout,
+				Exit:       func(n int) { fmt.Fprintf(stdout, "exit(%d)", n) },
+				NoWarnings: true,
 			}
 			_, err = interpreter.Execute(prog, config)
 			var output string
@@ -419,23 +74,23 @@ counter()
 					t.Fatalf("expected \": \" in error output, got %q", errOutput)
 				}
 				errpos := fields[0]
-				if errpos != test.errpos {
-					t.Fatalf("expected errpos %q, got %q", test.errpos, errpos)
+				if errpos != test.ErrPos {
+					t.Fatalf("expected errpos %q, got %q", test.ErrPos, errpos)
 				}
 				output = fields[1]
 			} else {
 				output = strings.TrimRight(stdout.String(), "\n")
 			}
-			if output != test.output {
-				t.Fatalf("expected:\n\"%s\"\ngot:\n\"%s\"", test.output, output)
+			if output != test.Output {
+				t.Fatalf("expected:\n\"%s\"\ngot:\n\"%s\"", test.Output, output)
 			}
 		})
 	}
 
 	// Run tests against external littlelang interpreter
 	if exePath != "" {
-		for _, test := range tests {
-			testName := "ll_" + test.source
+		for _, test := range corpus.Tests {
+			testName := "ll_" + test.Source
 			if len(testName) > 70 {
 				testName = testName[:70]
 			}
@@ -445,48 +100,1455 @@ counter()
 					t.Fatalf("error creating temp file: %v", err)
 				}
 				defer os.Remove(srcFile.Name())
-				_, err = srcFile.Write([]byte(test.source))
+				_, err = srcFile.Write([]byte(test.Source))
 				if err != nil {
 					t.Fatalf("error writing temp file: %v", err)
 				}
 
-				cmd := exec.Command(exePath, interpPath, srcFile.Name(), "one", "2", "THREE")
+				cmd := exec.Command(exePath, append([]string{interpPath, srcFile.Name()}, corpus.Args...)...)
 				stdin, err := cmd.StdinPipe()
 				if err != nil {
 					t.Fatalf("error creating stdin pipe: %v", err)
 				}
-				_, err = stdin.Write([]byte("dummy stdin"))
+				_, err = stdin.Write([]byte(corpus.Stdin))
 				if err != nil {
 					t.Fatalf("error writing temp file: %v", err)
 				}
 				stdin.Close()
 
+				var stderr bytes.Buffer
+				cmd.Stderr = &stderr
 				outBytes, err := cmd.Output()
 				output := string(outBytes)
 				if err != nil {
-					if test.errpos == "" {
+					if test.ErrPos == "" {
 						t.Fatalf("expected no error, got error %v", err)
 					}
-					lines := strings.Split(output, "\n")
-					if len(lines) < 2 {
-						t.Fatalf("expected at least two lines, got %d", len(lines))
+					// Errors from the Go interpreter are reported on stderr
+					// with the message first; littlelang.ll prints its own
+					// errors as the last line of stdout
+					var errLine string
+					if stderr.Len() > 0 {
+						errLine = strings.SplitN(stderr.String(), "\n", 2)[0]
+					} else {
+						lines := strings.Split(output, "\n")
+						if len(lines) < 2 {
+							t.Fatalf("expected at least two lines, got %d", len(lines))
+						}
+						errLine = lines[len(lines)-2]
 					}
-					lastLine := lines[len(lines)-2]
-					fields := strings.SplitN(lastLine, ": ", 2)
+					fields := strings.SplitN(errLine, ": ", 2)
 					if len(fields) < 2 {
-						t.Fatalf("expected \": \" in error output, got %q", lastLine)
+						t.Fatalf("expected \": \" in error output, got %q", errLine)
 					}
 					output = fields[1]
 				} else {
 					output = strings.TrimRight(output, "\n")
-					if test.errpos != "" {
-						t.Fatalf("expected error %q, got no error (output %q)", test.errpos, output)
+					if test.ErrPos != "" {
+						t.Fatalf("expected error %q, got no error (output %q)", test.ErrPos, output)
 					}
 				}
-				if output != test.output {
-					t.Fatalf("expected:\n\"%s\"\ngot:\n\"%s\"", test.output, output)
+				if output != test.Output {
+					t.Fatalf("expected:\n\"%s\"\ngot:\n\"%s\"", test.Output, output)
 				}
 			})
 		}
 	}
 }
+
+func TestProfile(t *testing.T) {
+	source := `
+func fib(n) {
+    if n < 2 {
+        return n
+    }
+    return fib(n-1) + fib(n-2)
+}
+lst = [3, 1, 2]
+sort(lst, func(x) { return -x })
+print(fib(10), lst)
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stats, err := interpreter.Execute(prog, &interpreter.Config{Stdout: &bytes.Buffer{}, Profile: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := map[string]int{
+		"<func fib>":      177,
+		"<func>":          3,
+		"<builtin sort>":  1,
+		"<builtin print>": 1,
+	}
+	if len(stats.Profile) != len(expected) {
+		t.Fatalf("expected %d profiled functions, got %d", len(expected), len(stats.Profile))
+	}
+	for name, calls := range expected {
+		p := stats.Profile[name]
+		if p == nil {
+			t.Fatalf("expected %s in profile", name)
+		}
+		if p.Calls != calls {
+			t.Errorf("expected %d calls to %s, got %d", calls, name, p.Calls)
+		}
+	}
+	if stats.Profile["<builtin sort>"].Time < stats.Profile["<func>"].Time {
+		t.Errorf("expected sort() time to include key function time")
+	}
+}
+
+func TestCover(t *testing.T) {
+	source := `
+func f(n) {
+    if n > 1 {
+        return "big"
+    }
+    return "small"
+}
+for i in range(3) { f(i) }
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stats, err := interpreter.Execute(prog, &interpreter.Config{Cover: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	lines := make(map[int]int)
+	for pos, count := range stats.Coverage {
+		lines[pos.Line] += count
+	}
+	expected := map[int]int{2: 1, 3: 3, 4: 1, 6: 2, 8: 4}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Fatalf("expected line counts %v, got %v", expected, lines)
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	interp := interpreter.New(&interpreter.Config{Stdout: stdout})
+	sources := []string{
+		`func double(x) { return x * 2 }  n = 20`,
+		`print(double(n))`,
+		`print(undefined)`,
+		`n = n + 1  print(n)`,
+	}
+	for _, source := range sources {
+		prog, err := parser.ParseProgram([]byte(source))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = interp.Execute(prog)
+		if err != nil {
+			fmt.Fprintln(stdout, err)
+		}
+	}
+	expr, err := parser.ParseExpression([]byte(`double(n) + 1`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	value, err := interp.Evaluate(expr)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	fmt.Fprintln(stdout, value)
+	expected := "40\nname error at 1:7: name \"undefined\" not found\n21\n43\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, stdout.String())
+	}
+	if interp.Stats().UserCalls != 2 {
+		t.Fatalf("expected 2 user calls, got %d", interp.Stats().UserCalls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte("i = 0\nwhile true {\n    i = i + 1\n}"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = interpreter.Execute(prog, &interpreter.Config{Context: ctx})
+	if _, ok := err.(interpreter.TimeoutError); !ok {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "execution timed out at ") {
+		t.Fatalf("unexpected error message %q", err.Error())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = interpreter.Execute(prog, &interpreter.Config{Context: ctx})
+	if err == nil || err.Error() != "execution canceled at 1:3" {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+
+	// A loop with an empty body has no statements to check the timeout at
+	prog, err = parser.ParseProgram([]byte("while true {}"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = interpreter.Execute(prog, &interpreter.Config{Context: ctx})
+	if err == nil || err.Error() != "execution timed out at 1:1" {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`print(len(read()))  read("littlelang.ll")`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdin:   strings.NewReader("stdin is allowed"),
+		Stdout:  stdout,
+		Sandbox: interpreter.Sandbox{NoFS: true},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if stdout.String() != "16\n" {
+		t.Fatalf("expected read() of stdin to work, got %q", stdout.String())
+	}
+	expected := "runtime error at 1:21: read() can't access the filesystem in sandbox mode"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestReadline(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+line = readline()
+while line != "two" {
+    print(repr(line))
+    line = readline()
+}
+print(repr(read()), readline())
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdin:  strings.NewReader("one\r\n\ntwo\nthree\nfour"),
+		Stdout: stdout,
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "\"one\"\n\"\"\n\"three\\nfour\" nil\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+data = readbytes("in.bin")
+write(data + bytes([255]), "out.bin")
+write("text", "out.txt")
+print(len(data))
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	written := make(map[string][]byte)
+	config := &interpreter.Config{
+		Stdout: stdout,
+		ReadFile: func(filename string) ([]byte, error) {
+			if filename != "in.bin" {
+				return nil, fmt.Errorf("unexpected filename %q", filename)
+			}
+			return []byte{0, 1, 2}, nil
+		},
+		WriteFile: func(filename string, data []byte) error {
+			written[filename] = data
+			return nil
+		},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stdout.String() != "3\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	if !bytes.Equal(written["out.bin"], []byte{0, 1, 2, 255}) || string(written["out.txt"]) != "text" {
+		t.Fatalf("unexpected files written: %q", written)
+	}
+
+	config.Sandbox = interpreter.Sandbox{NoFS: true}
+	_, err = interpreter.Execute(prog, config)
+	expected := "runtime error at 2:8: readbytes() can't access the filesystem in sandbox mode"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	prog, err := parser.ParseProgram([]byte(`
+sub = dir + "/a/b"
+print(exists(sub))
+mkdir(sub)
+write("hello", sub + "/f.txt")
+s = stat(sub + "/f.txt")
+print(exists(sub), listdir(sub), s.name, s.size, s.isdir, stat(sub).isdir, s.modtime > 1600000000)
+remove(sub + "/f.txt")
+print(listdir(sub))
+remove(sub)
+print(exists(sub), listdir(dir + "/a"))
+remove(sub)
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Vars:   map[string]interpreter.Value{"dir": dir},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err == nil || !strings.HasPrefix(err.Error(), "runtime error at 12:1: remove() error: ") {
+		t.Fatalf("expected remove() error, got %v", err)
+	}
+	expected := "false\ntrue [\"f.txt\"] f.txt 5 false true true\n[]\nfalse []\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+
+	config.Sandbox = interpreter.Sandbox{NoFS: true}
+	_, err = interpreter.Execute(prog, config)
+	expected = "runtime error at 3:7: exists() can't access the filesystem in sandbox mode"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	// The filesystem builtins go through the Config hooks
+	var calls []string
+	noFS := errors.New("no filesystem")
+	config = &interpreter.Config{
+		Stdout: stdout,
+		Vars:   map[string]interpreter.Value{"dir": dir},
+		Stat: func(filename string) (os.FileInfo, error) {
+			calls = append(calls, "stat "+filename)
+			return nil, os.ErrNotExist
+		},
+		ReadDir: func(dirname string) ([]os.FileInfo, error) {
+			calls = append(calls, "readdir "+dirname)
+			return nil, noFS
+		},
+		Mkdir: func(path string) error {
+			calls = append(calls, "mkdir "+path)
+			return nil
+		},
+		Remove: func(filename string) error {
+			calls = append(calls, "remove "+filename)
+			return noFS
+		},
+	}
+	prog, err = parser.ParseProgram([]byte(`
+mkdir("d")
+print(exists("d"))
+try { listdir("d") } catch e { print(e.message) }
+remove("d")
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout.Reset()
+	_, err = interpreter.Execute(prog, config)
+	expected = "runtime error at 5:1: remove() error: no filesystem"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+	if stdout.String() != "false\nlistdir() error: no filesystem\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	expectedCalls := []string{"mkdir d", "stat d", "readdir d", "remove d"}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Fatalf("expected calls %q, got %q", expectedCalls, calls)
+	}
+}
+
+func TestReadChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "in.txt")
+	err = ioutil.WriteFile(filename, []byte("abcdefgh"), 0644)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	prog, err := parser.ParseProgram([]byte(`
+chunk = read(filename, 3)
+while chunk != "" {
+    print(chunk)
+    chunk = read(filename, 3)
+}
+print(read(filename, 5), read(filename, 5), read(filename, 5))
+print(read(filename, 4), read(filename))
+read(filename + ".missing", 1)
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Vars:   map[string]interpreter.Value{"filename": filename},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err == nil || !strings.HasPrefix(err.Error(), "runtime error at 9:1: read() error: ") {
+		t.Fatalf("expected read() error, got %v", err)
+	}
+	expected := "abc\ndef\ngh\nabcde fgh \nabcd abcdefgh\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+
+	config.Sandbox = interpreter.Sandbox{NoFS: true}
+	_, err = interpreter.Execute(prog, config)
+	expected = "runtime error at 2:9: read() can't access the filesystem in sandbox mode"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	prog, err := parser.ParseProgram([]byte(`
+filename = dir + "/log.txt"
+appendfile(filename, "one\n")
+appendfile(filename, bytes([116, 119, 111, 10]))
+print(read(filename))
+appendfile(dir + "/missing/log.txt", "x")
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Vars:   map[string]interpreter.Value{"dir": dir},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err == nil || !strings.HasPrefix(err.Error(), "runtime error at 6:1: appendfile() error: ") {
+		t.Fatalf("expected appendfile() error, got %v", err)
+	}
+	if stdout.String() != "one\ntwo\n\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+
+	config.Sandbox = interpreter.Sandbox{NoFS: true}
+	_, err = interpreter.Execute(prog, config)
+	expected := "runtime error at 3:1: appendfile() can't access the filesystem in sandbox mode"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"lib.ll":    "count = 0\nfunc inc() { outer count = count + 1 }\n",
+		"bad.ll":    "x = \n",
+		"return.ll": "return 1\n",
+		"fail.ll":   "func fail() {\n    assert(false, \"oops\")\n}\n",
+	}
+	for name, source := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	tests := []struct {
+		source string
+		noFS   bool
+		output string
+		err    string
+	}{
+		{`func setup() { local = 1  include(dir + "/lib.ll") }  setup()  inc()  inc()  print(count)`, false, "2\n", ""},
+		{`include("std/strings")  print(strings.trim("  x  "))`, true, "x\n", ""},
+		{`include(dir + "/bad.ll")`, false, "", "runtime error at 1:1: include() error: parse error at " + dir + "/bad.ll:2:1: "},
+		{`include(dir + "/return.ll")`, false, "", "runtime error at " + dir + "/return.ll:1:1: can't return at top level"},
+		{`include(dir + "/fail.ll")  fail()`, false, "", "assertion error at " + dir + "/fail.ll:2:5: oops"},
+		{`include(dir + "/missing.ll")`, false, "", "runtime error at 1:1: include() error: "},
+		{`include(dir + "/lib.ll")`, true, "", "runtime error at 1:1: include() can't access the filesystem in sandbox mode"},
+		{`include("lib.ll")  inc()  print(count)`, false, "1\n", ""},
+		{`include("missing.ll")`, false, "", "runtime error at 1:1: include() error: open missing.ll: "},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			stdout := &bytes.Buffer{}
+			config := &interpreter.Config{
+				Stdout:      stdout,
+				Vars:        map[string]interpreter.Value{"dir": dir},
+				Sandbox:     interpreter.Sandbox{NoFS: test.noFS},
+				IncludePath: []string{dir},
+			}
+			_, err = interpreter.Execute(prog, config)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("expected error starting with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if stdout.String() != test.output {
+				t.Fatalf("expected output %q, got %q", test.output, stdout.String())
+			}
+		})
+	}
+
+	// Included is called with the name of each file read from the
+	// filesystem, after searching the include path
+	prog, err := parser.ParseProgram([]byte(`include("lib.ll")  include("std/strings")  include(dir + "/lib.ll")`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var included []string
+	config := &interpreter.Config{
+		Vars:        map[string]interpreter.Value{"dir": dir},
+		IncludePath: []string{dir},
+		Included:    func(filename string) { included = append(included, filename) },
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	libFilename := filepath.Join(dir, "lib.ll")
+	expected := []string{libFilename, dir + "/lib.ll"}
+	if !reflect.DeepEqual(included, expected) {
+		t.Fatalf("expected included %q, got %q", expected, included)
+	}
+}
+
+func TestReturnExit(t *testing.T) {
+	tests := []struct {
+		source string
+		status int
+		output string
+	}{
+		{`print("a")  exit()  print("b")`, 0, "a\n"},
+		{`func f() { try { exit(42) } catch e { print("caught") } }  f()  print("after")`, 42, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			stdout := &bytes.Buffer{}
+			config := &interpreter.Config{
+				Stdout:     stdout,
+				Exit:       func(int) { t.Fatalf("Exit called") },
+				ReturnExit: true,
+			}
+			_, err = interpreter.Execute(prog, config)
+			e, ok := err.(interpreter.ExitError)
+			if !ok {
+				t.Fatalf("expected ExitError, got %v", err)
+			}
+			if e.Status != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, e.Status)
+			}
+			if stdout.String() != test.output {
+				t.Fatalf("expected output %q, got %q", test.output, stdout.String())
+			}
+		})
+	}
+}
+
+func TestSignals(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+trap("interrupt", func() { print("cleaning up") })
+send("interrupt")
+print("continuing")
+trap("interrupt", nil)
+send("hangup")
+print("unknown ignored")
+send("interrupt")
+print("not reached")
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	signals := make(chan string, 1)
+	var trapped []string
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Builtins: map[string]interpreter.BuiltinFunc{
+			"send": func(args []interpreter.Value) (interpreter.Value, error) {
+				signals <- args[0].(string)
+				return nil, nil
+			},
+		},
+		Signals: signals,
+		Trap: func(signal string, on bool) {
+			trapped = append(trapped, fmt.Sprintf("%s %v", signal, on))
+		},
+		ReturnExit: true,
+	}
+	_, err = interpreter.Execute(prog, config)
+	e, ok := err.(interpreter.ExitError)
+	if !ok || e.Status != 130 {
+		t.Fatalf("expected exit status 130, got %v", err)
+	}
+	expected := "cleaning up\ncontinuing\nunknown ignored\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+	if got := strings.Join(trapped, ", "); got != "interrupt true, interrupt false" {
+		t.Fatalf("expected trap calls for interrupt, got %q", got)
+	}
+
+	// Handlers still set when the interpreter is stopped are removed
+	prog, err = parser.ParseProgram([]byte(`
+trap("terminate", func() {})
+trap("terminate", func() { print("replaced") })
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	trapped = nil
+	interp := interpreter.New(config)
+	if err := interp.Execute(prog); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := interp.Stop(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got := strings.Join(trapped, ", "); got != "terminate true, terminate false" {
+		t.Fatalf("expected trap calls for terminate, got %q", got)
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		{`
+func square(n, out) {
+    send(out, n * n)
+}
+out = chan()
+for i in range(5) {
+    spawn(square, i, out)
+}
+total = 0
+for i in range(5) {
+    total = total + recv(out)
+}
+print(total)
+`, "30\n", ""},
+		{`
+func pong(ping, pong) {
+    for i in range(3) {
+        send(pong, recv(ping) + 1)
+    }
+}
+ping = chan()
+pong_chan = chan()
+spawn(pong, ping, pong_chan)
+n = 0
+for i in range(3) {
+    send(ping, n)
+    n = recv(pong_chan)
+}
+print(n)
+`, "3\n", ""},
+		{`
+x = "global"
+func f(done) {
+    x = "local"
+    send(done, x)
+}
+done = chan()
+spawn(f, done)
+print(recv(done), x)
+`, "local global\n", ""},
+		{`
+spawn(func() {
+    while true {
+    }
+})
+print("done")
+`, "done\n", ""},
+		{`
+spawn(func() {
+    throw("boom")
+})
+try {
+    recv(chan())
+} catch e {
+    print("caught")
+}
+`, "", "error at 3:5: boom"},
+		{`
+counts = {"n": 0}
+func add(done) {
+    for i in range(100) {
+        withlock(counts, func() {
+            n = counts.n
+            counts.n = n + 1
+        })
+    }
+    send(done, nil)
+}
+done = chan()
+for i in range(4) {
+    spawn(add, done)
+}
+for i in range(4) {
+    recv(done)
+}
+print(counts.n)
+`, "400\n", ""},
+		{`
+x = []
+lock(x)
+spawn(func() {
+    lock(x)
+    print("got lock")
+})
+unlock(x)
+recv(chan())
+`, "got lock\n", "runtime error at 9:1: recv() would block forever with no spawned functions running"},
+		{`
+a = []
+b = []
+lock(a)
+spawn(func() {
+    lock(b)
+    lock(a)
+})
+lock(b)
+`, "", "runtime error at 7:5: lock() would deadlock: all tasks are waiting for locks"},
+		{`
+a = []
+lock(a)
+spawn(func() {
+    lock(a)
+})
+print("done")
+`, "done\n", "runtime error at 5:5: deadlock: spawned functions are still waiting for locks"},
+		{`
+func gen() {
+    yield 1
+    yield 2
+}
+g = gen()
+done = chan()
+spawn(func() {
+    try {
+        for x in g {
+        }
+    } catch e {
+        print(e.message)
+    }
+    send(done, nil)
+})
+recv(done)
+print([g...])
+`, "<generator gen> can only be iterated by the task that created it\n[1, 2]\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			stdout := &bytes.Buffer{}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, Context: ctx})
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if stdout.String() != test.output {
+				t.Fatalf("expected output %q, got %q", test.output, stdout.String())
+			}
+		})
+	}
+}
+
+func TestSpawnBlockingInput(t *testing.T) {
+	// The reader task blocks on input that's only sent once the counter
+	// task has finished, so it only finishes if tasks keep running while
+	// one is reading input
+	prog, err := parser.ParseProgram([]byte(`
+lines = chan()
+spawn(func() {
+    send(lines, readline())
+})
+counts = chan()
+spawn(func() {
+    total = 0
+    for i in range(100) {
+        total = total + i
+    }
+    send(counts, total)
+})
+print(recv(counts))
+input("hello")
+print(recv(lines))
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	reader, writer := io.Pipe()
+	stdout := &bytes.Buffer{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	config := &interpreter.Config{
+		Stdin:   reader,
+		Stdout:  stdout,
+		Context: ctx,
+		Builtins: map[string]interpreter.BuiltinFunc{
+			"input": func(args []interpreter.Value) (interpreter.Value, error) {
+				go fmt.Fprintln(writer, args[0])
+				return nil, nil
+			},
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := interpreter.Execute(prog, config)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	case <-time.After(5 * time.Second):
+		// Write the input so the blocked read returns
+		fmt.Fprintln(writer, "unblock")
+		t.Fatalf("tasks didn't run while a task was reading input")
+	}
+	if stdout.String() != "4950\nhello\n" {
+		t.Fatalf("expected output %q, got %q", "4950\nhello\n", stdout.String())
+	}
+}
+
+func TestNow(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`print(now(), formattime(now(), "2006-01-02 15:04:05.000"))`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Now: func() time.Time {
+			return time.Date(2023, 11, 14, 22, 13, 20, 500000000, time.UTC)
+		},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stdout.String() != "1.7000000005e+09 2023-11-14 22:13:20.500\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestStack(t *testing.T) {
+	source := `
+func inner(x) {
+    return x + 1
+}
+func caller(x) {
+    return inner(x)
+}
+caller(1)
+caller("x")
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = interpreter.Execute(prog, &interpreter.Config{})
+	e, ok := err.(interpreter.Error)
+	if !ok {
+		t.Fatalf("expected interpreter.Error, got %v", err)
+	}
+	var frames []string
+	for _, frame := range e.Stack() {
+		frames = append(frames, fmt.Sprintf("%s %d:%d", frame.Function, frame.Position.Line, frame.Position.Column))
+	}
+	got := strings.Join(frames, ", ")
+	expected := "<func caller> 9:1, <func inner> 6:12"
+	if got != expected {
+		t.Fatalf("expected stack %q, got %q", expected, got)
+	}
+
+	prog, err = parser.ParseProgram([]byte("x = 1 + nil"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = interpreter.Execute(prog, &interpreter.Config{})
+	if stack := err.(interpreter.Error).Stack(); stack != nil {
+		t.Fatalf("expected nil stack at top level, got %v", stack)
+	}
+}
+
+func TestTry(t *testing.T) {
+	source := `
+func inner(x) {
+    return x + 1
+}
+try {
+    inner("x")
+} catch e {
+    print(e)
+}
+try {
+    while true { i = 0 }
+} catch e {
+    print("caught timeout")
+}
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stdout := &bytes.Buffer{}
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, Context: ctx})
+	expected := `{"column": 14, "filename": nil, "kind": "type", "line": 3, "message": "+ requires two numbers, strs, bytes, lists, or maps", "value": nil}` + "\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+	if _, ok := err.(interpreter.TimeoutError); !ok {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+
+	// The filename is the file the error occurred in, which may be an
+	// included file rather than the main one
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	libFilename := filepath.Join(dir, "lib.ll")
+	err = ioutil.WriteFile(libFilename, []byte("func half(x) {\n    return x / 0\n}\n"), 0644)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	prog, err = parser.ParseFile("main.ll", []byte(`
+include(lib)
+try {
+    half(1)
+} catch e {
+    print(e.filename == lib, e.line, e.column)
+}
+try {
+    throw("oops")
+} catch e {
+    print(e.filename, e.line, e.column)
+}
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout.Reset()
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Vars:   map[string]interpreter.Value{"lib": libFilename},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected = "true 2 14\nmain.ll 9 5\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`print(double(21))  print(len("abc"))  fail()`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Builtins: map[string]interpreter.BuiltinFunc{
+			"double": func(args []interpreter.Value) (interpreter.Value, error) {
+				return args[0].(int) * 2, nil
+			},
+			"len": func(args []interpreter.Value) (interpreter.Value, error) {
+				return "custom len", nil
+			},
+			"fail": func(args []interpreter.Value) (interpreter.Value, error) {
+				return nil, fmt.Errorf("something went wrong")
+			},
+		},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err == nil || err.Error() != "runtime error at 1:39: fail() error: something went wrong" {
+		t.Fatalf("expected fail() error, got %v", err)
+	}
+	if stdout.String() != "42\ncustom len\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestNameSuggestions(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{`prnt(1)`, `name error at 1:1: name "prnt" not found, did you mean "print"?`},
+		{`total = 1  print(totl)`, `name error at 1:18: name "totl" not found, did you mean "total"?`},
+		{`func f(items) { return itms }  f([])`, `name error at 1:24: name "itms" not found, did you mean "items"?`},
+		{`x = 1  print(y)`, `name error at 1:14: name "y" not found`},
+		{`print(zzzzzz)`, `name error at 1:7: name "zzzzzz" not found`},
+		{`m = {"color": 1, "size": 2}  print(m["colour"])`, `value error at 1:38: key not found: "colour", did you mean "color"?`},
+		{`m = {"color": 1}  print(m["weight"])`, `value error at 1:27: key not found: "weight"`},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: &bytes.Buffer{}})
+			if err == nil || err.Error() != test.output {
+				t.Fatalf("expected error %q, got %v", test.output, err)
+			}
+		})
+	}
+}
+
+func TestArityErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{`func add(a, b) { return a + b }  add(1)`, "type error at 1:34: add(a, b) requires 2 args, got 1 (defined at 1:1)"},
+		{"\nfunc f() {}\nf(1, 2)", "type error at 3:1: f() requires 0 args, got 2 (defined at 2:1)"},
+		{`f = func(x) { return x }  f()`, "type error at 1:27: func(x) requires 1 arg, got 0 (defined at 1:5)"},
+		{`func g(a, b, rest...) {}  g(1)`, "type error at 1:27: g(a, b, rest...) requires at least 2 args, got 1 (defined at 1:1)"},
+		{`func add(a, b) { return a + b }  add(1, c=2)`, "type error at 1:34: add(a, b) has no parameter c (defined at 1:1)"},
+		{`func add(a, b) { return a + b }  add(1, a=2)`, "type error at 1:34: add(a, b) got multiple values for a (defined at 1:1)"},
+		{`func add(a, b) { return a + b }  add(b=2)`, "type error at 1:34: add(a, b) missing argument a (defined at 1:1)"},
+		{`func g(a, rest...) {}  g(rest=[1], a=2)`, "type error at 1:24: g(a, rest...) variadic parameter rest can't be passed by keyword (defined at 1:1)"},
+		{`func f(a, b...) {}  f(b=[1], a=2)`, "type error at 1:21: f(a, b...) variadic parameter b can't be passed by keyword (defined at 1:1)"},
+		{`struct P { x }  func P.m(p, a...) {}  P(1).m(a=2)`, "type error at 1:43: P.m(p, a...) variadic parameter a can't be passed by keyword (defined at 1:17)"},
+		{`len(x=[])`, "type error at 1:1: <builtin len> doesn't accept keyword arguments"},
+		{`struct P { x y }  P(x=1, z=2)`, "type error at 1:19: P(x, y) has no parameter z (defined at 1:1)"},
+		{`struct P { x }  func P.m(p, a) {}  P(1).m(p=2)`, "type error at 1:40: P.m(p, a) has no parameter p (defined at 1:17)"},
+		{`struct P { x }  func P.m(p, a) {}  P(1).m()`, "type error at 1:40: P.m(p, a) requires 2 args, got 1 (defined at 1:17)"},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: &bytes.Buffer{}})
+			if err == nil || err.Error() != test.output {
+				t.Fatalf("expected error %q, got %v", test.output, err)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	source := `
+len = 5
+len = 6
+func print(x) {}
+func f() { type = 1  return type }
+f()
+keys = []
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stderr := &bytes.Buffer{}
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stderr: stderr})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := "warning at 2:1: assignment to len shadows builtin len()\n" +
+		"warning at 4:1: function print shadows builtin print()\n" +
+		"warning at 7:1: assignment to keys shadows builtin keys()\n"
+	if stderr.String() != expected {
+		t.Fatalf("expected warnings %q, got %q", expected, stderr.String())
+	}
+
+	stderr.Reset()
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stderr: stderr, NoWarnings: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no warnings, got %q", stderr.String())
+	}
+}
+
+func TestFloorDivision(t *testing.T) {
+	source := `print(7 / 2, -7 / 2, 7 / -2, -7 / -2, -6 / 2)
+print(7 % 2, -7 % 2, 7 % -2, -7 % -2, -6 % 2)
+print(7.5 / 2, -7.5 / 2, -7.5 % 2)`
+	tests := []struct {
+		floor  bool
+		output string
+	}{
+		{false, "3 -3 -3 3 -3\n1 -1 1 -1 0\n3.75 -3.75 -1.5\n"},
+		{true, "3 -4 -4 3 -3\n1 1 -1 -1 0\n3.0 -4.0 0.5\n"},
+	}
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, test := range tests {
+		stdout := &bytes.Buffer{}
+		_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, FloorDivision: test.floor})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if stdout.String() != test.output {
+			t.Fatalf("floor %v: expected %q, got %q", test.floor, test.output, stdout.String())
+		}
+	}
+
+	prog, err = parser.ParseProgram([]byte(`print(-1 % 0)`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = interpreter.Execute(prog, &interpreter.Config{FloorDivision: true})
+	if err == nil || err.Error() != "value error at 1:10: can't divide by zero" {
+		t.Fatalf("expected divide by zero error, got %v", err)
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	source := `
+for x in [nil, 0, 1, "", "x", [], [0], {}, len] {
+    if x {
+        print("true")
+    } else {
+        print("false")
+    }
+}
+lst = [1, 2]
+while lst {
+    lst = lst[1:]
+}
+print(lst)
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdout := &bytes.Buffer{}
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, Truthy: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := "false\nfalse\ntrue\nfalse\ntrue\nfalse\ntrue\nfalse\ntrue\n[]\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, stdout.String())
+	}
+
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: &bytes.Buffer{}})
+	if err == nil || err.Error() != "type error at 3:8: if condition must be bool, got nil" {
+		t.Fatalf("expected if condition error, got %v", err)
+	}
+}
+
+func TestTruthyAndOr(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{`print(nil or "default")`, "default\n"},
+		{`print("x" or "default")`, "x\n"},
+		{`print(0 and 1, 2 and 3, [] and 1)`, "0 3 []\n"},
+		{`print(not 0, not "x", not nil)`, "true false true\n"},
+		{"f = func() { print(\"called\") }\nprint(1 or f(), 0 and f())", "1 0\n"},
+		{"m = {}\nprint(m?.name or \"world\")", "world\n"},
+		{`print(true and false, false or true)`, "false true\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			stdout := &bytes.Buffer{}
+			_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, Truthy: true})
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			if stdout.String() != test.output {
+				t.Fatalf("expected %q, got %q", test.output, stdout.String())
+			}
+		})
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{"struct Point { x y }\np = Point(1, \"a\")\nprint(p, type(p), Point, [p])", "Point(x=1, y=\"a\") Point <struct Point> [Point(x=1, y=\"a\")]\n"},
+		{"struct Point { x y }\nprint(Point(1, 2) == Point(1, 2.0), Point(1, 2) == Point(2, 1))", "true false\n"},
+		{"struct A { x }\nstruct B { x }\nprint(A(1) == B(1), A(1) != {\"x\": 1})", "false true\n"},
+		{"struct Node { next }\nn = Node(nil)\nn.next = n\nprint(n, n == n)", "Node(next=Node(...)) true\n"},
+		{"struct P { x }\nfunc P.get(p) { return p.x }\np = P(1)\nprint(p.get, p.get == p.get, p.get == P(1).get)", "<func P.get> true false\n"},
+		{"struct P { x }\nprint(bool(P(nil)))", "true\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			stdout := &bytes.Buffer{}
+			_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout})
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			if stdout.String() != test.output {
+				t.Fatalf("expected %q, got %q", test.output, stdout.String())
+			}
+		})
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	source := `
+func count(n) {
+    i = 0
+    while true {
+        print("yielding", i)
+        yield i
+        i = i + 1
+        if i == n {
+            return nil
+        }
+    }
+}
+for x in count(3) {
+    print("got", x)
+}
+func naturals() {
+    n = 0
+    while true {
+        yield n
+        n = n + 1
+    }
+}
+func take(n, iterable) {
+    for i, x in iterable {
+        if i == n {
+            return nil
+        }
+        yield x
+    }
+}
+func squares(iterable) {
+    for x in iterable {
+        yield x * x
+    }
+}
+print([take(5, squares(naturals()))...])
+g = count(1)
+print(type(g), g, g == g, [g...], [g...])
+func bad() {
+    yield 1
+    yield 1 + nil
+}
+try {
+    for x in bad() { print(x) }
+} catch e {
+    print(e.message, e.line)
+}
+func forever() {
+    while true { yield 1 }
+}
+for x in forever() { i = 0 }
+`
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stdout := &bytes.Buffer{}
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: stdout, Context: ctx})
+	expected := `yielding 0
+got 0
+yielding 1
+got 1
+yielding 2
+got 2
+[0, 1, 4, 9, 16]
+yielding 0
+generator <generator count> true [0] []
+1
++ requires two numbers, strs, bytes, lists, or maps 41
+`
+	if stdout.String() != expected {
+		t.Fatalf("expected output %q, got %q", expected, stdout.String())
+	}
+	if _, ok := err.(interpreter.TimeoutError); !ok {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+
+	prog, err = parser.ParseProgram([]byte(`
+func gen() {
+    yield 1
+    yield nil + 1
+}
+func caller() {
+    return [gen()...]
+}
+caller()
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = interpreter.Execute(prog, &interpreter.Config{})
+	e, ok := err.(interpreter.Error)
+	if !ok {
+		t.Fatalf("expected interpreter.Error, got %v", err)
+	}
+	var frames []string
+	for _, frame := range e.Stack() {
+		frames = append(frames, fmt.Sprintf("%s %d:%d", frame.Function, frame.Position.Line, frame.Position.Column))
+	}
+	got := strings.Join(frames, ", ")
+	want := "<func caller> 9:1, <func gen> 7:13"
+	if got != want {
+		t.Fatalf("expected stack %q, got %q", want, got)
+	}
+
+	prog, err = parser.ParseProgram([]byte(`
+func gen() {
+    for x in g { }
+    yield 1
+}
+g = gen()
+print([g...])
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = interpreter.Execute(prog, &interpreter.Config{})
+	if err == nil || err.Error() != "runtime error at 3:14: <generator gen> is already running" {
+		t.Fatalf("expected already running error, got %v", err)
+	}
+}
+
+// Return the number of goroutines once it has dropped to at most n, or
+// after a second if it hasn't
+func waitGoroutines(n int) int {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestGeneratorsStopped(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+func naturals() {
+    n = 0
+    while true {
+        yield n
+        n = n + 1
+    }
+}
+func first(g) {
+    for x in g {
+        return x
+    }
+}
+kept = []
+for i in range(50) {
+    append(kept, naturals())
+    first(kept[i])
+}
+for i in range(50) {
+    first(naturals())
+}
+collect()
+print(goroutines() < 80)
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	before := runtime.NumGoroutine()
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdout: stdout,
+		Builtins: map[string]interpreter.BuiltinFunc{
+			// Run the finalizers of the unreferenced generators
+			"collect": func(args []interpreter.Value) (interpreter.Value, error) {
+				for i := 0; i < 5; i++ {
+					runtime.GC()
+					time.Sleep(10 * time.Millisecond)
+				}
+				return nil, nil
+			},
+			"goroutines": func(args []interpreter.Value) (interpreter.Value, error) {
+				return waitGoroutines(before + 50), nil
+			},
+		},
+	}
+	_, err = interpreter.Execute(prog, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// The 50 generators the program doesn't refer to are closed while it
+	// runs, and the 50 it keeps are closed when it finishes
+	if stdout.String() != "true\n" {
+		t.Fatalf("expected unreferenced generators to be closed, got %q", stdout.String())
+	}
+	if n := waitGoroutines(before); n > before {
+		t.Fatalf("expected %d goroutines after Execute, got %d", before, n)
+	}
+}
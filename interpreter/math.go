@@ -0,0 +1,119 @@
+// Math builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"math"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Return arg as a float64, raising an error if it's not a number
+func ensureNumber(pos Position, name string, index int, arg Value) float64 {
+	f, ok := toFloat(arg)
+	if !ok {
+		panic(argTypeError(pos, name, index, "a number", arg))
+	}
+	return f
+}
+
+// Convert the result of rounding a float to an int, raising an error if
+// it's out of range (or NaN)
+func roundedInt(pos Position, name string, f float64) Value {
+	if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		panic(valueError(pos, "%s() argument %s out of range", name, formatFloat(f)))
+	}
+	return Value(int(f))
+}
+
+func absFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "abs", args, 1)
+	switch arg := args[0].(type) {
+	case int:
+		if arg < 0 {
+			return Value(-arg)
+		}
+		return Value(arg)
+	case float64:
+		return Value(math.Abs(arg))
+	}
+	panic(argTypeError(pos, "abs", 1, "a number", args[0]))
+}
+
+func ceilFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "ceil", args, 1)
+	if i, ok := args[0].(int); ok {
+		return Value(i)
+	}
+	return roundedInt(pos, "ceil", math.Ceil(ensureNumber(pos, "ceil", 1, args[0])))
+}
+
+func floorFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "floor", args, 1)
+	if i, ok := args[0].(int); ok {
+		return Value(i)
+	}
+	return roundedInt(pos, "floor", math.Floor(ensureNumber(pos, "floor", 1, args[0])))
+}
+
+func powFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "pow", args, 2)
+	x := ensureNumber(pos, "pow", 1, args[0])
+	y := ensureNumber(pos, "pow", 2, args[1])
+	if x == 0 && y < 0 {
+		// Same as 1 / pow(0, -y)
+		panic(valueError(pos, "can't divide by zero"))
+	}
+	base, baseIsInt := args[0].(int)
+	exp, expIsInt := args[1].(int)
+	if baseIsInt && expIsInt && exp >= 0 {
+		// Exponentiation by squaring, so int results are exact
+		result := 1
+		ok := true
+		for exp > 0 && ok {
+			if exp&1 != 0 {
+				result, ok = multiplyInts(result, base)
+			}
+			exp >>= 1
+			if exp > 0 && ok {
+				base, ok = multiplyInts(base, base)
+			}
+		}
+		if !ok {
+			panic(valueError(pos, "pow() result is too large for an int"))
+		}
+		return Value(result)
+	}
+	return Value(math.Pow(x, y))
+}
+
+// Return a*b, and false if the result overflows an int
+func multiplyInts(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	// Dividing the smallest int by -1 overflows too, giving itself (the
+	// only nonzero int equal to its negation)
+	if c/b != a || (b == -1 && a == -a) {
+		return c, false
+	}
+	return c, true
+}
+
+func roundFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "round", args, 1)
+	if i, ok := args[0].(int); ok {
+		return Value(i)
+	}
+	return roundedInt(pos, "round", math.Round(ensureNumber(pos, "round", 1, args[0])))
+}
+
+func sqrtFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "sqrt", args, 1)
+	f := ensureNumber(pos, "sqrt", 1, args[0])
+	if f < 0 {
+		panic(valueError(pos, "sqrt() argument must not be negative"))
+	}
+	return Value(math.Sqrt(f))
+}
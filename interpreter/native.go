@@ -40,7 +40,7 @@ func (f nativeFunction) call(interp *interpreter, pos Position, args []Value) Va
 		case reflect.String:
 			return Value(x)
 		}
-		panic(runtimeError(pos, fmt.Sprintf("native function returned invalid type %s", v.Kind())))
+		panic(runtimeError(pos, "native function returned invalid type %s", v.Kind()))
 	}
 	// Uint
 	// Uint8
@@ -65,7 +65,7 @@ func (f nativeFunction) call(interp *interpreter, pos Position, args []Value) Va
 	} else if len(results) == 1 {
 		return getValue(results[0])
 	} else {
-		panic(runtimeError(pos, fmt.Sprintf("native function must return 0 or 1 result, not %d", len(results))))
+		panic(runtimeError(pos, "native function must return 0 or 1 result, not %d", len(results)))
 	}
 }
 
@@ -0,0 +1,40 @@
+// Per-function profiling for littlelang interpreter
+
+package interpreter
+
+import (
+	"time"
+)
+
+// FunctionProfile holds the profiling information for a single function,
+// collected when Config.Profile is true.
+type FunctionProfile struct {
+	// Calls is the number of times the function was called.
+	Calls int
+
+	// Time is the cumulative time spent in the function, including time
+	// spent in functions it calls. Time spent in recursive calls is only
+	// counted once.
+	Time time.Duration
+
+	active int
+}
+
+// Record the start of a call to function name, returning a function to call
+// when the call finishes.
+func (interp *interpreter) startProfile(name string) func() {
+	p := interp.stats.Profile[name]
+	if p == nil {
+		p = &FunctionProfile{}
+		interp.stats.Profile[name] = p
+	}
+	p.Calls++
+	p.active++
+	start := time.Now()
+	return func() {
+		p.active--
+		if p.active == 0 {
+			p.Time += time.Since(start)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Set type and set builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Set value created by the set() builtin. Elements must be nil, bool, int,
+// float, or str, and are stored by key so that equal numbers (like 1 and
+// 1.0) are the same element.
+type set struct {
+	elems map[Value]Value
+}
+
+func newSet() *set {
+	return &set{make(map[Value]Value)}
+}
+
+// Return the key used to store v in a set, raising an error if v can't be
+// a set element
+func setKey(pos Position, v Value) Value {
+	switch v := v.(type) {
+	case nil, bool, int, string:
+		return v
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int(v)
+		}
+		return v
+	}
+	panic(typeError(pos, "set element must be nil, bool, int, float, or str, not %s", typeName(v)))
+}
+
+func (s *set) add(pos Position, v Value) {
+	s.elems[setKey(pos, v)] = v
+}
+
+func (s *set) contains(pos Position, v Value) bool {
+	_, ok := s.elems[setKey(pos, v)]
+	return ok
+}
+
+// Return the set's elements in a consistent order: nil, then bools, then
+// numbers, then strs
+func (s *set) sorted() []Value {
+	values := make([]Value, 0, len(s.elems))
+	for _, v := range s.elems {
+		values = append(values, v)
+	}
+	rank := func(v Value) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case int, float64:
+			return 2
+		default:
+			return 3
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		l, r := values[i], values[j]
+		if rank(l) != rank(r) {
+			return rank(l) < rank(r)
+		}
+		switch l := l.(type) {
+		case bool:
+			return !l && r.(bool)
+		case string:
+			return l < r.(string)
+		case nil:
+			return false
+		default:
+			lf, _ := toFloat(l)
+			rf, _ := toFloat(r)
+			return lf < rf
+		}
+	})
+	return values
+}
+
+func (s *set) String() string {
+	strs := []string{}
+	for _, v := range s.sorted() {
+		strs = append(strs, toString(v, true))
+	}
+	return "set([" + strings.Join(strs, ", ") + "])"
+}
+
+func ensureSet(pos Position, name string, index int, arg Value) *set {
+	s, ok := arg.(*set)
+	if !ok {
+		panic(argTypeError(pos, name, index, "a set", arg))
+	}
+	return s
+}
+
+func setFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) > 1 {
+		panic(typeError(pos, "set() requires 0 or 1 args, got %d", len(args)))
+	}
+	s := newSet()
+	if len(args) == 1 {
+		iterator := interp.getIterator(pos, args[0])
+		for iterator.HasNext() {
+			s.add(pos, iterator.Value())
+		}
+	}
+	return Value(s)
+}
+
+func unionFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "union", args, 2)
+	a := ensureSet(pos, "union", 1, args[0])
+	b := ensureSet(pos, "union", 2, args[1])
+	result := newSet()
+	for k, v := range a.elems {
+		result.elems[k] = v
+	}
+	for k, v := range b.elems {
+		if _, ok := result.elems[k]; !ok {
+			result.elems[k] = v
+		}
+	}
+	return Value(result)
+}
+
+func intersectFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "intersect", args, 2)
+	a := ensureSet(pos, "intersect", 1, args[0])
+	b := ensureSet(pos, "intersect", 2, args[1])
+	result := newSet()
+	for k, v := range a.elems {
+		if _, ok := b.elems[k]; ok {
+			result.elems[k] = v
+		}
+	}
+	return Value(result)
+}
@@ -0,0 +1,65 @@
+// Signal handling for littlelang interpreter
+
+package interpreter
+
+import (
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Exit status for each signal a program can trap, used when the signal
+// arrives and the program hasn't trapped it (128 plus the Unix signal
+// number, as shells do)
+var signalStatus = map[string]int{
+	"interrupt": 130,
+	"terminate": 143,
+}
+
+func trapFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "trap", args, 2)
+	name := ensureStr(pos, "trap", 1, args[0])
+	if _, ok := signalStatus[name]; !ok {
+		panic(valueError(pos, "trap() signal must be \"interrupt\" or \"terminate\", not %q", name))
+	}
+	_, trapped := interp.traps[name]
+	if args[1] == nil {
+		if trapped {
+			delete(interp.traps, name)
+			interp.notifyTrap(name, false)
+		}
+		return Value(nil)
+	}
+	interp.traps[name] = ensureFunction(pos, "trap", 2, args[1])
+	if !trapped {
+		interp.notifyTrap(name, true)
+	}
+	return Value(nil)
+}
+
+// Tell the embedder (via Config.Trap) that the program has started or
+// stopped trapping the named signal
+func (interp *interpreter) notifyTrap(name string, trapped bool) {
+	if interp.trapNotify != nil {
+		interp.trapNotify(name, trapped)
+	}
+}
+
+// Remove all the program's signal handlers, when it's stopped
+func (interp *interpreter) untrapAll() {
+	for name := range interp.traps {
+		delete(interp.traps, name)
+		interp.notifyTrap(name, false)
+	}
+}
+
+// Handle a signal received on Config.Signals by calling the program's
+// handler for it, or exiting if it hasn't trapped the signal. Unknown
+// signal names are ignored.
+func (interp *interpreter) handleSignal(pos Position, name string) {
+	if handler, ok := interp.traps[name]; ok {
+		interp.callFunction(pos, handler, nil)
+		return
+	}
+	if status, ok := signalStatus[name]; ok {
+		interp.exitProgram(pos, status)
+	}
+}
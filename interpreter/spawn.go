@@ -0,0 +1,438 @@
+// Concurrency (spawn and channels) for littlelang interpreter
+
+package interpreter
+
+import (
+	"reflect"
+	"sync"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// A function started with spawn() runs as a task in its own goroutine, with
+// its own scopes and call stack but sharing the program's global scope.
+// Only one goroutine runs littlelang code at a time, the one that has the
+// turn. It gives the turn to the next goroutine waiting for it between
+// statements, while it's blocked on a channel or waiting in lock(), and
+// while it's reading input.
+type tasks struct {
+	turnLock sync.Mutex
+	running  bool            // a goroutine has the turn
+	queue    []chan struct{} // goroutines waiting for the turn, in order
+	fresh    bool            // the turn was just given, no statement run yet
+
+	count    int           // number of tasks still running
+	stopping bool          // tasks should stop at their next statement
+	stopped  chan struct{} // closed when stopping is set
+	exited   chan struct{} // closed (and replaced) when a task finishes
+	err      Error         // error raised by a task, not yet reported
+
+	input sync.Mutex // held while reading input with the turn released
+
+	locks       map[uintptr]*mutex // values locked with lock(), by containerID
+	lockWaiters int                // number of tasks waiting in lock()
+	mainWaiting bool               // main program is waiting in lock()
+	waitPos     Position           // position of the latest wait in lock()
+}
+
+// Ask for the turn, returning a channel that's closed when it's given
+func (t *tasks) enqueue() chan struct{} {
+	t.turnLock.Lock()
+	defer t.turnLock.Unlock()
+	ready := make(chan struct{})
+	if t.running {
+		t.queue = append(t.queue, ready)
+	} else {
+		t.running = true
+		close(ready)
+	}
+	return ready
+}
+
+// Wait for the turn
+func (t *tasks) acquire() {
+	<-t.enqueue()
+	t.fresh = true
+}
+
+// Give the turn to the next goroutine waiting for it, if any
+func (t *tasks) release() {
+	t.turnLock.Lock()
+	defer t.turnLock.Unlock()
+	if len(t.queue) > 0 {
+		close(t.queue[0])
+		t.queue = t.queue[1:]
+	} else {
+		t.running = false
+	}
+}
+
+// Call read, which reads input and may block (for example, waiting for a
+// line on standard input), with the turn released so that other tasks keep
+// running meanwhile. Tasks share standard input and the files opened by
+// chunked reads, so reads are done one at a time, holding the input lock.
+// read mustn't use any other interpreter state.
+func (interp *interpreter) blockingRead(read func()) {
+	t := interp.tasks
+	if t.count == 0 {
+		read()
+		return
+	}
+	t.release()
+	func() {
+		defer t.acquire()
+		t.input.Lock()
+		defer t.input.Unlock()
+		read()
+	}()
+	interp.checkTasks()
+}
+
+// Panicked to unwind a task's goroutine when tasks are stopped
+type taskStopped struct{}
+
+// Panicked in the main program to report an error raised by a task. It's
+// not an Error, so that a try statement in the main program can't catch it.
+type taskError struct {
+	err Error
+}
+
+// Stop all tasks, recording err (if not nil) to report in the main program.
+// Must be called with the lock held.
+func (t *tasks) stop(err Error) {
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	if !t.stopping {
+		t.stopping = true
+		if t.stopped != nil {
+			close(t.stopped)
+		}
+	}
+}
+
+// Give other tasks a turn (unless this goroutine hasn't run a statement
+// since it got the turn), then stop this task if tasks are being stopped,
+// or raise the error a task stopped with if this is the main program
+func (interp *interpreter) switchTasks() {
+	t := interp.tasks
+	if t.count > 0 && !t.fresh {
+		t.release()
+		t.acquire()
+	}
+	t.fresh = false
+	interp.checkTasks()
+}
+
+func (interp *interpreter) checkTasks() {
+	t := interp.tasks
+	if interp.isTask {
+		if t.stopping {
+			panic(taskStopped{})
+		}
+	} else if t.err != nil {
+		err := t.err
+		t.err = nil
+		panic(taskError{err})
+	}
+}
+
+func spawnFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) < 1 {
+		panic(typeError(pos, "spawn() requires at least 1 arg, got %d", len(args)))
+	}
+	f := ensureFunction(pos, "spawn", 1, args[0])
+	t := interp.tasks
+	if t.count == 0 && t.stopping {
+		t.stopping = false
+		t.stopped = nil
+	}
+	if t.stopped == nil {
+		t.stopped = make(chan struct{})
+		t.exited = make(chan struct{})
+	}
+	t.count++
+	t.fresh = false // this goroutine is running a statement
+	task := *interp
+	task.vars = []map[string]Value{interp.vars[0]}
+	task.calls = nil
+	task.generator = nil
+	task.signals = nil // only the main program handles signals
+	task.isTask = true
+	// Queue the task for a turn now, so it runs after the goroutines
+	// already waiting
+	go task.runTask(t.enqueue(), pos, f, append([]Value(nil), args[1:]...))
+	return Value(nil)
+}
+
+// Body of a task's goroutine. An error raised by the function stops all
+// tasks, and is reported in the main program.
+func (interp *interpreter) runTask(ready chan struct{}, pos Position, f functionType, args []Value) {
+	t := interp.tasks
+	<-ready
+	t.fresh = true
+	defer t.release()
+	defer func() {
+		switch r := recover().(type) {
+		case nil, taskStopped:
+		case Error:
+			t.stop(r)
+		default:
+			panic(r)
+		}
+		t.count--
+		t.unlockAll(interp)
+		close(t.exited)
+		t.exited = make(chan struct{})
+	}()
+	interp.checkTasks()
+	interp.callFunction(pos, f, args)
+}
+
+// Channel created by chan() for sending values between tasks
+type channel chan Value
+
+func chanFunc(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) > 1 {
+		panic(typeError(pos, "chan() requires 0 or 1 args, got %d", len(args)))
+	}
+	capacity := 0
+	if len(args) == 1 {
+		n, ok := args[0].(int)
+		if !ok {
+			panic(argTypeError(pos, "chan", 1, "an int", args[0]))
+		}
+		if n < 0 {
+			panic(valueError(pos, "chan() capacity must not be negative"))
+		}
+		capacity = n
+	}
+	return Value(make(channel, capacity))
+}
+
+func ensureChannel(pos Position, name string, arg Value) channel {
+	ch, ok := arg.(channel)
+	if !ok {
+		panic(argTypeError(pos, name, 1, "a chan", arg))
+	}
+	return ch
+}
+
+func sendFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "send", args, 2)
+	ch := ensureChannel(pos, "send", args[0])
+	interp.channelOp(pos, "send", reflect.SelectCase{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(ch),
+		Send: reflect.ValueOf(&args[1]).Elem(),
+	})
+	return Value(nil)
+}
+
+func recvFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "recv", args, 1)
+	ch := ensureChannel(pos, "recv", args[0])
+	return interp.channelOp(pos, "recv", reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ch),
+	})
+}
+
+// Perform the send or receive in op, returning the value received (nil for
+// a send). If it can't complete right away, wait for it with the lock
+// released. If no tasks are running the operation could never complete, so
+// that's an error.
+func (interp *interpreter) channelOp(pos Position, name string, op reflect.SelectCase) Value {
+	chosen, value, _ := reflect.Select([]reflect.SelectCase{op, {Dir: reflect.SelectDefault}})
+	if chosen == 0 {
+		return opValue(op, value)
+	}
+	for {
+		interp.checkTasks()
+		if interp.tasks.count == 0 {
+			panic(runtimeError(pos, "%s() would block forever with no spawned functions running", name))
+		}
+		if value, ok := interp.wait(pos, op); ok {
+			return opValue(op, value)
+		}
+	}
+}
+
+// Release the lock and wait until op's channel operation completes,
+// returning the value received and true. Meanwhile, raise an error if
+// Config.Context is done, and handle any signal that arrives; return false
+// if a signal was handled, tasks are being stopped, or (in the main
+// program) a task finished.
+func (interp *interpreter) wait(pos Position, op reflect.SelectCase) (reflect.Value, bool) {
+	t := interp.tasks
+	var done <-chan struct{}
+	if interp.ctx != nil {
+		done = interp.ctx.Done()
+	}
+	var exited chan struct{}
+	if !interp.isTask {
+		exited = t.exited
+	}
+	// Receiving from a nil channel blocks forever, so a nil done, signals,
+	// or exited channel is never selected
+	cases := []reflect.SelectCase{
+		op,
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.stopped)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(interp.signals)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(exited)},
+	}
+	t.release()
+	chosen, value, _ := reflect.Select(cases)
+	t.acquire()
+	switch chosen {
+	case 0:
+		return value, true
+	case 2:
+		panic(interp.timeoutError(pos))
+	case 3:
+		interp.handleSignal(pos, value.String())
+	}
+	return reflect.Value{}, false
+}
+
+// Return the value received by op, or nil if it's a send
+func opValue(op reflect.SelectCase, value reflect.Value) Value {
+	if op.Dir == reflect.SelectSend {
+		return nil
+	}
+	return value.Interface()
+}
+
+// A value locked by lock() or withlock(), and the task that holds it.
+// Values are locked by their containerID, so a lock belongs to the shared
+// value itself and doesn't have to be passed around separately. A task
+// waiting for a lock gives up its turn until the unlocked channel is
+// closed, which wakes all the waiters to compete for the lock again.
+type mutex struct {
+	value    Value // keeps value (and so its containerID) alive
+	owner    *interpreter
+	waiters  int           // number of tasks waiting to lock it
+	unlocked chan struct{} // closed when it's unlocked
+}
+
+// Return the ID to lock value by, raising an error if it's not a mutable
+// container
+func lockID(pos Position, name string, value Value) uintptr {
+	switch value.(type) {
+	case *[]Value, map[string]Value, *set, *record:
+		return containerID(value)
+	}
+	panic(argTypeError(pos, name, 1, "a list, map, set, or record", value))
+}
+
+// Lock value with the given ID for this task, waiting until any other task
+// that holds it unlocks it
+func (interp *interpreter) lock(pos Position, name string, id uintptr, value Value) {
+	t := interp.tasks
+	for {
+		m := t.locks[id]
+		if m == nil {
+			t.locks[id] = &mutex{value: value, owner: interp, unlocked: make(chan struct{})}
+			return
+		}
+		if m.owner == interp {
+			panic(runtimeError(pos, "%s() would deadlock: %s is already locked by this task", name, typeName(value)))
+		}
+		interp.waitForUnlock(pos, name, m)
+	}
+}
+
+// Wait until m is unlocked. It's an error if the main program and all tasks
+// are waiting for locks, as none of them could ever be unlocked.
+func (interp *interpreter) waitForUnlock(pos Position, name string, m *mutex) {
+	t := interp.tasks
+	m.waiters++
+	t.lockWaiters++
+	t.waitPos = pos
+	if !interp.isTask {
+		t.mainWaiting = true
+	}
+	defer func() {
+		if !interp.isTask {
+			t.mainWaiting = false
+		}
+		select {
+		case <-m.unlocked:
+			// Unlocking m has already stopped counting this task as waiting
+		default:
+			m.waiters--
+			t.lockWaiters--
+		}
+	}()
+	if t.mainWaiting && t.lockWaiters == t.count+1 {
+		panic(runtimeError(pos, "%s() would deadlock: all tasks are waiting for locks", name))
+	}
+	op := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.unlocked)}
+	for {
+		interp.checkTasks()
+		if _, ok := interp.wait(pos, op); ok {
+			return
+		}
+	}
+}
+
+// Unlock the value with the given ID and wake the tasks waiting for it
+func (t *tasks) unlock(id uintptr) {
+	m := t.locks[id]
+	delete(t.locks, id)
+	t.lockWaiters -= m.waiters
+	m.waiters = 0
+	close(m.unlocked)
+}
+
+// Unlock all values locked by the given task (when it finishes)
+func (t *tasks) unlockAll(owner *interpreter) {
+	for id, m := range t.locks {
+		if m.owner == owner {
+			t.unlock(id)
+		}
+	}
+}
+
+// Stop all tasks when the main program has finished, returning an error if
+// they're all waiting for locks, as nothing can unlock them now
+func (t *tasks) shutdown() error {
+	var err error
+	if t.count > 0 && t.lockWaiters == t.count {
+		err = runtimeError(t.waitPos, "deadlock: spawned functions are still waiting for locks")
+	}
+	t.stop(nil)
+	return err
+}
+
+func lockFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "lock", args, 1)
+	interp.lock(pos, "lock", lockID(pos, "lock", args[0]), args[0])
+	return Value(nil)
+}
+
+func unlockFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "unlock", args, 1)
+	id := lockID(pos, "unlock", args[0])
+	m := interp.tasks.locks[id]
+	if m == nil || m.owner != interp {
+		panic(runtimeError(pos, "unlock() %s isn't locked by this task", typeName(args[0])))
+	}
+	interp.tasks.unlock(id)
+	return Value(nil)
+}
+
+func withlockFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "withlock", args, 2)
+	id := lockID(pos, "withlock", args[0])
+	f := ensureFunction(pos, "withlock", 2, args[1])
+	interp.lock(pos, "withlock", id, args[0])
+	defer func() {
+		// The function may have unlocked it already
+		if m := interp.tasks.locks[id]; m != nil && m.owner == interp {
+			interp.tasks.unlock(id)
+		}
+	}()
+	return interp.callFunction(pos, f, nil)
+}
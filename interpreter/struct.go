@@ -0,0 +1,96 @@
+// Struct types and records for littlelang interpreter
+
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Struct type created by a struct definition. Calling it like a function
+// creates a record with the given field values, and methods defined with
+// "func Name.method(receiver, ...)" can be called on those records.
+type structType struct {
+	Name    string
+	Fields  []string
+	Methods map[string]*userFunction
+	Defined Position
+}
+
+func (s *structType) signature() string {
+	return fmt.Sprintf("%s(%s)", s.Name, strings.Join(s.Fields, ", "))
+}
+
+func (s *structType) call(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != len(s.Fields) {
+		plural := ""
+		if len(s.Fields) != 1 {
+			plural = "s"
+		}
+		panic(typeError(pos, "%s requires %d arg%s, got %d (defined at %s)",
+			s.signature(), len(s.Fields), plural, len(args), s.Defined))
+	}
+	fields := make(map[string]Value, len(s.Fields))
+	for i, field := range s.Fields {
+		fields[field] = args[i]
+	}
+	return Value(&record{s, fields})
+}
+
+func (s *structType) name() string {
+	return fmt.Sprintf("<struct %s>", s.Name)
+}
+
+func (s *structType) hasField(name string) bool {
+	for _, field := range s.Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a method to the struct type, raising an error if it has a field of
+// the same name
+func (s *structType) addMethod(pos Position, f *userFunction) {
+	name := strings.TrimPrefix(f.Name, s.Name+".")
+	if s.hasField(name) {
+		panic(typeError(pos, "struct %s already has a field %s", s.Name, name))
+	}
+	s.Methods[name] = f
+}
+
+// Record value created by calling a struct type. Its fields are accessed
+// with dot or subscript syntax, like a map's keys.
+type record struct {
+	typ    *structType
+	fields map[string]Value
+}
+
+// Return the record's field or bound method with the given name
+func (r *record) get(pos Position, name string) (Value, bool) {
+	if v, ok := r.fields[name]; ok {
+		return v, true
+	}
+	if f, ok := r.typ.Methods[name]; ok {
+		return Value(boundMethod{f, r}), true
+	}
+	return nil, false
+}
+
+// Method of a record, which is called with the record as its first
+// argument
+type boundMethod struct {
+	function *userFunction
+	receiver *record
+}
+
+func (m boundMethod) call(interp *interpreter, pos Position, args []Value) Value {
+	return m.function.call(interp, pos, append([]Value{m.receiver}, args...))
+}
+
+func (m boundMethod) name() string {
+	return m.function.name()
+}
@@ -0,0 +1,54 @@
+// Date and time builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"math"
+	"time"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Return t as a number of seconds since the Unix epoch: an int if it's a
+// whole number of seconds, otherwise a float
+func timeValue(t time.Time) Value {
+	if t.Nanosecond() == 0 {
+		return Value(int(t.Unix()))
+	}
+	return Value(float64(t.UnixNano()) / 1e9)
+}
+
+func nowFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "now", args, 0)
+	return timeValue(interp.now())
+}
+
+func formattimeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "formattime", args, 2)
+	var t time.Time
+	switch arg := args[0].(type) {
+	case int:
+		t = time.Unix(int64(arg), 0)
+	case float64:
+		if math.IsNaN(arg) || math.IsInf(arg, 0) {
+			panic(valueError(pos, "formattime() argument %s out of range", formatFloat(arg)))
+		}
+		secs, frac := math.Modf(arg)
+		t = time.Unix(int64(secs), int64(math.Round(frac*1e9)))
+	default:
+		panic(argTypeError(pos, "formattime", 1, "a number", args[0]))
+	}
+	layout := ensureStr(pos, "formattime", 2, args[1])
+	return Value(t.UTC().Format(layout))
+}
+
+func parsetimeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "parsetime", args, 2)
+	s := ensureStr(pos, "parsetime", 1, args[0])
+	layout := ensureStr(pos, "parsetime", 2, args[1])
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		panic(valueError(pos, "parsetime() error: %v", err))
+	}
+	return timeValue(t)
+}
@@ -0,0 +1,75 @@
+// URL builtins for littlelang interpreter
+
+package interpreter
+
+import (
+	"net/url"
+
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+func urlparseFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "urlparse", args, 1)
+	u, err := url.Parse(ensureStr(pos, "urlparse", 1, args[0]))
+	if err != nil {
+		panic(valueError(pos, "urlparse() error: %v", err.(*url.Error).Err))
+	}
+	password, _ := u.User.Password()
+	return Value(map[string]Value{
+		"scheme":   u.Scheme,
+		"user":     u.User.Username(),
+		"password": password,
+		"host":     u.Hostname(),
+		"port":     u.Port(),
+		"path":     u.Path,
+		"query":    u.RawQuery,
+		"fragment": u.Fragment,
+	})
+}
+
+func urlencodeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "urlencode", args, 1)
+	return Value(url.QueryEscape(ensureStr(pos, "urlencode", 1, args[0])))
+}
+
+func urldecodeFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "urldecode", args, 1)
+	s, err := url.QueryUnescape(ensureStr(pos, "urldecode", 1, args[0]))
+	if err != nil {
+		panic(valueError(pos, "urldecode() error: %v", err))
+	}
+	return Value(s)
+}
+
+func parsequeryFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "parsequery", args, 1)
+	values, err := url.ParseQuery(ensureStr(pos, "parsequery", 1, args[0]))
+	if err != nil {
+		panic(valueError(pos, "parsequery() error: %v", err))
+	}
+	m := make(map[string]Value, len(values))
+	for k := range values {
+		m[k] = values.Get(k)
+	}
+	return Value(m)
+}
+
+func urlqueryFunc(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "urlquery", args, 1)
+	m, ok := args[0].(map[string]Value)
+	if !ok {
+		panic(argTypeError(pos, "urlquery", 1, "a map", args[0]))
+	}
+	values := make(url.Values, len(m))
+	for k, v := range m {
+		if list, ok := v.(*[]Value); ok {
+			// A list gives the key several times
+			for _, item := range *list {
+				values.Add(k, valueString(item, false, nil, interp.strHook(pos)))
+			}
+		} else {
+			values.Set(k, valueString(v, false, nil, interp.strHook(pos)))
+		}
+	}
+	return Value(values.Encode())
+}
@@ -3,81 +3,501 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/benhoyt/littlelang/interpreter"
 	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/std"
 	"github.com/benhoyt/littlelang/tokenizer"
 )
 
-// Show the source line and position of a parser or interpreter error
-func showErrorSource(source []byte, pos tokenizer.Position, dividerLen int) {
-	divider := strings.Repeat("-", dividerLen)
-	if divider != "" {
-		fmt.Println(divider)
+// Print table of per-function call counts and cumulative times, slowest first
+func showProfile(profile map[string]*interpreter.FunctionProfile) {
+	names := make([]string, 0, len(profile))
+	for name := range profile {
+		names = append(names, name)
 	}
-	lines := bytes.Split(source, []byte{'\n'})
-	errorLine := string(lines[pos.Line-1])
-	numTabs := strings.Count(errorLine[:pos.Column-1], "\t")
-	fmt.Println(strings.Replace(errorLine, "\t", "    ", -1))
-	fmt.Println(strings.Repeat(" ", pos.Column-1) + strings.Repeat("   ", numTabs) + "^")
-	if divider != "" {
-		fmt.Println(divider)
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := profile[names[i]], profile[names[j]]
+		if pi.Time != pj.Time {
+			return pi.Time > pj.Time
+		}
+		return names[i] < names[j]
+	})
+	fmt.Printf("%-30s %10s %14s\n", "function", "calls", "time")
+	for _, name := range names {
+		p := profile[name]
+		fmt.Printf("%-30s %10d %14s\n", name, p.Calls, p.Time)
 	}
 }
 
-func main() {
-	if len(os.Args) < 2 || (os.Args[1] == "-stats" && len(os.Args) < 3) {
-		fmt.Printf("usage: littlelang [-stats] source_filename\n")
-		os.Exit(1)
+// Print the -stats line: elapsed time and the number of operations and
+// calls, along with their rates
+func showStats(stats *interpreter.Stats, elapsed time.Duration) {
+	fmt.Printf("%s elapsed: %d ops (%.0f/s), %d builtin calls (%.0f/s), %d user calls (%.0f/s)\n",
+		elapsed,
+		stats.Ops, float64(stats.Ops)/elapsed.Seconds(),
+		stats.BuiltinCalls, float64(stats.BuiltinCalls)/elapsed.Seconds(),
+		stats.UserCalls, float64(stats.UserCalls)/elapsed.Seconds(),
+	)
+}
+
+// Print source annotated with the number of times each line's statements
+// were executed, followed by a summary of statements covered. Only
+// statements in the named file (not in library or included files) count.
+func showCoverage(filename string, source []byte, coverage map[tokenizer.Position]int) {
+	lineCounts := make(map[int]int)
+	covered := 0
+	total := 0
+	for pos, count := range coverage {
+		if pos.Filename != filename {
+			continue
+		}
+		total++
+		if c, ok := lineCounts[pos.Line]; !ok || count > c {
+			lineCounts[pos.Line] = count
+		}
+		if count > 0 {
+			covered++
+		}
+	}
+	lines := bytes.Split(source, []byte{'\n'})
+	for i, line := range lines {
+		if i == len(lines)-1 && len(line) == 0 {
+			break
+		}
+		countStr := ""
+		if count, ok := lineCounts[i+1]; ok {
+			countStr = fmt.Sprintf("%d", count)
+		}
+		fmt.Printf("%8s | %s\n", countStr, line)
 	}
-	showStats := false
-	filename := os.Args[1]
-	execArgs := os.Args[2:]
-	if os.Args[1] == "-stats" {
-		showStats = true
-		filename = os.Args[2]
-		execArgs = os.Args[3:]
+	percent := 100.0
+	if total > 0 {
+		percent = float64(covered) * 100 / float64(total)
 	}
+	fmt.Printf("coverage: %d of %d statements executed (%.1f%%)\n", covered, total, percent)
+}
 
+// Exit statuses of the littlelang command. If the program calls exit(n),
+// the command exits with status n.
+const (
+	exitError   = 1   // other errors, like failing to read a source file
+	exitUsage   = 2   // invalid command line usage
+	exitParse   = 3   // tokenizer or parser error
+	exitRuntime = 4   // interpreter error
+	exitTimeout = 124 // execution stopped by -timeout (same as timeout(1))
+)
+
+func usage() {
+	fmt.Printf("usage: littlelang [options] [source_filename|- [args...]]\n")
+	fmt.Printf("       littlelang [options] library_filename... source_filename -- [args...]\n")
+	fmt.Printf("       littlelang [options] -e code [args...]\n")
+	fmt.Printf("       littlelang doc [-format markdown|html] source_filename\n")
+	fmt.Printf("       littlelang vet [-rules list] [-disable list] [-json] source_filename...\n")
+	fmt.Printf("       littlelang fmt [-d] source_filename...\n")
+	fmt.Printf("       littlelang test [-v] dir_or_filename...\n")
+	fmt.Printf("       littlelang selftest\n\n")
+	flag.PrintDefaults()
+	fmt.Printf(`
+exit status is 0 on success, %d for other errors, %d for usage errors,
+%d for parse errors, %d for runtime errors, %d if -timeout is reached, or n
+if the program calls exit(n)
+`, exitError, exitUsage, exitParse, exitRuntime, exitTimeout)
+}
+
+// Read the given source file, returning false if it can't be read. The
+// filename "-" means standard input, and names like "std/strings" that
+// don't exist as files are loaded from the embedded standard library.
+func readSource(filename string) ([]byte, bool) {
+	if filename == "-" {
+		input, err := ioutil.ReadAll(os.Stdin)
+		return input, err == nil
+	}
 	input, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Printf("error reading %q\n", os.Args[1])
-		os.Exit(1)
+		return stdSource(filename)
 	}
+	return input, true
+}
 
-	prog, err := parser.ParseProgram(input)
+// Read and parse the given source file, or exit with an error message
+func parseFile(filename string) ([]byte, *parser.Program) {
+	input, ok := readSource(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error reading %q\n", filename)
+		os.Exit(exitError)
+	}
+	if filename == "-" {
+		filename = ""
+	}
+	return input, parseSource(filename, input)
+}
+
+// Parse the given program source, or exit with an error message. Positions
+// in the program include the filename, unless it's empty.
+func parseSource(filename string, input []byte) *parser.Program {
+	if filename != "" {
+		sourceFiles[filename] = input
+	}
+	prog, err := parser.ParseFile(filename, input)
 	if err != nil {
-		errorMessage := fmt.Sprintf("%s", err)
-		if e, ok := err.(parser.Error); ok {
-			showErrorSource(input, e.Position, len(errorMessage))
+		showError(input, err)
+		os.Exit(exitParse)
+	}
+	return prog
+}
+
+// Standard input for a program that was itself read from standard input,
+// so that reading from it gives a clear error
+type usedStdin struct{}
+
+func (usedStdin) Read(p []byte) (int, error) {
+	return 0, errors.New("standard input was used to read the program")
+}
+
+// Return the path of the named library file, searching each directory in
+// path in turn if it's not found relative to the current directory
+func findFile(name string, path []string) string {
+	if _, err := os.Stat(name); err == nil || filepath.IsAbs(name) {
+		return name
+	}
+	for _, dir := range path {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
 		}
-		fmt.Println(errorMessage)
-		os.Exit(1)
+	}
+	return name
+}
+
+// Return the source of the standard library module with the given name
+// (for example "std/strings"), and whether it exists
+func stdSource(name string) ([]byte, bool) {
+	if !strings.HasPrefix(name, "std/") {
+		return nil, false
+	}
+	return std.Source(strings.TrimPrefix(name, "std/"))
+}
+
+// Split the positional args into the source files to run (library files
+// followed by the main file) and the args to pass to the program. If a "--"
+// arg directly follows a list of source files (names ending in ".ll" or
+// standard library modules like "std/strings"), every arg before it is a
+// source file; otherwise only the first is, or none if the program is given
+// with -e. A "--" after a program arg, as in "prog.ll x -- y", is passed to
+// the program like any other arg.
+func splitArgs(args []string, code bool) (sources, progArgs []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			if i > 0 {
+				return args[:i], args[i+1:]
+			}
+			break
+		}
+		if !strings.HasSuffix(arg, ".ll") && !strings.HasPrefix(arg, "std/") {
+			break
+		}
+	}
+	if code || len(args) == 0 {
+		return nil, args
+	}
+	return args[:1], args[1:]
+}
+
+// Report whether args start with the named subcommand followed by at least
+// minArgs args of its own. If a file with the command's name exists, like a
+// script called "test", args are a program to run instead, as they were
+// before the command was added.
+func isCommand(args []string, name string, minArgs int) bool {
+	if len(args) < minArgs+1 || args[0] != name {
+		return false
+	}
+	_, err := os.Stat(name)
+	return err != nil
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func main() {
+	statsFlag := flag.Bool("stats", false, "show interpreter statistics after running")
+	bench := flag.Int("bench", 0, "run the program `n` times, each in a fresh interpreter with output discarded, "+
+		"and show the run times and statistics")
+	profile := flag.Bool("profile", false, "show per-function call counts and times after running")
+	cover := flag.Bool("cover", false, "show source annotated with line execution counts after running")
+	astFormat := optionalFlag("ast", "text", "print parsed AST as `format` (text or json) and exit")
+	showTokens := flag.Bool("tokens", false, "print token stream with positions and exit")
+	printVersion := flag.Bool("version", false, "print version information and exit")
+	var libs stringList
+	flag.Var(&libs, "lib", "run library `file` before the main file (can be given more than once)")
+	timeout := flag.Duration("timeout", 0, "stop execution with an error after `duration` (for example 5s)")
+	sandbox := flag.Bool("sandbox", false, "run the program in a sandbox (enables all -no-* restrictions)")
+	noFS := flag.Bool("no-fs", false, "don't allow the program to access the filesystem")
+	cpuProfile := flag.String("cpuprofile", "", "write Go CPU profile of the interpreter to `file`")
+	memProfile := flag.String("memprofile", "", "write Go memory profile of the interpreter to `file`")
+	var includeDirs stringList
+	flag.Var(&includeDirs, "I", "search `dir` for library and include() files (can be given more than once;\n"+
+		"searched before -path, $LLPATH, and $LITTLELANG_PATH)")
+	pathFlag := flag.String("path", "", "list of `dirs` to search for library and include() files, separated by "+
+		string(filepath.ListSeparator)+" (searched after -I and before $LLPATH and $LITTLELANG_PATH)")
+	floorDiv := flag.Bool("floor-div", false, "make / and % round toward negative infinity, like Python")
+	truthy := flag.Bool("truthy", false, "allow conditions and and/or/not operands of any type, using the rules of bool()")
+	noWarnings := flag.Bool("no-warnings", false, "don't warn about suspicious code, like assigning to a builtin's name")
+	var exts stringList
+	flag.Var(&exts, "ext", "load builtin functions from Go plugin `file` (can be given more than once)")
+	watchFiles := flag.Bool("watch", false, "re-run the program whenever it or its library or included files change")
+	code := flag.String("e", "", "run `code` as the program instead of reading a source file (all args are passed to args())")
+	flag.Usage = usage
+	flag.Parse()
+	if *printVersion {
+		showVersion()
+		return
+	}
+	if isCommand(flag.Args(), "doc", 1) {
+		os.Exit(docCommand(flag.Args()[1:]))
+	}
+	if isCommand(flag.Args(), "vet", 1) {
+		os.Exit(vetCommand(flag.Args()[1:]))
+	}
+	if isCommand(flag.Args(), "fmt", 1) {
+		os.Exit(fmtCommand(flag.Args()[1:]))
+	}
+	if isCommand(flag.Args(), "test", 1) {
+		os.Exit(testCommand(flag.Args()[1:]))
+	}
+	if flag.NArg() == 1 && isCommand(flag.Args(), "selftest", 0) {
+		os.Exit(selftest())
+	}
+	// With no source file or -e code, start an interactive REPL, unless
+	// stdin isn't a terminal, in which case the program is read from it (as
+	// with a filename of "-")
+	var filename string
+	sources, execArgs := splitArgs(flag.Args(), *code != "")
+	if *code == "" && len(sources) > 0 {
+		filename = sources[len(sources)-1]
+		sources = sources[:len(sources)-1]
+	}
+	// Copy libs rather than appending to it, as it's the -lib flag's value
+	libNames := append(append([]string{}, libs...), sources...)
+	switch {
+	case *code != "" || filename != "":
+	case !isTerminal(os.Stdin):
+		filename = "-"
+	case *showTokens || astFormat.set || *watchFiles || *bench != 0:
+		usage()
+		os.Exit(exitUsage)
+	}
+	if *bench < 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	watchIncludes := os.Getenv(watchChildEnv)
+	if watchIncludes != "" {
+		*watchFiles = false
+	}
+	if *watchFiles && filename == "-" {
+		fmt.Fprintln(os.Stderr, "can't use -watch with a program read from standard input")
+		os.Exit(exitUsage)
+	}
+
+	if *showTokens {
+		input := []byte(*code)
+		if filename != "" {
+			var ok bool
+			input, ok = readSource(filename)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "error reading %q\n", filename)
+				os.Exit(exitError)
+			}
+		}
+		if !dumpTokens(os.Stdout, input) {
+			os.Exit(exitParse)
+		}
+		return
+	}
+
+	path := []string(includeDirs)
+	if *pathFlag != "" {
+		path = append(path, filepath.SplitList(*pathFlag)...)
+	}
+	path = append(path, filepath.SplitList(os.Getenv("LLPATH"))...)
+	path = append(path, filepath.SplitList(os.Getenv("LITTLELANG_PATH"))...)
+	libFilenames := make([]string, len(libNames))
+	for i, lib := range libNames {
+		libFilenames[i] = findFile(lib, path)
+	}
+
+	if *watchFiles {
+		files := libFilenames
+		if filename != "" {
+			files = append(files, filename)
+		}
+		os.Exit(watch(files, watchArgs(flag.CommandLine)))
+	}
+
+	var input []byte
+	var prog *parser.Program
+	if *code != "" {
+		input = []byte(*code)
+		prog = parseSource("", input)
+	} else if filename != "" {
+		input, prog = parseFile(filename)
+	}
+
+	if astFormat.set {
+		err := dumpAST(os.Stdout, prog, astFormat.value)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+
+	type libFile struct {
+		input []byte
+		prog  *parser.Program
+	}
+	libFiles := make([]libFile, len(libFilenames))
+	for i, libFilename := range libFilenames {
+		libInput, libProg := parseFile(libFilename)
+		libFiles[i] = libFile{libInput, libProg}
 	}
 
 	startTime := time.Now()
-	stats, err := interpreter.Execute(prog, &interpreter.Config{Args: execArgs})
+	config := &interpreter.Config{
+		Args:          execArgs,
+		IncludePath:   path,
+		Profile:       *profile,
+		Cover:         *cover,
+		NoWarnings:    *noWarnings,
+		FloorDivision: *floorDiv,
+		Truthy:        *truthy,
+		Sandbox: interpreter.Sandbox{
+			NoFS: *sandbox || *noFS,
+		},
+	}
+	if watchIncludes != "" {
+		config.Included = recordIncludes(watchIncludes)
+	}
+	signals := newSignalNotifier()
+	config.Signals, config.Trap = signals.signals, signals.trap
+	// The REPL reads statements from the same buffered stdin as the
+	// program's read() and readline() calls
+	stdin := bufio.NewReader(os.Stdin)
+	config.Stdin = stdin
+	if filename == "-" {
+		config.Stdin = usedStdin{}
+	}
+	if len(exts) > 0 {
+		config.Builtins = make(map[string]interpreter.BuiltinFunc)
+		for _, ext := range exts {
+			loadExtension(findFile(ext, path), config.Builtins)
+		}
+	}
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		config.Context = ctx
+	}
+	stopCPUProfile := startCPUProfile(*cpuProfile)
+	config.Exit = func(status int) {
+		stopCPUProfile()
+		writeMemProfile(*memProfile)
+		os.Exit(status)
+	}
+	// Create an interpreter and run the library files in it, returning a
+	// non-zero status if one of them fails
+	newInterpreter := func() (*interpreter.Interpreter, int) {
+		interp := interpreter.New(config)
+		for _, lib := range libFiles {
+			status := execute(interp, lib.input, lib.prog)
+			if status != 0 {
+				return interp, status
+			}
+		}
+		return interp, 0
+	}
+	// Run the program in a fresh interpreter (or start the REPL if there's
+	// no program), returning the interpreter and exit status
+	run := func() (*interpreter.Interpreter, int) {
+		interp, status := newInterpreter()
+		if status == 0 {
+			if prog == nil {
+				interp, status = repl(interp, stdin, signals, newInterpreter)
+			} else {
+				status = execute(interp, input, prog)
+			}
+		}
+		if err := interp.Stop(); err != nil && status == 0 {
+			showError(input, err)
+			status = exitRuntime
+		}
+		return interp, status
+	}
+	var interp *interpreter.Interpreter
+	var status int
+	var times []time.Duration
+	if *bench > 0 {
+		// Only the timings are of interest, not the program's output
+		config.Stdout = ioutil.Discard
+		times, interp, status = benchmark(*bench, run)
+	} else {
+		interp, status = run()
+	}
+	stopCPUProfile()
+	writeMemProfile(*memProfile)
+	if status != 0 {
+		os.Exit(status)
+	}
+	stats := interp.Stats()
+	if *bench > 0 {
+		showStats(stats, showBenchmark(times))
+	} else if *statsFlag {
+		showStats(stats, time.Since(startTime))
+	}
+	if *profile {
+		showProfile(stats.Profile)
+	}
+	if *cover {
+		coverFilename := filename
+		if coverFilename == "-" {
+			coverFilename = ""
+		}
+		showCoverage(coverFilename, input, stats.Coverage)
+	}
+}
+
+// Execute program in interpreter. On error, show the error message and
+// return a non-zero exit status.
+func execute(interp *interpreter.Interpreter, input []byte, prog *parser.Program) int {
+	err := interp.Execute(prog)
 	if err != nil {
-		errorMessage := fmt.Sprintf("%s", err)
-		if e, ok := err.(interpreter.Error); ok {
-			showErrorSource(input, e.Position(), len(errorMessage))
-		}
-		fmt.Println(errorMessage)
-		os.Exit(1)
-	}
-	if showStats {
-		elapsed := time.Since(startTime)
-		fmt.Printf("%s elapsed: %d ops (%.0f/s), %d builtin calls (%.0f/s), %d user calls (%.0f/s)\n",
-			elapsed,
-			stats.Ops, float64(stats.Ops)/elapsed.Seconds(),
-			stats.BuiltinCalls, float64(stats.BuiltinCalls)/elapsed.Seconds(),
-			stats.UserCalls, float64(stats.UserCalls)/elapsed.Seconds(),
-		)
+		showError(input, err)
+		if _, ok := err.(interpreter.TimeoutError); ok {
+			return exitTimeout
+		}
+		return exitRuntime
 	}
+	return 0
 }
@@ -10,18 +10,24 @@ EOF = "EOF"
 
 // Keywords
 AND = "and"
+CATCH = "catch"
 ELSE = "else"
 FALSE = "false"
 FOR = "for"
 FUNC = "func"
 IF = "if"
 IN = "in"
+MATCH = "match"
 NIL = "nil"
 NOT = "not"
 OR = "or"
+OUTER = "outer"
 RETURN = "return"
+STRUCT = "struct"
 TRUE = "true"
+TRY = "try"
 WHILE = "while"
+YIELD = "yield"
 
 // Single-character tokens
 ASSIGN = "="
@@ -55,29 +61,38 @@ EQUAL = "=="
 GTE = ">="
 LTE = "<="
 NOTEQUAL = "!="
+OPTDOT = "?."
+OPTLBRACKET = "?["
 
 // Three-character tokens
 ELLIPSIS = "..."
 
 // Literals and identifiers
+FLOAT = "float"
 INT = "int"
 NAME = "name"
 STR = "str"
 
 keyword_tokens = {
     "and": true,
+    "catch": true,
     "else": true,
     "false": true,
     "for": true,
     "func": true,
     "if": true,
     "in": true,
+    "match": true,
     "nil": true,
     "not": true,
     "or": true,
+    "outer": true,
     "return": true,
+    "struct": true,
     "true": true,
+    "try": true,
     "while": true,
+    "yield": true,
 }
 
 func Pos(line, col) {
@@ -126,6 +141,19 @@ func tokenize(source) {
         }
     }
 
+    // Return the character n characters after the current one, or nil if
+    // that's past the end of the source
+    func peek(n) {
+        if t.offset + n >= len(source) {
+            return nil
+        }
+        return source[t.offset + n]
+    }
+
+    func is_digit(c) {
+        return c != nil and c >= "0" and c <= "9"
+    }
+
     func skip_whitespace_and_comments() {
         while true {
             while t.ch == " " or t.ch == "\t" or t.ch == "\r" or t.ch == "\n" {
@@ -147,6 +175,12 @@ func tokenize(source) {
     // Kick things off
     tokens = []
     next()
+    if t.ch == "#" and peek(0) == "!" {
+        // Skip "#!" line at start of source so scripts can be executable
+        while t.ch != "\n" and t.ch != nil {
+            next()
+        }
+    }
 
     func end(tok, val, line, col) {
         append(tokens, Token(tok, val, Pos(line, col)))
@@ -192,6 +226,16 @@ func tokenize(source) {
             } else {
                 return end(ILLEGAL, "expected != instead of !" + t.ch, line, col)
             }
+        } else if ch == "?" {
+            if t.ch == "." {
+                next()
+                tok = OPTDOT
+            } else if t.ch == "[" {
+                next()
+                tok = OPTLBRACKET
+            } else {
+                return end(ILLEGAL, "expected ?. or ?[ instead of ?" + t.ch, line, col)
+            }
         } else if ch == "<" {
             if t.ch == "=" {
                 next()
@@ -224,15 +268,56 @@ func tokenize(source) {
                 next()
             }
             tok = INT
-            val = int(join(chars, ""))
+            // A float has a fractional part, an exponent, or both
+            if t.ch == "." and is_digit(peek(0)) {
+                append(chars, t.ch)
+                next()
+                while t.ch != nil and t.ch >= "0" and t.ch <= "9" {
+                    append(chars, t.ch)
+                    next()
+                }
+                tok = FLOAT
+            }
+            if (t.ch == "e" or t.ch == "E") and
+                    (is_digit(peek(0)) or ((peek(0) == "+" or peek(0) == "-") and is_digit(peek(1)))) {
+                append(chars, t.ch)
+                next()
+                if t.ch == "+" or t.ch == "-" {
+                    append(chars, t.ch)
+                    next()
+                }
+                while t.ch != nil and t.ch >= "0" and t.ch <= "9" {
+                    append(chars, t.ch)
+                    next()
+                }
+                tok = FLOAT
+            }
+            if tok == INT {
+                val = int(join(chars, ""))
+            } else {
+                val = float(join(chars, ""))
+                if val == nil {
+                    return end(ILLEGAL, "float literal " + join(chars, "") + " out of range", line, col)
+                }
+            }
         } else if ch == "\"" {
+            // A triple-quoted string may contain newlines
+            triple = false
+            if t.ch == "\"" and peek(0) == "\"" {
+                next()
+                next()
+                triple = true
+            }
             chars = []
-            while t.ch != "\"" {
+            while not (t.ch == "\"" and (not triple or (peek(0) == "\"" and peek(1) == "\""))) {
                 c = t.ch
                 if c == nil {
+                    if triple {
+                        return end(ILLEGAL, "didn't find end \"\"\" in string", line, col)
+                    }
                     return end(ILLEGAL, "didn't find end quote in string", line, col)
                 }
-                if c == "\r" or c == "\n" {
+                if (c == "\r" or c == "\n") and not triple {
                     return end(ILLEGAL, "can't have newline in string", line, col)
                 }
                 if c == "\\" {
@@ -248,8 +333,28 @@ func tokenize(source) {
                     } else {
                         return end(ILLEGAL, "invalid string escape \\" + t.ch, line, col)
                     }
+                    append(chars, c)
+                } else if c != "\r" {
+                    append(chars, c)
+                }
+                next()
+            }
+            if triple {
+                next()
+                next()
+            }
+            next()
+            tok = STR
+            val = join(chars, "")
+        } else if ch == "`" {
+            chars = []
+            while t.ch != "`" {
+                if t.ch == nil {
+                    return end(ILLEGAL, "didn't find end backtick in raw string", line, col)
+                }
+                if t.ch != "\r" {
+                    append(chars, t.ch)
                 }
-                append(chars, c)
                 next()
             }
             next()
@@ -315,6 +420,16 @@ func Assign(pos, target, value) {
     return self
 }
 
+func OuterAssign(pos, name, value) {
+    self = Node("OuterAssign", pos)
+    self.name = name
+    self.value = value
+    self.str = func() {
+        return "outer " + self.name + " = " + self.value.str()
+    }
+    return self
+}
+
 func If(pos, condition, body, else_body) {
     self = Node("If", pos)
     self.condition = condition
@@ -340,13 +455,45 @@ func While(pos, condition, body) {
     return self
 }
 
-func For(pos, name, iterable, body) {
+func For(pos, name, value_name, iterable, body) {
     self = Node("For", pos)
     self.name = name
+    self.value_name = value_name
     self.iterable = iterable
     self.body = body
     self.str = func() {
-        return "for " + self.name + " in " + self.iterable.str() + " {\n" + indent(self.body.str()) + "\n}"
+        names = self.name
+        if self.value_name != nil {
+            names = names + ", " + self.value_name
+        }
+        return "for " + names + " in " + self.iterable.str() + " {\n" + indent(self.body.str()) + "\n}"
+    }
+    return self
+}
+
+func Try(pos, body, error_name, catch_body) {
+    self = Node("Try", pos)
+    self.body = body
+    self.error_name = error_name
+    self.catch_body = catch_body
+    self.str = func() {
+        return "try {\n" + indent(self.body.str()) + "\n} catch " + self.error_name +
+            " {\n" + indent(self.catch_body.str()) + "\n}"
+    }
+    return self
+}
+
+// Cases is a list of [pattern, body] pairs
+func Match(pos, value, cases) {
+    self = Node("Match", pos)
+    self.value = value
+    self.cases = cases
+    self.str = func() {
+        cases = []
+        for c in self.cases {
+            append(cases, indent(c[0].str() + " {\n" + indent(c[1].str()) + "\n}"))
+        }
+        return "match " + self.value.str() + " {\n" + join(cases, "\n") + "\n}"
     }
     return self
 }
@@ -360,6 +507,15 @@ func Return(pos, result) {
     return self
 }
 
+func Yield(pos, value) {
+    self = Node("Yield", pos)
+    self.value = value
+    self.str = func() {
+        return "yield " + self.value.str()
+    }
+    return self
+}
+
 func ExpressionStatement(pos, expr) {
     self = Node("ExpressionStatement", pos)
     self.expr = expr
@@ -369,12 +525,15 @@ func ExpressionStatement(pos, expr) {
     return self
 }
 
-func FunctionDefinition(pos, name, params, ellipsis, body) {
+// Struct_name is the name of the struct for a method, otherwise nil
+func FunctionDefinition(pos, struct_name, name, params, ellipsis, body, generator) {
     self = Node("FunctionDefinition", pos)
+    self.struct_name = struct_name
     self.name = name
     self.params = params
     self.ellipsis = ellipsis
     self.body = body
+    self.generator = generator
     self.str = func() {
         ellipsis_str = ""
         if self.ellipsis {
@@ -384,7 +543,21 @@ func FunctionDefinition(pos, name, params, ellipsis, body) {
         if len(self.body) != 0 {
             body_str = "\n" + indent(self.body.str()) + "\n"
         }
-        return "func " + self.name + "(" + join(self.params, ", ") + ellipsis_str + ") {" + body_str + "}"
+        name = self.name
+        if self.struct_name != nil {
+            name = self.struct_name + "." + name
+        }
+        return "func " + name + "(" + join(self.params, ", ") + ellipsis_str + ") {" + body_str + "}"
+    }
+    return self
+}
+
+func StructDefinition(pos, name, fields) {
+    self = Node("StructDefinition", pos)
+    self.name = name
+    self.fields = fields
+    self.str = func() {
+        return "struct " + self.name + " { " + join(self.fields, " ") + " }"
     }
     return self
 }
@@ -414,21 +587,22 @@ func Unary(pos, operator, operand) {
     return self
 }
 
-func Call(pos, function, args, ellipsis) {
+// Args may include Spread nodes, and keywords is a list of [name, value]
+// pairs for name=value arguments
+func Call(pos, function, args, keywords) {
     self = Node("Call", pos)
     self.function = function
     self.args = args
-    self.ellipsis = ellipsis
+    self.keywords = keywords
     self.str = func() {
         args = []
         for arg in self.args {
             append(args, arg.str())
         }
-        ellipsis_str = ""
-        if self.ellipsis {
-            ellipsis_str = "..."
+        for keyword in self.keywords {
+            append(args, keyword[0] + "=" + keyword[1].str())
         }
-        return self.function.str() + "(" + join(args, ", ") + ellipsis_str + ")"
+        return self.function.str() + "(" + join(args, ", ") + ")"
     }
     return self
 }
@@ -460,24 +634,38 @@ func List(pos, values) {
     return self
 }
 
+func Spread(pos, value) {
+    self = Node("Spread", pos)
+    self.value = value
+    self.str = func() {
+        return self.value.str() + "..."
+    }
+    return self
+}
+
 func Map(pos, items) {
     self = Node("Map", pos)
     self.items = items
     self.str = func() {
         items = []
         for item in self.items {
-            append(items, item[0].str() + ": " + item[1].str())
+            if item[0] == nil {
+                append(items, item[1].str())
+            } else {
+                append(items, item[0].str() + ": " + item[1].str())
+            }
         }
         return "{" + join(items, ", ") + "}"
     }
     return self
 }
 
-func FunctionExpression(pos, params, ellipsis, body) {
+func FunctionExpression(pos, params, ellipsis, body, generator) {
     self = Node("FunctionExpression", pos)
     self.params = params
     self.ellipsis = ellipsis
     self.body = body
+    self.generator = generator
     self.str = func() {
         ellipsis_str = ""
         if self.ellipsis {
@@ -492,16 +680,39 @@ func FunctionExpression(pos, params, ellipsis, body) {
     return self
 }
 
-func Subscript(pos, container, subscript) {
+func Subscript(pos, container, subscript, optional) {
     self = Node("Subscript", pos)
     self.container = container
     self.subscript = subscript
+    self.optional = optional
     self.str = func() {
+        if self.optional {
+            return self.container.str() + "?[" + self.subscript.str() + "]"
+        }
         return self.container.str() + "[" + self.subscript.str() + "]"
     }
     return self
 }
 
+func Slice(pos, container, start, end) {
+    self = Node("Slice", pos)
+    self.container = container
+    self.start = start
+    self.end = end
+    self.str = func() {
+        start_str = ""
+        if self.start != nil {
+            start_str = self.start.str()
+        }
+        end_str = ""
+        if self.end != nil {
+            end_str = self.end.str()
+        }
+        return self.container.str() + "[" + start_str + ":" + end_str + "]"
+    }
+    return self
+}
+
 func Variable(pos, name) {
     self = Node("Variable", pos)
     self.name = name
@@ -523,6 +734,8 @@ func parse(source) {
     p.tok = nil
     p.val = nil
     p.pos = nil
+    p.in_function = false
+    p.yielded = false
 
     func error(msg) {
         print("parse error at " + str(p.pos.line) + ":" + str(p.pos.col) + ": " + msg)
@@ -577,15 +790,28 @@ func parse(source) {
             return while_()
         } else if p.tok == FOR {
             return for_()
+        } else if p.tok == TRY {
+            return try_()
+        } else if p.tok == MATCH {
+            return match_()
         } else if p.tok == RETURN {
             return return_()
+        } else if p.tok == YIELD {
+            return yield_()
         } else if p.tok == FUNC {
             return func_()
+        } else if p.tok == STRUCT {
+            return struct_()
+        } else if p.tok == OUTER {
+            return outer_()
         }
         pos = p.pos
         expr = expression()
         if p.tok == ASSIGN {
             pos = p.pos
+            if expr.type == "Subscript" and expr.optional {
+                error("can't assign to ?[ or ?. expression")
+            }
             if expr.type == "Variable" or expr.type == "Subscript" {
                 next()
                 value = expression()
@@ -636,10 +862,131 @@ func parse(source) {
         expect(FOR)
         name = p.val
         expect(NAME)
+        value_name = nil
+        if p.tok == COMMA {
+            next()
+            value_name = p.val
+            expect(NAME)
+        }
         expect(IN)
         iterable = expression()
         body = block()
-        return For(pos, name, iterable, body)
+        return For(pos, name, value_name, iterable, body)
+    }
+
+    func try_() {
+        pos = p.pos
+        expect(TRY)
+        body = block()
+        expect(CATCH)
+        error_name = p.val
+        expect(NAME)
+        catch_body = block()
+        return Try(pos, body, error_name, catch_body)
+    }
+
+    func match_() {
+        pos = p.pos
+        expect(MATCH)
+        value = expression()
+        expect(LBRACE)
+        cases = []
+        while p.tok != RBRACE and p.tok != EOF {
+            pattern = pattern_({})
+            body = block()
+            append(cases, [pattern, body])
+        }
+        expect(RBRACE)
+        return Match(pos, value, cases)
+    }
+
+    // Names records the names bound so far in the pattern
+    func pattern_(names) {
+        if p.tok == NAME {
+            if p.val in names {
+                error("duplicate name " + p.val + " in pattern")
+            }
+            names[p.val] = true
+            return primary()
+        } else if p.tok == INT or p.tok == FLOAT or p.tok == STR or p.tok == TRUE or p.tok == FALSE or p.tok == NIL {
+            return primary()
+        } else if p.tok == MINUS {
+            pos = p.pos
+            next()
+            if p.tok != INT and p.tok != FLOAT {
+                error("expected number after - in pattern, not " + p.tok)
+            }
+            return Literal(pos, -primary().value)
+        } else if p.tok == LBRACKET {
+            pos = p.pos
+            next()
+            values = []
+            got_comma = true
+            got_ellipsis = false
+            while p.tok != RBRACKET and p.tok != EOF and not got_ellipsis {
+                if not got_comma {
+                    error("expected , between list patterns")
+                }
+                value = pattern_(names)
+                if p.tok == ELLIPSIS {
+                    if value.type != "Variable" {
+                        error("can only use ... after a name in a pattern")
+                    }
+                    value = Spread(value.pos, value)
+                    got_ellipsis = true
+                    next()
+                }
+                append(values, value)
+                if p.tok == COMMA {
+                    got_comma = true
+                    next()
+                } else {
+                    got_comma = false
+                }
+            }
+            if p.tok != RBRACKET and got_ellipsis {
+                error("can only have ... after last list pattern")
+            }
+            expect(RBRACKET)
+            return List(pos, values)
+        } else if p.tok == LBRACE {
+            pos = p.pos
+            next()
+            items = []
+            got_comma = true
+            while p.tok != RBRACE and p.tok != EOF {
+                if not got_comma {
+                    error("expected , between map patterns")
+                }
+                if p.tok != STR {
+                    error("map pattern key must be a str, not " + p.tok)
+                }
+                key = primary()
+                expect(COLON)
+                value = pattern_(names)
+                append(items, [key, value])
+                if p.tok == COMMA {
+                    got_comma = true
+                    next()
+                } else {
+                    got_comma = false
+                }
+            }
+            expect(RBRACE)
+            return Map(pos, items)
+        } else {
+            error("expected pattern, not " + p.tok)
+        }
+    }
+
+    func outer_() {
+        pos = p.pos
+        expect(OUTER)
+        name = p.val
+        expect(NAME)
+        expect(ASSIGN)
+        value = expression()
+        return OuterAssign(pos, name, value)
     }
 
     func return_() {
@@ -649,23 +996,71 @@ func parse(source) {
         return Return(pos, result)
     }
 
+    func yield_() {
+        pos = p.pos
+        expect(YIELD)
+        if not p.in_function {
+            p.pos = pos
+            error("can't yield outside a function")
+        }
+        p.yielded = true
+        value = expression()
+        return Yield(pos, value)
+    }
+
     func func_() {
         pos = p.pos
         expect(FUNC)
         if p.tok == NAME {
+            struct_name = nil
             name = p.val
             next()
+            if p.tok == DOT {
+                next()
+                struct_name = name
+                name = p.val
+                expect(NAME)
+            }
+            params_pos = p.pos
             params_ellipsis = params()
-            body = block()
-            return FunctionDefinition(pos, name, params_ellipsis[0], params_ellipsis[1], body)
+            if struct_name != nil and len(params_ellipsis[0]) == 0 {
+                p.pos = params_pos
+                error("method " + struct_name + "." + name + " needs a parameter for its receiver")
+            }
+            body_generator = function_body(block)
+            return FunctionDefinition(pos, struct_name, name, params_ellipsis[0], params_ellipsis[1], body_generator[0], body_generator[1])
         } else {
             params_ellipsis = params()
-            body = block()
-            expr = FunctionExpression(pos, params_ellipsis[0], params_ellipsis[1], body)
+            body_generator = function_body(body_)
+            expr = FunctionExpression(pos, params_ellipsis[0], params_ellipsis[1], body_generator[0], body_generator[1])
             return ExpressionStatement(pos, expr)
         }
     }
 
+    // Parse a function body using parse, returning [body, generator],
+    // where generator is true if the body has a yield statement
+    func function_body(parse) {
+        in_function = p.in_function
+        yielded = p.yielded
+        p.in_function = true
+        p.yielded = false
+        body = parse()
+        generator = p.yielded
+        p.in_function = in_function
+        p.yielded = yielded
+        return [body, generator]
+    }
+
+    // Short "lambda" body like ": x*2" is equivalent to "{ return x*2 }"
+    func body_() {
+        if p.tok != COLON {
+            return block()
+        }
+        next()
+        result = expression()
+        return Block(result.pos, [Return(result.pos, result)])
+    }
+
     func params() {
         expect(LPAREN)
         params = []
@@ -696,6 +1091,27 @@ func parse(source) {
         return [params, got_ellipsis]
     }
 
+    func struct_() {
+        pos = p.pos
+        expect(STRUCT)
+        name = p.val
+        expect(NAME)
+        expect(LBRACE)
+        fields = []
+        while p.tok != RBRACE and p.tok != EOF {
+            if p.tok != NAME {
+                error("expected field name, not " + p.tok)
+            }
+            if p.val in fields {
+                error("duplicate field " + p.val + " in struct " + name)
+            }
+            append(fields, p.val)
+            next()
+        }
+        expect(RBRACE)
+        return StructDefinition(pos, name, fields)
+    }
+
     func binary(parse_expr, operators...) {
         expr = parse_expr()
         while matches(operators...) {
@@ -754,22 +1170,40 @@ func parse(source) {
 
     func call() {
         expr = primary()
-        while matches(LPAREN, LBRACKET, DOT) {
+        while matches(LPAREN, LBRACKET, DOT, OPTLBRACKET, OPTDOT) {
             if p.tok == LPAREN {
                 pos = p.pos
                 next()
                 args = []
+                keywords = []
                 got_comma = true
-                got_ellipsis = false
-                while p.tok != RPAREN and p.tok != EOF and not got_ellipsis {
+                while p.tok != RPAREN and p.tok != EOF {
                     if not got_comma {
                         error("expected , between arguments")
                     }
+                    arg_pos = p.pos
                     arg = expression()
-                    append(args, arg)
-                    if p.tok == ELLIPSIS {
-                        got_ellipsis = true
+                    if arg.type == "Variable" and p.tok == ASSIGN {
+                        for keyword in keywords {
+                            if keyword[0] == arg.name {
+                                p.pos = arg_pos
+                                error("duplicate keyword argument " + arg.name)
+                            }
+                        }
                         next()
+                        append(keywords, [arg.name, expression()])
+                        if p.tok == ELLIPSIS {
+                            error("can't use ... with keyword arguments")
+                        }
+                    } else if len(keywords) > 0 {
+                        p.pos = arg_pos
+                        error("positional argument can't follow keyword arguments")
+                    } else {
+                        if p.tok == ELLIPSIS {
+                            arg = Spread(arg.pos, arg)
+                            next()
+                        }
+                        append(args, arg)
                     }
                     if p.tok == COMMA {
                         got_comma = true
@@ -778,23 +1212,40 @@ func parse(source) {
                         got_comma = false
                     }
                 }
-                if p.tok != RPAREN and got_ellipsis {
-                    error("can only have ... after last argument")
-                }
                 expect(RPAREN)
-                expr = Call(pos, expr, args, got_ellipsis)
+                expr = Call(pos, expr, args, keywords)
             } else if p.tok == LBRACKET {
+                pos = p.pos
+                next()
+                start = nil
+                if p.tok != COLON {
+                    start = expression()
+                }
+                if start != nil and p.tok != COLON {
+                    expect(RBRACKET)
+                    expr = Subscript(pos, expr, start, false)
+                } else {
+                    expect(COLON)
+                    end = nil
+                    if p.tok != RBRACKET {
+                        end = expression()
+                    }
+                    expect(RBRACKET)
+                    expr = Slice(pos, expr, start, end)
+                }
+            } else if p.tok == OPTLBRACKET {
                 pos = p.pos
                 next()
                 subscript = expression()
                 expect(RBRACKET)
-                expr = Subscript(pos, expr, subscript)
+                expr = Subscript(pos, expr, subscript, true)
             } else {
                 pos = p.pos
+                optional = p.tok == OPTDOT
                 next()
                 subscript = Literal(p.pos, p.val)
                 expect(NAME)
-                expr = Subscript(pos, expr, subscript)
+                expr = Subscript(pos, expr, subscript, optional)
             }
         }
         return expr
@@ -806,7 +1257,7 @@ func parse(source) {
             pos = p.pos
             next()
             return Variable(pos, name)
-        } else if p.tok == INT or p.tok == STR {
+        } else if p.tok == INT or p.tok == FLOAT or p.tok == STR {
             val = p.val
             pos = p.pos
             next()
@@ -831,8 +1282,8 @@ func parse(source) {
             pos = p.pos
             next()
             params_ellipsis = params()
-            body = block()
-            return FunctionExpression(pos, params_ellipsis[0], params_ellipsis[1], body)
+            body_generator = function_body(body_)
+            return FunctionExpression(pos, params_ellipsis[0], params_ellipsis[1], body_generator[0], body_generator[1])
         } else if p.tok == LPAREN {
             next()
             expr = expression()
@@ -853,6 +1304,10 @@ func parse(source) {
                 error("expected , between list elements")
             }
             value = expression()
+            if p.tok == ELLIPSIS {
+                value = Spread(value.pos, value)
+                next()
+            }
             append(values, value)
             if p.tok == COMMA {
                 got_comma = true
@@ -875,9 +1330,14 @@ func parse(source) {
                 error("expected , between map items")
             }
             key = expression()
-            expect(COLON)
-            value = expression()
-            append(items, [key, value])
+            if p.tok == ELLIPSIS {
+                append(items, [nil, Spread(key.pos, key)])
+                next()
+            } else {
+                expect(COLON)
+                value = expression()
+                append(items, [key, value])
+            }
             if p.tok == COMMA {
                 got_comma = true
                 next()
@@ -917,37 +1377,121 @@ unary_funcs = {
     NOT:      func(v) { return not v },
 }
 
-// Remove the first arg (source filename) from target's args()
+// Remove the first arg (source filename) from target's args(). This is
+// defined inside a function so that it doesn't shadow the args() builtin
+// at the top level. Arguments are passed through to the builtin so that
+// calling it with any gives the builtin's error message.
 _args = args()
-func args() {
-    return slice(_args, 1, len(_args))
+func make_args() {
+    host_args = args
+    func args(a...) {
+        host_args(a...)
+        return slice(_args, 1, len(_args))
+    }
+    return args
 }
+target_args = make_args()
 
 builtins = {
+    "abs": abs,
     "append": append,
-    "args": args,
+    "appendfile": appendfile,
+    "args": target_args,
+    "assert": assert,
+    "bool": bool,
+    "bytes": bytes,
+    "casefold": casefold,
+    "ceil": ceil,
+    "chan": chan,
     "char": char,
+    "chars": chars,
+    "csv": csv,
+    "decode": decode,
+    "delete": delete,
+    "exists": exists,
     "exit": exit,
+    "filter": filter,
     "find": find,
+    "float": float,
+    "floor": floor,
+    "format": format,
+    "formattime": formattime,
+    "gunzip": gunzip,
+    "gzip": gzip,
+    "hex": hex,
+    "insert": insert,
     "int": int,
+    "intersect": intersect,
     "join": join,
+    "keys": keys,
     "len": len,
+    "listdir": listdir,
+    "lock": lock,
     "lower": lower,
+    "map": map,
+    "max": max,
+    "min": min,
+    "mkdir": mkdir,
+    "now": now,
+    "parsecsv": parsecsv,
+    "parsequery": parsequery,
+    "parsetime": parsetime,
+    "pop": pop,
+    "pow": pow,
     "print": print,
     "range": range,
     "read": read,
+    "readbytes": readbytes,
+    "readline": readline,
+    "recv": recv,
+    "reduce": reduce,
+    "remove": remove,
+    "replace": replace,
+    "repr": repr,
+    "round": round,
     "rune": rune,
+    "runelen": runelen,
+    "send": send,
+    "set": set,
     "slice": slice,
     "sort": sort,
     "split": split,
+    "splitlines": splitlines,
+    "sqrt": sqrt,
+    "stat": stat,
     "str": str,
+    "sum": sum,
+    "throw": throw,
+    "trap": trap,
     "type": type,
+    "unhex": unhex,
+    "union": union,
+    "unique": unique,
+    "unlock": unlock,
     "upper": upper,
+    "urldecode": urldecode,
+    "urlencode": urlencode,
+    "urlparse": urlparse,
+    "urlquery": urlquery,
+    "withlock": withlock,
+    "write": write,
+}
+
+// Builtins that can be called as methods of each type with dot syntax,
+// so s.upper() is the same as upper(s)
+methods = {
+    "str": ["bytes", "casefold", "chars", "find", "float", "format", "hex", "int", "len", "lower", "replace", "rune", "runelen", "slice", "split", "splitlines", "unhex", "upper"],
+    "bytes": ["decode", "gunzip", "gzip", "hex", "len"],
+    "list": ["append", "delete", "find", "insert", "join", "len", "max", "min", "pop", "slice", "sort", "sum", "unique"],
+    "map": ["delete", "keys", "len"],
+    "set": ["append", "intersect", "len", "max", "min", "sum", "union"],
 }
 
 func execute(program) {
     interp = {}
     interp.vars = []
+    interp.yielded = nil
+    interp.structs = []
 
     func error(msg) {
         print("execute error : " + msg)
@@ -978,8 +1522,196 @@ func execute(program) {
         return [nil, false]
     }
 
-    func user_function(name, params, ellipsis, body, closure) {
+    // Passed as the first argument to a user function to say that the
+    // other two arguments are the positional arguments and a list of
+    // [name, value] keyword arguments
+    keywords_marker = func() {}
+
+    // Passed as the only argument to a user function, struct constructor,
+    // or bound method to get the map funcinfo() returns for it
+    info_marker = func() {}
+
+    // Call the print() builtin with its sep and end keyword arguments
+    func print_keywords(args, keywords) {
+        options = {"sep": " ", "end": "\n"}
+        for keyword in keywords {
+            if not keyword[0] in options {
+                error(str(print) + " has no parameter " + keyword[0])
+            }
+            value = evaluate(keyword[1])
+            if type(value) != "str" {
+                error("print() " + keyword[0] + " must be a str, not " + type(value))
+            }
+            options[keyword[0]] = value
+        }
+        strs = []
+        for arg in args {
+            append(strs, str(arg))
+        }
+        write(join(strs, options.sep) + options.end)
+    }
+
+    // Call the sort() builtin with its reverse keyword argument
+    func sort_keywords(args, keywords) {
+        for keyword in keywords {
+            if keyword[0] != "reverse" {
+                error(str(sort) + " has no parameter " + keyword[0])
+            }
+        }
+        if len(args) < 1 or len(args) > 3 {
+            return sort(args...)
+        }
+        if len(args) == 3 {
+            error("sort() got multiple values for reverse")
+        }
+        value = evaluate(keywords[0][1])
+        if type(value) != "bool" {
+            error("sort() reverse must be a bool, not " + type(value))
+        }
+        if len(args) == 1 {
+            append(args, nil)
+        }
+        append(args, value)
+        return sort(args...)
+    }
+
+    // Return the list of arguments with keyword values at the positions of
+    // the parameters they name
+    func bind_keywords(name, params, ellipsis, args, keywords) {
+        variadic = nil
+        if ellipsis {
+            variadic = params[len(params)-1]
+            params = slice(params, 0, len(params)-1)
+        }
+        bound = []
+        given = {}
+        for i, param in params {
+            if i < len(args) {
+                append(bound, args[i])
+                given[param] = true
+            } else {
+                append(bound, nil)
+            }
+        }
+        for keyword in keywords {
+            index = find(params, keyword[0])
+            if index < 0 and keyword[0] == variadic {
+                error(name + "() variadic parameter " + keyword[0] + " can't be passed by keyword")
+            }
+            if index < 0 {
+                error(name + "() has no parameter " + keyword[0])
+            }
+            if keyword[0] in given {
+                error(name + "() got multiple values for " + keyword[0])
+            }
+            bound[index] = keyword[1]
+            given[keyword[0]] = true
+        }
+        for param in params {
+            if not param in given {
+                error(name + "() missing argument " + param)
+            }
+        }
+        return bound
+    }
+
+    // Return the constructor for a new struct type. Records are maps of
+    // their fields plus a " struct" key (which can't be a field name)
+    // giving their struct's name, fields, and methods.
+    func struct_type(name, fields, pos) {
+        info = {"name": name, "fields": fields, "methods": {}}
+        f = func(args...) {
+            if len(args) == 1 and args[0] == info_marker {
+                return {"name": name, "params": slice(fields, 0, len(fields)), "variadic": false, "builtin": false}
+            }
+            if len(args) == 3 and args[0] == keywords_marker {
+                args = bind_keywords(name, fields, false, args[1], args[2])
+            }
+            if len(args) != len(fields) {
+                plural = "s"
+                if len(fields) == 1 {
+                    plural = ""
+                }
+                error(name + "(" + join(fields, ", ") + ") requires " + str(len(fields)) + " arg" + plural +
+                    ", got " + str(len(args)) + " (defined at " + str(pos.line) + ":" + str(pos.col) + ")")
+            }
+            record = {" struct": info}
+            for i, field in fields {
+                record[field] = args[i]
+            }
+            return record
+        }
+        append(interp.structs, [f, info])
+        return f
+    }
+
+    func is_record(value) {
+        return type(value) == "map" and " struct" in value
+    }
+
+    // Return true if name is a method of value's type (a map's keys take
+    // precedence over its methods)
+    func has_method(value, name) {
+        if is_record(value) or type(name) != "str" {
+            return false
+        }
+        if type(value) == "map" and name in value {
+            return false
+        }
+        return type(value) in methods and name in methods[type(value)]
+    }
+
+    func subscript_(container, subscript, optional) {
+        if is_record(container) {
+            return record_get(container, subscript, optional)
+        }
+        if optional {
+            return container?[subscript]
+        }
+        return container[subscript]
+    }
+
+    // Return the record's field or bound method with the given name
+    func record_get(record, name, optional) {
+        info = record[" struct"]
+        if name in info.fields {
+            return record[name]
+        }
+        if name in info.methods {
+            method = info.methods[name]
+            return func(args...) {
+                if len(args) == 1 and args[0] == info_marker {
+                    // The receiver is already bound, so it's not a parameter
+                    method_info = method(info_marker)
+                    method_info.params = slice(method_info.params, 1, len(method_info.params))
+                    return method_info
+                }
+                if len(args) == 3 and args[0] == keywords_marker {
+                    return method(keywords_marker, [record] + args[1], args[2])
+                }
+                return method(record, args...)
+            }
+        }
+        if optional {
+            return nil
+        }
+        error(info.name + " has no field or method \"" + name + "\"")
+    }
+
+    // Unlike the Go interpreter, this runs a generator's body as soon as
+    // it's called, returning a list of the values it yields
+    func user_function(name, params, ellipsis, body, closure, generator) {
         f = func(args...) {
+            if len(args) == 1 and args[0] == info_marker {
+                info_name = name
+                if name == "" {
+                    info_name = nil
+                }
+                return {"name": info_name, "params": slice(params, 0, len(params)), "variadic": ellipsis, "builtin": false}
+            }
+            if len(args) == 3 and args[0] == keywords_marker {
+                args = bind_keywords(name, params, ellipsis, args[1], args[2])
+            }
             if ellipsis {
                 ellipsis_args = slice(args, len(params)-1, len(args))
                 new_args = slice(args, 0, len(params)-1)
@@ -991,6 +1723,16 @@ func execute(program) {
             for i in range(len(args)) {
                 assign(params[i], args[i])
             }
+            if generator {
+                yielded = interp.yielded
+                interp.yielded = []
+                execute_block(body)
+                values = interp.yielded
+                interp.yielded = yielded
+                pop_scope()
+                pop_scope()
+                return values
+            }
             r = execute_block(body)
             pop_scope()
             pop_scope()
@@ -1013,17 +1755,47 @@ func execute(program) {
         } else if e.type == "Unary" {
             return unary_funcs[e.operator](evaluate(e.operand))
         } else if e.type == "Call" {
-            function = evaluate(e.function)
             args = []
+            if e.function.type == "Subscript" {
+                container = evaluate(e.function.container)
+                subscript = evaluate(e.function.subscript)
+                if has_method(container, subscript) {
+                    function = builtins[subscript]
+                    append(args, container)
+                } else if type(subscript) == "str" and not type(container) in ["nil", "map"] {
+                    error(type(container) + " has no method " + subscript)
+                } else {
+                    function = subscript_(container, subscript, e.function.optional)
+                }
+            } else {
+                function = evaluate(e.function)
+            }
             for a in e.args {
-                append(args, evaluate(a))
+                if a.type == "Spread" {
+                    for x in evaluate(a.value) {
+                        append(args, x)
+                    }
+                } else {
+                    append(args, evaluate(a))
+                }
             }
-            if e.ellipsis {
-                ellipsis_arg = args[len(args)-1]
-                args = slice(args, 0, len(args)-1)
-                for a in ellipsis_arg {
-                    append(args, a)
+            if len(e.keywords) > 0 {
+                if function == print {
+                    return print_keywords(args, e.keywords)
                 }
+                if function == sort {
+                    return sort_keywords(args, e.keywords)
+                }
+                for name in builtins {
+                    if builtins[name] == function {
+                        error(str(function) + " doesn't accept keyword arguments")
+                    }
+                }
+                keywords = []
+                for keyword in e.keywords {
+                    append(keywords, [keyword[0], evaluate(keyword[1])])
+                }
+                return function(keywords_marker, args, keywords)
             }
             return function(args...)
         } else if e.type == "Literal" {
@@ -1037,23 +1809,92 @@ func execute(program) {
         } else if e.type == "List" {
             values = []
             for v in e.values {
-                append(values, evaluate(v))
+                if v.type == "Spread" {
+                    for x in evaluate(v.value) {
+                        append(values, x)
+                    }
+                } else {
+                    append(values, evaluate(v))
+                }
             }
             return values
         } else if e.type == "Map" {
             value = {}
             for item in e.items {
-                value[evaluate(item[0])] = evaluate(item[1])
+                if item[0] == nil {
+                    m = evaluate(item[1].value)
+                    if type(m) != "map" {
+                        error("can only use ... with a map in a map literal")
+                    }
+                    for k, v in m {
+                        value[k] = v
+                    }
+                } else {
+                    value[evaluate(item[0])] = evaluate(item[1])
+                }
             }
             return value
         } else if e.type == "Subscript" {
+            return subscript_(evaluate(e.container), evaluate(e.subscript), e.optional)
+        } else if e.type == "Slice" {
             container = evaluate(e.container)
-            subscript = evaluate(e.subscript)
-            return container[subscript]
+            start = nil
+            if e.start != nil {
+                start = evaluate(e.start)
+            }
+            end = nil
+            if e.end != nil {
+                end = evaluate(e.end)
+            }
+            return container[start:end]
         } else {
             // FunctionExpression
             closure = interp.vars[len(interp.vars)-1]
-            return user_function("", e.params, e.ellipsis, e.body, closure)
+            return user_function("", e.params, e.ellipsis, e.body, closure, e.generator)
+        }
+    }
+
+    // Return true if value matches the match statement pattern, adding the
+    // names it binds to bindings
+    func match_pattern(pattern, value, bindings) {
+        if pattern.type == "Variable" {
+            bindings[pattern.name] = value
+            return true
+        } else if pattern.type == "Literal" {
+            return pattern.value == value
+        } else if pattern.type == "List" {
+            if type(value) != "list" {
+                return false
+            }
+            patterns = pattern.values
+            rest = nil
+            if len(patterns) > 0 and patterns[len(patterns)-1].type == "Spread" {
+                rest = patterns[len(patterns)-1].value
+                patterns = slice(patterns, 0, len(patterns)-1)
+            }
+            if len(value) < len(patterns) or (rest == nil and len(value) != len(patterns)) {
+                return false
+            }
+            for i, elem in patterns {
+                if not match_pattern(elem, value[i], bindings) {
+                    return false
+                }
+            }
+            if rest != nil {
+                bindings[rest.name] = slice(value, len(patterns), len(value))
+            }
+            return true
+        } else {
+            // Map
+            if type(value) != "map" {
+                return false
+            }
+            for item in pattern.items {
+                if not item[0].value in value or not match_pattern(item[1], value[item[0].value], bindings) {
+                    return false
+                }
+            }
+            return true
         }
     }
 
@@ -1064,8 +1905,21 @@ func execute(program) {
             } else {
                 container = evaluate(s.target.container)
                 subscript = evaluate(s.target.subscript)
+                if is_record(container) and not subscript in container[" struct"].fields {
+                    error(container[" struct"].name + " has no field \"" + subscript + "\"")
+                }
                 container[subscript] = evaluate(s.value)
             }
+        } else if s.type == "OuterAssign" {
+            value = evaluate(s.value)
+            i = len(interp.vars) - 2
+            while i >= 0 and not s.name in interp.vars[i] {
+                i = i - 1
+            }
+            if i < 0 {
+                error("name \"" + s.name + "\" not found in an outer scope")
+            }
+            interp.vars[i][s.name] = value
         } else if s.type == "If" {
             cond = evaluate(s.condition)
             if cond {
@@ -1087,18 +1941,87 @@ func execute(program) {
                 }
             }
         } else if s.type == "For" {
-            for elem in evaluate(s.iterable) {
-                assign(s.name, elem)
+            iterable = evaluate(s.iterable)
+            if s.value_name != nil {
+                for k, v in iterable {
+                    assign(s.name, k)
+                    assign(s.value_name, v)
+                    r = execute_block(s.body)
+                    if r != nil {
+                        return r
+                    }
+                }
+            } else {
+                for elem in iterable {
+                    assign(s.name, elem)
+                    r = execute_block(s.body)
+                    if r != nil {
+                        return r
+                    }
+                }
+            }
+        } else if s.type == "Try" {
+            // Errors are raised by the host interpreter, so catch them
+            // there, and pop any scopes left by functions that didn't return
+            depth = len(interp.vars)
+            yielded = interp.yielded
+            try {
                 r = execute_block(s.body)
-                if r != nil {
-                    return r
+            } catch err {
+                interp.vars = slice(interp.vars, 0, depth)
+                interp.yielded = yielded
+                assign(s.error_name, err)
+                r = execute_block(s.catch_body)
+            }
+            if r != nil {
+                return r
+            }
+        } else if s.type == "Match" {
+            value = evaluate(s.value)
+            matched = false
+            for c in s.cases {
+                bindings = {}
+                if not matched and match_pattern(c[0], value, bindings) {
+                    matched = true
+                    for name, v in bindings {
+                        assign(name, v)
+                    }
+                    r = execute_block(c[1])
+                    if r != nil {
+                        return r
+                    }
                 }
             }
         } else if s.type == "ExpressionStatement" {
             evaluate(s.expr)
         } else if s.type == "FunctionDefinition" {
             closure = interp.vars[len(interp.vars)-1]
-            assign(s.name, user_function(s.name, s.params, s.ellipsis, s.body, closure))
+            if s.struct_name != nil {
+                value_found = lookup(s.struct_name)
+                if not value_found[1] {
+                    error("name \"" + s.struct_name + "\" not found")
+                }
+                info = nil
+                for pair in interp.structs {
+                    if pair[0] == value_found[0] {
+                        info = pair[1]
+                    }
+                }
+                if info == nil {
+                    error(s.struct_name + " is not a struct")
+                }
+                if s.name in info.fields {
+                    error("struct " + s.struct_name + " already has a field " + s.name)
+                }
+                name = s.struct_name + "." + s.name
+                info.methods[s.name] = user_function(name, s.params, s.ellipsis, s.body, closure, s.generator)
+            } else {
+                assign(s.name, user_function(s.name, s.params, s.ellipsis, s.body, closure, s.generator))
+            }
+        } else if s.type == "StructDefinition" {
+            assign(s.name, struct_type(s.name, s.fields, s.pos))
+        } else if s.type == "Yield" {
+            append(interp.yielded, evaluate(s.value))
         } else {
             // Return
             return [evaluate(s.result)]
@@ -1114,6 +2037,86 @@ func execute(program) {
         }
     }
 
+    // The host's include() would run the file in the host interpreter, so
+    // parse and run it here, in the global scope
+    func include(args...) {
+        if len(args) != 1 {
+            error("include() requires 1 arg, got " + str(len(args)))
+        }
+        if type(args[0]) != "str" {
+            error("include() argument 1 must be a str, not " + type(args[0]))
+        }
+        included = parse(read(args[0]))
+        vars = interp.vars
+        interp.vars = [vars[0]]
+        r = execute_block(included.body)
+        interp.vars = vars
+        if r != nil {
+            error("can't return at top level")
+        }
+    }
+
+    // Return a copy of scope, leaving out the builtin functions (unless
+    // the program has assigned something else to the name)
+    func scope_map(scope) {
+        m = {}
+        for name in scope {
+            if not (name in builtins and builtins[name] == scope[name]) {
+                m[name] = scope[name]
+            }
+        }
+        return m
+    }
+
+    // Like include(), the host's globals() and locals() would use the host
+    // interpreter's scopes
+    func globals(args...) {
+        if len(args) != 0 {
+            error("globals() requires 0 args, got " + str(len(args)))
+        }
+        return scope_map(interp.vars[0])
+    }
+
+    func locals(args...) {
+        if len(args) != 0 {
+            error("locals() requires 0 args, got " + str(len(args)))
+        }
+        return scope_map(interp.vars[len(interp.vars)-1])
+    }
+
+    func funcinfo(args...) {
+        if len(args) != 1 {
+            error("funcinfo() requires 1 arg, got " + str(len(args)))
+        }
+        f = args[0]
+        if type(f) != "func" {
+            error("funcinfo() argument 1 must be a func, not " + type(f))
+        }
+        for name in builtins {
+            if builtins[name] == f {
+                return {"name": name, "params": nil, "variadic": nil, "builtin": true}
+            }
+        }
+        return f(info_marker)
+    }
+
+    // Spawned functions would share this interpreter's scope stack, so
+    // they can't be supported here
+    func spawn(args...) {
+        if len(args) < 1 {
+            error("spawn() requires at least 1 arg, got " + str(len(args)))
+        }
+        if type(args[0]) != "func" {
+            error("spawn() argument 1 must be a func, not " + type(args[0]))
+        }
+        error("spawn() isn't supported by littlelang.ll")
+    }
+
+    builtins["funcinfo"] = funcinfo
+    builtins["globals"] = globals
+    builtins["include"] = include
+    builtins["locals"] = locals
+    builtins["spawn"] = spawn
     push_scope({})
     for name in builtins {
         assign(name, builtins[name])
@@ -0,0 +1,171 @@
+// Tests for the littlelang command
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/benhoyt/littlelang/parser"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		code     bool
+		sources  string
+		progArgs string
+	}{
+		{"", false, "", ""},
+		{"prog.ll", false, "prog.ll", ""},
+		{"prog.ll x y", false, "prog.ll", "x y"},
+		{"prog.ll x -- y", false, "prog.ll", "x -- y"},
+		{"prog.ll -- y", false, "prog.ll", "y"},
+		{"a.ll b.ll main.ll -- x y", false, "a.ll b.ll main.ll", "x y"},
+		{"std/strings main.ll --", false, "std/strings main.ll", ""},
+		{"littlelang.ll examples/readme.ll", false, "littlelang.ll", "examples/readme.ll"},
+		{"script -- x", false, "script", "-- x"},
+		{"-- x", false, "--", "x"},
+		{"x y", true, "", "x y"},
+		{"x -- y", true, "", "x -- y"},
+		{"a.ll -- y", true, "a.ll", "y"},
+		{"-- y", true, "", "-- y"},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			sources, progArgs := splitArgs(strings.Fields(test.args), test.code)
+			if strings.Join(sources, " ") != test.sources {
+				t.Fatalf("expected sources %q, got %q", test.sources, sources)
+			}
+			if strings.Join(progArgs, " ") != test.progArgs {
+				t.Fatalf("expected args %q, got %q", test.progArgs, progArgs)
+			}
+		})
+	}
+}
+
+func TestDumpASTJSON(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte("x = 1  // one\n"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var buf bytes.Buffer
+	err = dumpAST(&buf, prog, "json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var obj map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, " ") != "Node Statements" {
+		t.Fatalf("expected Program keys Node and Statements, got %q", keys)
+	}
+}
+
+func TestWatchArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected string
+	}{
+		{"-watch f.ll", "-- f.ll"},
+		{"-timeout 5s -watch f.ll x", "-timeout=5s -- f.ll x"},
+		{"-watch -timeout 5s f.ll -- -x", "-timeout=5s -- f.ll -- -x"},
+		{"-lib a.ll -watch=true -lib b.ll -stats f.ll", "-lib=a.ll -lib=b.ll -stats=true -- f.ll"},
+		{"-watch -- -f.ll", "-- -f.ll"},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			flags := flag.NewFlagSet("littlelang", flag.ContinueOnError)
+			flags.Bool("watch", false, "")
+			flags.Bool("stats", false, "")
+			flags.Duration("timeout", 0, "")
+			var libs stringList
+			flags.Var(&libs, "lib", "")
+			err := flags.Parse(strings.Fields(test.args))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			args := strings.Join(watchArgs(flags), " ")
+			if args != test.expected {
+				t.Fatalf("expected args %q, got %q", test.expected, args)
+			}
+		})
+	}
+}
+
+func TestIsCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littlelang")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = ioutil.WriteFile("doc", []byte("print(args())\n"), 0644)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	tests := []struct {
+		args     string
+		name     string
+		minArgs  int
+		expected bool
+	}{
+		{"doc lib.ll", "doc", 1, false}, // a file named "doc" exists
+		{"doc", "doc", 1, false},
+		{"prog.ll doc", "doc", 1, false},
+		{"test data.txt", "test", 1, true},
+		{"selftest", "selftest", 0, true},
+		{"fmt", "fmt", 1, false},
+		{"vet lib.ll", "vet", 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			isCmd := isCommand(strings.Fields(test.args), test.name, test.minArgs)
+			if isCmd != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, isCmd)
+			}
+		})
+	}
+}
+
+func TestWriteDiffHeader(t *testing.T) {
+	tests := []struct {
+		filename string
+		header   string
+	}{
+		{"x.ll", "--- a/x.ll\n+++ b/x.ll\n"},
+		{"./dir//x.ll", "--- a/dir/x.ll\n+++ b/dir/x.ll\n"},
+		{"/tmp/x.ll", "--- /tmp/x.ll\n+++ /tmp/x.ll\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeDiff(&buf, test.filename, []byte("x = 1\n"), []byte("x = 2\n"))
+			if !strings.HasPrefix(buf.String(), test.header) {
+				t.Fatalf("expected header %q, got %q", test.header, buf.String())
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	. "github.com/benhoyt/littlelang/tokenizer"
@@ -11,6 +12,29 @@ import (
 
 type Program struct {
 	Statements Block
+
+	// Comments has all the comments in the source, in order, and Attached
+	// has the comments attached to each statement (statements without any
+	// aren't in the map). They're only set by ParseProgramComments.
+	Comments []Comment
+	Attached map[Statement]*StatementComments
+}
+
+// Comment is a "//" comment in the source
+type Comment struct {
+	Position Position
+	Text     string // including the "//"
+}
+
+// StatementComments holds the comments attached to a statement. Leading has
+// the comments on their own lines between the previous statement (or the
+// start of the block) and this one, and Trailing is the comment at the end
+// of the statement's last line, or nil if there isn't one. Other comments,
+// such as those inside expressions or at the end of a block, are only in
+// Program.Comments.
+type StatementComments struct {
+	Leading  []Comment
+	Trailing *Comment
 }
 
 func (p *Program) String() string {
@@ -99,17 +123,64 @@ func (s *While) String() string {
 }
 
 type For struct {
-	pos      Position
-	Name     string
-	Iterable Expression
-	Body     Block
+	pos       Position
+	Name      string
+	ValueName string // second name in "for k, v in m", or "" if not present
+	Iterable  Expression
+	Body      Block
 }
 
 func (s *For) statementNode()     {}
 func (s *For) Position() Position { return s.pos }
 
 func (s *For) String() string {
-	return fmt.Sprintf("for %s in %s {\n%s\n}", s.Name, s.Iterable, indent(s.Body.String()))
+	names := s.Name
+	if s.ValueName != "" {
+		names += ", " + s.ValueName
+	}
+	return fmt.Sprintf("for %s in %s {\n%s\n}", names, s.Iterable, indent(s.Body.String()))
+}
+
+type Try struct {
+	pos       Position
+	Body      Block
+	ErrorName string // name the caught error is assigned to
+	Catch     Block
+}
+
+func (s *Try) statementNode()     {}
+func (s *Try) Position() Position { return s.pos }
+
+func (s *Try) String() string {
+	return fmt.Sprintf("try {\n%s\n} catch %s {\n%s\n}", indent(s.Body.String()), s.ErrorName, indent(s.Catch.String()))
+}
+
+// Match is a match statement, which runs the body of the first case whose
+// pattern matches Value
+type Match struct {
+	pos   Position
+	Value Expression
+	Cases []MatchCase
+}
+
+// MatchCase is a single case of a match statement. Pattern is a *Variable
+// (which matches any value), a *Literal, a *List of patterns (the last of
+// which may be a *Spread of a *Variable), or a *Map with str *Literal keys
+// and pattern values.
+type MatchCase struct {
+	Pattern Expression
+	Body    Block
+}
+
+func (s *Match) statementNode()     {}
+func (s *Match) Position() Position { return s.pos }
+
+func (s *Match) String() string {
+	cases := []string{}
+	for _, c := range s.Cases {
+		cases = append(cases, indent(fmt.Sprintf("%s {\n%s\n}", c.Pattern, indent(c.Body.String()))))
+	}
+	return fmt.Sprintf("match %s {\n%s\n}", s.Value, strings.Join(cases, "\n"))
 }
 
 type Return struct {
@@ -124,6 +195,18 @@ func (s *Return) String() string {
 	return fmt.Sprintf("return %s", s.Result)
 }
 
+type Yield struct {
+	pos   Position
+	Value Expression
+}
+
+func (s *Yield) statementNode()     {}
+func (s *Yield) Position() Position { return s.pos }
+
+func (s *Yield) String() string {
+	return fmt.Sprintf("yield %s", s.Value)
+}
+
 type ExpressionStatement struct {
 	pos        Position
 	Expression Expression
@@ -138,10 +221,12 @@ func (s *ExpressionStatement) String() string {
 
 type FunctionDefinition struct {
 	pos        Position
+	Struct     string // name of the struct for a method, otherwise ""
 	Name       string
 	Parameters []string
 	Ellipsis   bool
 	Body       Block
+	Generator  bool // true if Body contains a yield statement
 }
 
 func (s *FunctionDefinition) statementNode()     {}
@@ -156,8 +241,25 @@ func (s *FunctionDefinition) String() string {
 	if len(s.Body) != 0 {
 		bodyStr = "\n" + indent(s.Body.String()) + "\n"
 	}
+	name := s.Name
+	if s.Struct != "" {
+		name = s.Struct + "." + s.Name
+	}
 	return fmt.Sprintf("func %s(%s%s) {%s}",
-		s.Name, strings.Join(s.Parameters, ", "), ellipsisStr, bodyStr)
+		name, strings.Join(s.Parameters, ", "), ellipsisStr, bodyStr)
+}
+
+type StructDefinition struct {
+	pos    Position
+	Name   string
+	Fields []string
+}
+
+func (s *StructDefinition) statementNode()     {}
+func (s *StructDefinition) Position() Position { return s.pos }
+
+func (s *StructDefinition) String() string {
+	return fmt.Sprintf("struct %s { %s }", s.Name, strings.Join(s.Fields, " "))
 }
 
 type Expression interface {
@@ -199,8 +301,13 @@ func (e *Unary) String() string {
 type Call struct {
 	pos       Position
 	Function  Expression
-	Arguments []Expression
-	Ellipsis  bool
+	Arguments []Expression      // may include *Spread values
+	Keywords  []KeywordArgument // name=value arguments after the positional ones
+}
+
+type KeywordArgument struct {
+	Name  string
+	Value Expression
 }
 
 func (e *Call) expressionNode()    {}
@@ -211,11 +318,10 @@ func (e *Call) String() string {
 	for _, arg := range e.Arguments {
 		args = append(args, fmt.Sprintf("%s", arg))
 	}
-	ellipsisStr := ""
-	if e.Ellipsis {
-		ellipsisStr = "..."
+	for _, keyword := range e.Keywords {
+		args = append(args, fmt.Sprintf("%s=%s", keyword.Name, keyword.Value))
 	}
-	return fmt.Sprintf("%s(%s%s)", e.Function, strings.Join(args, ", "), ellipsisStr)
+	return fmt.Sprintf("%s(%s)", e.Function, strings.Join(args, ", "))
 }
 
 type Literal struct {
@@ -233,12 +339,20 @@ func (e *Literal) String() string {
 	if s, ok := e.Value.(string); ok {
 		return fmt.Sprintf("%q", s)
 	}
+	if f, ok := e.Value.(float64); ok {
+		// Ensure a float like 1.0 doesn't print as an int
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	}
 	return fmt.Sprintf("%v", e.Value)
 }
 
 type List struct {
 	pos    Position
-	Values []Expression
+	Values []Expression // may include *Spread values
 }
 
 func (e *List) expressionNode()    {}
@@ -252,6 +366,22 @@ func (e *List) String() string {
 	return fmt.Sprintf("[%s]", strings.Join(values, ", "))
 }
 
+// Spread is an argument like the xs... in f(1, xs..., 2), an element of a
+// list literal like the xs... in [1, xs..., 2], or an item of a map literal
+// like the m... in {m..., "a": 1}, which is expanded in place
+type Spread struct {
+	Value Expression
+}
+
+func (e *Spread) expressionNode()    {}
+func (e *Spread) Position() Position { return e.Value.Position() }
+
+func (e *Spread) String() string {
+	return fmt.Sprintf("%s...", e.Value)
+}
+
+// MapItem is a key: value item in a map literal. For a spread item, Key is
+// nil and Value is a *Spread.
 type MapItem struct {
 	Key   Expression
 	Value Expression
@@ -268,6 +398,10 @@ func (e *Map) Position() Position { return e.pos }
 func (e *Map) String() string {
 	items := []string{}
 	for _, item := range e.Items {
+		if item.Key == nil {
+			items = append(items, fmt.Sprintf("%s", item.Value))
+			continue
+		}
 		items = append(items, fmt.Sprintf("%s: %s", item.Key, item.Value))
 	}
 	return fmt.Sprintf("{%s}", strings.Join(items, ", "))
@@ -278,6 +412,7 @@ type FunctionExpression struct {
 	Parameters []string
 	Ellipsis   bool
 	Body       Block
+	Generator  bool // true if Body contains a yield statement
 }
 
 func (e *FunctionExpression) expressionNode()    {}
@@ -299,15 +434,40 @@ type Subscript struct {
 	pos       Position
 	Container Expression
 	Subscript Expression
+	Optional  bool // ?[ or ?. form, which gives nil if the key is missing
 }
 
 func (e *Subscript) expressionNode()    {}
 func (e *Subscript) Position() Position { return e.pos }
 
 func (e *Subscript) String() string {
+	if e.Optional {
+		return fmt.Sprintf("%s?[%s]", e.Container, e.Subscript)
+	}
 	return fmt.Sprintf("%s[%s]", e.Container, e.Subscript)
 }
 
+type Slice struct {
+	pos       Position
+	Container Expression
+	Start     Expression // nil if omitted
+	End       Expression // nil if omitted
+}
+
+func (e *Slice) expressionNode()    {}
+func (e *Slice) Position() Position { return e.pos }
+
+func (e *Slice) String() string {
+	start, end := "", ""
+	if e.Start != nil {
+		start = fmt.Sprint(e.Start)
+	}
+	if e.End != nil {
+		end = fmt.Sprint(e.End)
+	}
+	return fmt.Sprintf("%s[%s:%s]", e.Container, start, end)
+}
+
 type Variable struct {
 	pos  Position
 	Name string
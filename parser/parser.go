@@ -1,13 +1,16 @@
 // Package parser turns littlelang source code into an abstract syntax tree.
 //
 // You can parse a single expression with ParseExpression(), or an entire
-// program with ParseProgram().
+// program with ParseProgram(). Use ParseFile() to record the name of the
+// source file in positions, or ParseProgramComments() to also get the
+// program's comments, attached to the statements they belong to.
 //
 package parser
 
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	. "github.com/benhoyt/littlelang/tokenizer"
 )
@@ -21,7 +24,7 @@ type Error struct {
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("parse error at %d:%d: %s", e.Position.Line, e.Position.Column, e.Message)
+	return fmt.Sprintf("parse error at %s: %s", e.Position, e.Message)
 }
 
 type parser struct {
@@ -29,10 +32,27 @@ type parser struct {
 	pos       Position
 	tok       Token
 	val       string
+
+	inFunction bool // parsing a function body, so yield is allowed
+	yielded    bool // current function body has a yield statement
+
+	// Only used when parsing comments (attached is nil otherwise)
+	attached map[Statement]*StatementComments
+	comments []Comment // all comments so far
+	gap      int       // index in comments of first one after previous token
+	prevLine int       // line the previous token ends on
 }
 
 func (p *parser) next() {
+	if p.attached != nil {
+		p.prevLine = p.pos.Line + strings.Count(p.tokenizer.Lexeme(), "\n")
+		p.gap = len(p.comments)
+	}
 	p.pos, p.tok, p.val = p.tokenizer.Next()
+	for p.tok == COMMENT {
+		p.comments = append(p.comments, Comment{p.pos, "//" + p.val})
+		p.pos, p.tok, p.val = p.tokenizer.Next()
+	}
 	if p.tok == ILLEGAL {
 		p.error("%s", p.val)
 	}
@@ -62,18 +82,48 @@ func (p *parser) matches(operators ...Token) bool {
 // program = statement*
 func (p *parser) program() *Program {
 	statements := p.statements(EOF)
-	return &Program{statements}
+	return &Program{Statements: statements}
 }
 
 func (p *parser) statements(end Token) Block {
 	statements := Block{}
 	for p.tok != end && p.tok != EOF {
-		statements = append(statements, p.statement())
+		leading := p.leadingComments()
+		s := p.statement()
+		p.attachComments(s, leading)
+		statements = append(statements, s)
 	}
 	return statements
 }
 
-// statement = if | while | for | return | func | assign | expression
+// Return the comments between the previous token and the current one,
+// except for one on the same line as the previous token
+func (p *parser) leadingComments() []Comment {
+	gap := p.comments[p.gap:]
+	if len(gap) > 0 && gap[0].Position.Line == p.prevLine {
+		gap = gap[1:]
+	}
+	return append([]Comment(nil), gap...)
+}
+
+// Attach the given leading comments, and the comment at the end of the
+// statement's last line (if any), to statement s
+func (p *parser) attachComments(s Statement, leading []Comment) {
+	if p.attached == nil {
+		return
+	}
+	comments := &StatementComments{Leading: leading}
+	gap := p.comments[p.gap:]
+	if len(gap) > 0 && gap[0].Position.Line == p.prevLine {
+		trailing := gap[0]
+		comments.Trailing = &trailing
+	}
+	if len(comments.Leading) > 0 || comments.Trailing != nil {
+		p.attached[s] = comments
+	}
+}
+
+// statement = if | while | for | try | match | return | yield | func | struct | outer | assign | expression
 // assign    = NAME ASSIGN expression |
 //             call subscript ASSIGN expression |
 //             call dot ASSIGN expression
@@ -85,15 +135,28 @@ func (p *parser) statement() Statement {
 		return p.while()
 	case FOR:
 		return p.for_()
+	case TRY:
+		return p.try()
+	case MATCH:
+		return p.match()
 	case RETURN:
 		return p.return_()
+	case YIELD:
+		return p.yield()
 	case FUNC:
 		return p.func_()
+	case STRUCT:
+		return p.struct_()
+	case OUTER:
+		return p.outer()
 	}
 	pos := p.pos
 	expr := p.expression()
 	if p.tok == ASSIGN {
 		pos = p.pos
+		if s, ok := expr.(*Subscript); ok && s.Optional {
+			p.error("can't assign to ?[ or ?. expression")
+		}
 		switch expr.(type) {
 		case *Variable, *Subscript:
 			p.next()
@@ -106,6 +169,17 @@ func (p *parser) statement() Statement {
 	return &ExpressionStatement{pos, expr}
 }
 
+// outer = OUTER NAME ASSIGN expression
+func (p *parser) outer() Statement {
+	pos := p.pos
+	p.expect(OUTER)
+	name := p.val
+	p.expect(NAME)
+	p.expect(ASSIGN)
+	value := p.expression()
+	return &OuterAssign{pos, name, value}
+}
+
 // block = LBRACE statement* RBRACE
 func (p *parser) block() Block {
 	p.expect(LBRACE)
@@ -145,16 +219,144 @@ func (p *parser) while() Statement {
 	return &While{pos, condition, body}
 }
 
-// for = FOR NAME IN expression block
+// for = FOR NAME (COMMA NAME)? IN expression block
 func (p *parser) for_() Statement {
 	pos := p.pos
 	p.expect(FOR)
 	name := p.val
 	p.expect(NAME)
+	valueName := ""
+	if p.tok == COMMA {
+		p.next()
+		valueName = p.val
+		p.expect(NAME)
+	}
 	p.expect(IN)
 	iterable := p.expression()
 	body := p.block()
-	return &For{pos, name, iterable, body}
+	return &For{pos, name, valueName, iterable, body}
+}
+
+// try = TRY block CATCH NAME block
+func (p *parser) try() Statement {
+	pos := p.pos
+	p.expect(TRY)
+	body := p.block()
+	p.expect(CATCH)
+	name := p.val
+	p.expect(NAME)
+	catch := p.block()
+	return &Try{pos, body, name, catch}
+}
+
+// match = MATCH expression LBRACE (pattern block)* RBRACE
+func (p *parser) match() Statement {
+	pos := p.pos
+	p.expect(MATCH)
+	value := p.expression()
+	p.expect(LBRACE)
+	cases := []MatchCase{}
+	for p.tok != RBRACE && p.tok != EOF {
+		pattern := p.pattern(make(map[string]bool))
+		body := p.block()
+		cases = append(cases, MatchCase{pattern, body})
+	}
+	p.expect(RBRACE)
+	return &Match{pos, value, cases}
+}
+
+// pattern = NAME | INT | FLOAT | STR | TRUE | FALSE | NIL |
+//           MINUS (INT | FLOAT) |
+//           LBRACKET RBRACKET |
+//           LBRACKET pattern (COMMA pattern)* (COMMA NAME ELLIPSIS)? COMMA? RBRACKET |
+//           LBRACE RBRACE |
+//           LBRACE STR COLON pattern (COMMA STR COLON pattern)* COMMA? RBRACE
+//
+// Names records the names bound so far, as a name can only be bound once
+// in a pattern.
+func (p *parser) pattern(names map[string]bool) Expression {
+	switch p.tok {
+	case NAME:
+		if names[p.val] {
+			p.error("duplicate name %s in pattern", p.val)
+		}
+		names[p.val] = true
+		return p.primary()
+	case INT, FLOAT, STR, TRUE, FALSE, NIL:
+		return p.primary()
+	case MINUS:
+		pos := p.pos
+		p.next()
+		if p.tok != INT && p.tok != FLOAT {
+			p.error("expected number after - in pattern, not %s", p.tok)
+		}
+		switch n := p.primary().(*Literal).Value.(type) {
+		case int:
+			return &Literal{pos, -n}
+		default:
+			return &Literal{pos, -n.(float64)}
+		}
+	case LBRACKET:
+		pos := p.pos
+		p.next()
+		values := []Expression{}
+		gotComma := true
+		gotEllipsis := false
+		for p.tok != RBRACKET && p.tok != EOF && !gotEllipsis {
+			if !gotComma {
+				p.error("expected , between list patterns")
+			}
+			value := p.pattern(names)
+			if p.tok == ELLIPSIS {
+				if _, ok := value.(*Variable); !ok {
+					p.error("can only use ... after a name in a pattern")
+				}
+				value = &Spread{value}
+				gotEllipsis = true
+				p.next()
+			}
+			values = append(values, value)
+			if p.tok == COMMA {
+				gotComma = true
+				p.next()
+			} else {
+				gotComma = false
+			}
+		}
+		if p.tok != RBRACKET && gotEllipsis {
+			p.error("can only have ... after last list pattern")
+		}
+		p.expect(RBRACKET)
+		return &List{pos, values}
+	case LBRACE:
+		pos := p.pos
+		p.next()
+		items := []MapItem{}
+		gotComma := true
+		for p.tok != RBRACE && p.tok != EOF {
+			if !gotComma {
+				p.error("expected , between map patterns")
+			}
+			if p.tok != STR {
+				p.error("map pattern key must be a str, not %s", p.tok)
+			}
+			key := p.primary()
+			p.expect(COLON)
+			value := p.pattern(names)
+			items = append(items, MapItem{key, value})
+			if p.tok == COMMA {
+				gotComma = true
+				p.next()
+			} else {
+				gotComma = false
+			}
+		}
+		p.expect(RBRACE)
+		return &Map{pos, items}
+	default:
+		p.error("expected pattern, not %s", p.tok)
+		return nil
+	}
 }
 
 // return = RETURN expression
@@ -165,25 +367,75 @@ func (p *parser) return_() Statement {
 	return &Return{pos, result}
 }
 
+// yield = YIELD expression
+func (p *parser) yield() Statement {
+	pos := p.pos
+	p.expect(YIELD)
+	if !p.inFunction {
+		p.pos = pos
+		p.error("can't yield outside a function")
+	}
+	p.yielded = true
+	value := p.expression()
+	return &Yield{pos, value}
+}
+
 // func = FUNC NAME params block |
-//        FUNC params block
+//        FUNC NAME DOT NAME params block |
+//        FUNC params body
 func (p *parser) func_() Statement {
 	pos := p.pos
 	p.expect(FUNC)
 	if p.tok == NAME {
+		structName := ""
 		name := p.val
 		p.next()
+		if p.tok == DOT {
+			p.next()
+			structName = name
+			name = p.val
+			p.expect(NAME)
+		}
+		paramsPos := p.pos
 		params, ellipsis := p.params()
-		body := p.block()
-		return &FunctionDefinition{pos, name, params, ellipsis, body}
+		if structName != "" && len(params) == 0 {
+			p.pos = paramsPos
+			p.error("method %s.%s needs a parameter for its receiver", structName, name)
+		}
+		body, generator := p.functionBody(p.block)
+		return &FunctionDefinition{pos, structName, name, params, ellipsis, body, generator}
 	} else {
 		params, ellipsis := p.params()
-		body := p.block()
-		expr := &FunctionExpression{pos, params, ellipsis, body}
+		body, generator := p.functionBody(p.body)
+		expr := &FunctionExpression{pos, params, ellipsis, body, generator}
 		return &ExpressionStatement{pos, expr}
 	}
 }
 
+// Parse a function body using parse, and return it along with whether it
+// has a yield statement (not counting yields in nested functions)
+func (p *parser) functionBody(parse func() Block) (Block, bool) {
+	inFunction, yielded := p.inFunction, p.yielded
+	p.inFunction, p.yielded = true, false
+	body := parse()
+	generator := p.yielded
+	p.inFunction, p.yielded = inFunction, yielded
+	return body, generator
+}
+
+// body = block | COLON expression
+//
+// The second form is a short "lambda" body equivalent to a block that
+// returns the expression, so "func(x): x*2" is "func(x) { return x*2 }".
+func (p *parser) body() Block {
+	if p.tok != COLON {
+		return p.block()
+	}
+	p.next()
+	result := p.expression()
+	return Block{&Return{result.Position(), result}}
+}
+
 // params = LPAREN RPAREN |
 //          LPAREN NAME (COMMA NAME)* ELLIPSIS? COMMA? RPAREN |
 func (p *parser) params() ([]string, bool) {
@@ -216,6 +468,30 @@ func (p *parser) params() ([]string, bool) {
 	return params, gotEllipsis
 }
 
+// struct = STRUCT NAME LBRACE NAME* RBRACE
+func (p *parser) struct_() Statement {
+	pos := p.pos
+	p.expect(STRUCT)
+	name := p.val
+	p.expect(NAME)
+	p.expect(LBRACE)
+	fields := []string{}
+	for p.tok != RBRACE && p.tok != EOF {
+		if p.tok != NAME {
+			p.error("expected field name, not %s", p.tok)
+		}
+		for _, field := range fields {
+			if field == p.val {
+				p.error("duplicate field %s in struct %s", p.val, name)
+			}
+		}
+		fields = append(fields, p.val)
+		p.next()
+	}
+	p.expect(RBRACE)
+	return &StructDefinition{pos, name, fields}
+}
+
 func (p *parser) binary(parseFunc func() Expression, operators ...Token) Expression {
 	expr := parseFunc()
 	for p.matches(operators...) {
@@ -282,27 +558,49 @@ func (p *parser) negative() Expression {
 
 // call      = primary (args | subscript | dot)*
 // args      = LPAREN RPAREN |
-//             LPAREN expression (COMMA expression)* ELLIPSIS? COMMA? RPAREN)
-// subscript = LBRACKET expression RBRACKET
-// dot       = DOT NAME
+//             LPAREN element (COMMA element)* COMMA? RPAREN) |
+//             LPAREN (element COMMA)* keyword (COMMA keyword)* COMMA? RPAREN)
+// keyword   = NAME ASSIGN expression
+// subscript = LBRACKET expression RBRACKET |
+//             LBRACKET expression? COLON expression? RBRACKET |
+//             OPTLBRACKET expression RBRACKET
+// dot       = (DOT | OPTDOT) NAME
 func (p *parser) call() Expression {
 	expr := p.primary()
-	for p.matches(LPAREN, LBRACKET, DOT) {
+	for p.matches(LPAREN, LBRACKET, DOT, OPTLBRACKET, OPTDOT) {
 		if p.tok == LPAREN {
 			pos := p.pos
 			p.next()
 			args := []Expression{}
+			var keywords []KeywordArgument
 			gotComma := true
-			gotEllipsis := false
-			for p.tok != RPAREN && p.tok != EOF && !gotEllipsis {
+			for p.tok != RPAREN && p.tok != EOF {
 				if !gotComma {
 					p.error("expected , between arguments")
 				}
+				argPos := p.pos
 				arg := p.expression()
-				args = append(args, arg)
-				if p.tok == ELLIPSIS {
-					gotEllipsis = true
+				if v, ok := arg.(*Variable); ok && p.tok == ASSIGN {
+					for _, keyword := range keywords {
+						if keyword.Name == v.Name {
+							p.pos = argPos
+							p.error("duplicate keyword argument %s", v.Name)
+						}
+					}
 					p.next()
+					keywords = append(keywords, KeywordArgument{v.Name, p.expression()})
+					if p.tok == ELLIPSIS {
+						p.error("can't use ... with keyword arguments")
+					}
+				} else if keywords != nil {
+					p.pos = argPos
+					p.error("positional argument can't follow keyword arguments")
+				} else {
+					if p.tok == ELLIPSIS {
+						arg = &Spread{arg}
+						p.next()
+					}
+					args = append(args, arg)
 				}
 				if p.tok == COMMA {
 					gotComma = true
@@ -311,30 +609,47 @@ func (p *parser) call() Expression {
 					gotComma = false
 				}
 			}
-			if p.tok != RPAREN && gotEllipsis {
-				p.error("can only have ... after last argument")
-			}
 			p.expect(RPAREN)
-			expr = &Call{pos, expr, args, gotEllipsis}
+			expr = &Call{pos, expr, args, keywords}
 		} else if p.tok == LBRACKET {
+			pos := p.pos
+			p.next()
+			var start Expression
+			if p.tok != COLON {
+				start = p.expression()
+				if p.tok != COLON {
+					p.expect(RBRACKET)
+					expr = &Subscript{pos, expr, start, false}
+					continue
+				}
+			}
+			p.expect(COLON)
+			var end Expression
+			if p.tok != RBRACKET {
+				end = p.expression()
+			}
+			p.expect(RBRACKET)
+			expr = &Slice{pos, expr, start, end}
+		} else if p.tok == OPTLBRACKET {
 			pos := p.pos
 			p.next()
 			subscript := p.expression()
 			p.expect(RBRACKET)
-			expr = &Subscript{pos, expr, subscript}
+			expr = &Subscript{pos, expr, subscript, true}
 		} else {
 			pos := p.pos
+			optional := p.tok == OPTDOT
 			p.next()
 			subscript := &Literal{p.pos, p.val}
 			p.expect(NAME)
-			expr = &Subscript{pos, expr, subscript}
+			expr = &Subscript{pos, expr, subscript, optional}
 		}
 	}
 	return expr
 }
 
-// primary = NAME | INT | STR | TRUE | FALSE | NIL | list | map |
-//           FUNC params block |
+// primary = NAME | INT | FLOAT | STR | TRUE | FALSE | NIL | list | map |
+//           FUNC params body |
 //           LPAREN expression RPAREN
 func (p *parser) primary() Expression {
 	switch p.tok {
@@ -353,6 +668,19 @@ func (p *parser) primary() Expression {
 			panic(fmt.Sprintf("tokenizer gave INT token that isn't an int: %s", val))
 		}
 		return &Literal{pos, n}
+	case FLOAT:
+		val := p.val
+		pos := p.pos
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			if err.(*strconv.NumError).Err == strconv.ErrRange {
+				p.error("float literal %s out of range", val)
+			}
+			// Tokenizer should never give us this
+			panic(fmt.Sprintf("tokenizer gave FLOAT token that isn't a float: %s", val))
+		}
+		p.next()
+		return &Literal{pos, f}
 	case STR:
 		val := p.val
 		pos := p.pos
@@ -378,8 +706,8 @@ func (p *parser) primary() Expression {
 		pos := p.pos
 		p.next()
 		args, ellipsis := p.params()
-		body := p.block()
-		return &FunctionExpression{pos, args, ellipsis, body}
+		body, generator := p.functionBody(p.body)
+		return &FunctionExpression{pos, args, ellipsis, body, generator}
 	case LPAREN:
 		p.next()
 		expr := p.expression()
@@ -391,8 +719,9 @@ func (p *parser) primary() Expression {
 	}
 }
 
-// list = LBRACKET RBRACKET |
-//        LBRACKET expression (COMMA expression)* COMMA? RBRACKET
+// list    = LBRACKET RBRACKET |
+//           LBRACKET element (COMMA element)* COMMA? RBRACKET
+// element = expression ELLIPSIS?
 func (p *parser) list() Expression {
 	pos := p.pos
 	p.expect(LBRACKET)
@@ -403,6 +732,10 @@ func (p *parser) list() Expression {
 			p.error("expected , between list elements")
 		}
 		value := p.expression()
+		if p.tok == ELLIPSIS {
+			value = &Spread{value}
+			p.next()
+		}
 		values = append(values, value)
 		if p.tok == COMMA {
 			gotComma = true
@@ -415,9 +748,9 @@ func (p *parser) list() Expression {
 	return &List{pos, values}
 }
 
-// map = LBRACE RBRACE |
-//       LBRACE expression COLON expression
-//              (COMMA expression COLON expression)* COMMA? RBRACE
+// map  = LBRACE RBRACE |
+//        LBRACE item (COMMA item)* COMMA? RBRACE
+// item = expression COLON expression | expression ELLIPSIS
 func (p *parser) map_() Expression {
 	pos := p.pos
 	p.expect(LBRACE)
@@ -428,9 +761,14 @@ func (p *parser) map_() Expression {
 			p.error("expected , between map items")
 		}
 		key := p.expression()
-		p.expect(COLON)
-		value := p.expression()
-		items = append(items, MapItem{key, value})
+		if p.tok == ELLIPSIS {
+			items = append(items, MapItem{nil, &Spread{key}})
+			p.next()
+		} else {
+			p.expect(COLON)
+			value := p.expression()
+			items = append(items, MapItem{key, value})
+		}
 		if p.tok == COMMA {
 			gotComma = true
 			p.next()
@@ -463,15 +801,44 @@ func ParseExpression(input []byte) (e Expression, err error) {
 // basically a list of statements). If the program parses correctly, return
 // a *Program and nil. If there's a syntax error, return nil and a
 // parser.Error value.
-func ParseProgram(input []byte) (prog *Program, err error) {
+func ParseProgram(input []byte) (*Program, error) {
+	return parseProgram(NewTokenizer(input), false)
+}
+
+// ParseFile is like ParseProgram, but records the given filename in the
+// positions of the program's nodes and of any parse error, so errors can
+// say which file they're in.
+func ParseFile(filename string, input []byte) (*Program, error) {
+	t := NewTokenizer(input)
+	t.SetFilename(filename)
+	return parseProgram(t, false)
+}
+
+// ParseProgramComments is like ParseProgram, but also returns the comments
+// in the source, for tools like formatters that need to keep them. The
+// returned Program's Comments field has all the comments, and its Attached
+// field has the leading and trailing comments of each statement.
+func ParseProgramComments(input []byte) (*Program, error) {
+	return parseProgram(NewTokenizer(input), true)
+}
+
+// Parse a program from the tokens of t, recording its comments if comments
+// is true
+func parseProgram(t *Tokenizer, comments bool) (prog *Program, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			// Convert to parser.Error or re-panic
 			err = r.(Error)
 		}
 	}()
-	t := NewTokenizer(input)
 	p := parser{tokenizer: t}
+	if comments {
+		t.EmitComments()
+		p.attached = make(map[Statement]*StatementComments)
+	}
 	p.next()
-	return p.program(), nil
+	prog = p.program()
+	prog.Comments = p.comments
+	prog.Attached = p.attached
+	return prog, nil
 }
@@ -5,6 +5,7 @@ package parser_test
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/benhoyt/littlelang/parser"
@@ -33,6 +34,10 @@ func TestParseExpression(t *testing.T) {
 		{"[1, 2]", "List", "[1, 2]", 1, 1},
 		{"[1, 2,]", "List", "[1, 2]", 1, 1},
 		{"[a+b, f(),]", "List", "[(a + b), f()]", 1, 1},
+		{"[a...]", "List", "[a...]", 1, 1},
+		{"[a..., 4, f(b)...,]", "List", "[a..., 4, f(b)...]", 1, 1},
+		{"[...]", "", "expected expression, not ...", 1, 2},
+		{"[a... b]", "", "expected , between list elements", 1, 7},
 		{"[", "", "expected ] and not EOF", 1, 2},
 		{"[1 2", "", "expected , between list elements", 1, 4},
 		{"[,]", "", "expected expression, not ,", 1, 2},
@@ -42,6 +47,9 @@ func TestParseExpression(t *testing.T) {
 		{`{"a": 1}`, "Map", `{"a": 1}`, 1, 1},
 		{`{x: 1}`, "Map", `{x: 1}`, 1, 1},
 		{`{x: 1,}`, "Map", `{x: 1}`, 1, 1},
+		{`{m...}`, "Map", `{m...}`, 1, 1},
+		{`{m..., "a": 1, f(x)...,}`, "Map", `{m..., "a": 1, f(x)...}`, 1, 1},
+		{`{m...: 1}`, "", `expected , between map items`, 1, 6},
 		{`{x: 1, b: 2}`, "Map", `{x: 1, b: 2}`, 1, 1},
 		{`{x: 1, b: 2,}`, "Map", `{x: 1, b: 2}`, 1, 1},
 		{`{x + y: 1, "a" + f(): g() / 4,}`, "Map", `{(x + y): 1, ("a" + f()): (g() / 4)}`, 1, 1},
@@ -64,6 +72,11 @@ func TestParseExpression(t *testing.T) {
 		{"func(a: b) {}", "", "expected , between parameters", 1, 7},
 		{"func(a..., b) {}", "", "can only have ... after last parameter", 1, 12},
 		{"func(,) {}", "", "expected name and not ,", 1, 6},
+		{"func(x): x * 2", "FunctionExpression", "func(x) {\n    return (x * 2)\n}", 1, 1},
+		{"func(): [1, 2]", "FunctionExpression", "func() {\n    return [1, 2]\n}", 1, 1},
+		{"func(a, b...): f(a, b...)", "FunctionExpression", "func(a, b...) {\n    return f(a, b...)\n}", 1, 1},
+		{"func(x):", "", "expected expression, not EOF", 1, 9},
+		{"func(x): return x", "", "expected expression, not return", 1, 10},
 		{"func(", "", "expected ) and not EOF", 1, 6},
 
 		// Grouping
@@ -80,6 +93,11 @@ func TestParseExpression(t *testing.T) {
 		{`a.b["c"]`, "Subscript", `a["b"]["c"]`, 1, 4},
 		{`a["b"].c`, "Subscript", `a["b"]["c"]`, 1, 7},
 		{`a["b"]["c"]`, "Subscript", `a["b"]["c"]`, 1, 7},
+		{`a?.b`, "Subscript", `a?["b"]`, 1, 2},
+		{`a?[0]?.b.c`, "Subscript", `a?[0]?["b"]["c"]`, 1, 9},
+		{`a?[b + 1]`, "Subscript", `a?[(b + 1)]`, 1, 2},
+		{`a?[1:2]`, "", `expected ] and not :`, 1, 5},
+		{`a?.1`, "", `expected name and not int`, 1, 4},
 		{`a.`, "", `expected name and not EOF`, 1, 3},
 		{`a.1`, "", `expected name and not int`, 1, 3},
 		{`a[...]`, "", `expected expression, not ...`, 1, 3},
@@ -96,8 +114,18 @@ func TestParseExpression(t *testing.T) {
 		{"f(a, b, c...)", "Call", "f(a, b, c...)", 1, 2},
 		{"f(,)", "", "expected expression, not ,", 1, 3},
 		{"f(a b)", "", "expected , between arguments", 1, 5},
-		{"f(a..., b)", "", "can only have ... after last argument", 1, 9},
+		{"f(a..., b)", "Call", "f(a..., b)", 1, 2},
+		{"f(a..., b, c..., d...)", "Call", "f(a..., b, c..., d...)", 1, 2},
+		{"f(a... b)", "", "expected , between arguments", 1, 8},
+		{"f(a......)", "", "expected , between arguments", 1, 7},
+		{"f(a..., x=1)", "Call", "f(a..., x=1)", 1, 2},
 		{"f(a,", "", "expected ) and not EOF", 1, 5},
+		{"f(x=1)", "Call", "f(x=1)", 1, 2},
+		{"f(a, b, x=1, y=c+d,)", "Call", "f(a, b, x=1, y=(c + d))", 1, 2},
+		{"f(x=1, a)", "", "positional argument can't follow keyword arguments", 1, 8},
+		{"f(x=1, y=2, x=3)", "", "duplicate keyword argument x", 1, 13},
+		{"f(x=a...)", "", "can't use ... with keyword arguments", 1, 6},
+		{"f(a + b=1)", "", "expected , between arguments", 1, 8},
 
 		// Negative (unary minus)
 		{"-3", "Unary", "(-3)", 1, 1},
@@ -351,6 +379,74 @@ if b {
 		{"for a in b {", "expected } and not EOF", 1, 13},
 		{"for", "expected name and not EOF", 1, 4},
 
+		// Try statements
+		{"try { f() } catch e { g(e) }", `try {
+    f()
+} catch e {
+    g(e)
+}`, 1, 1},
+		{"try { f() }", "expected catch and not EOF", 1, 12},
+		{"try { f() } catch { g() }", "expected name and not {", 1, 19},
+		{"try f() catch e {}", "expected { and not name", 1, 5},
+
+		// Match statements
+		{`match v { [x, y] { f(x, y) } {"type": t} { g(t) } _ { h() } }`, `match v {
+    [x, y] {
+        f(x, y)
+    }
+    {"type": t} {
+        g(t)
+    }
+    _ {
+        h()
+    }
+}`, 1, 1},
+		{`match f(x) {}`, "match f(x) {\n\n}", 1, 1},
+		{`match v { 1 {} -2 {} -2.5 {} "s" {} true {} nil {} [] {} {} {} [a, [b, c], rest...] {} {"a": {"b": [x,]},} {} }`,
+			`match v {
+    1 {
+        
+    }
+    -2 {
+        
+    }
+    -2.5 {
+        
+    }
+    "s" {
+        
+    }
+    true {
+        
+    }
+    nil {
+        
+    }
+    [] {
+        
+    }
+    {} {
+        
+    }
+    [a, [b, c], rest...] {
+        
+    }
+    {"a": {"b": [x]}} {
+        
+    }
+}`, 1, 1},
+		{`match v { [x, x] {} }`, "duplicate name x in pattern", 1, 15},
+		{`match v { [x, {"a": x}] {} }`, "duplicate name x in pattern", 1, 21},
+		{`match v { [rest..., x] {} }`, "can only have ... after last list pattern", 1, 21},
+		{`match v { [1...] {} }`, "can only use ... after a name in a pattern", 1, 13},
+		{`match v { {x: 1} {} }`, "map pattern key must be a str, not name", 1, 12},
+		{`match v { a + b {} }`, "expected { and not +", 1, 13},
+		{`match v { f() {} }`, "expected { and not (", 1, 12},
+		{`match v { -x {} }`, "expected number after - in pattern, not name", 1, 12},
+		{`match v { [a b] {} }`, "expected , between list patterns", 1, 14},
+		{`match v x {}`, "expected { and not name", 1, 9},
+		{`match v { x }`, "expected { and not }", 1, 13},
+
 		// Return statements (return outside of function is legal according
 		// to the parser, but causes a runtime error)
 		{"return a", "return a", 1, 1},
@@ -363,6 +459,21 @@ if b {
 		{"func() { return }", "expected expression, not }", 1, 17},
 		{"func() { return if }", "expected expression, not if", 1, 17},
 
+		// Yield statements (only allowed inside a function)
+		{"func g() { yield 1 yield a + b }", `func g() {
+    yield 1
+    yield (a + b)
+}`, 1, 1},
+		{"func() { for x in xs { yield x } }", `func() {
+    for x in xs {
+        yield x
+    }
+}`, 1, 1},
+		{"yield 1", "can't yield outside a function", 1, 1},
+		{"if a { yield 1 }", "can't yield outside a function", 1, 8},
+		{"func() { yield }", "expected expression, not }", 1, 16},
+		{"func(x): yield x", "expected expression, not yield", 1, 10},
+
 		// Function definitions (function expression is kinda useless at the
 		// statement level -- does nothing but is valid syntax)
 		{"func() {}", "func() {}", 1, 1},
@@ -393,6 +504,17 @@ if b {
 		{"func f(,) {}", "expected name and not ,", 1, 8},
 		{"func f(", "expected ) and not EOF", 1, 8},
 
+		// Structs and methods
+		{"struct Point { x y }", "struct Point { x y }", 1, 1},
+		{"struct Point {\n    x\n    y\n}", "struct Point { x y }", 1, 1},
+		{"struct Empty {}", "struct Empty {  }", 1, 1},
+		{"func Point.dist(p, q) { return 0 }", "func Point.dist(p, q) {\n    return 0\n}", 1, 1},
+		{"struct Point { x, y }", "expected field name, not ,", 1, 17},
+		{"struct Point { x y x }", "duplicate field x in struct Point", 1, 20},
+		{"struct { x }", "expected name and not {", 1, 8},
+		{"func Point.dist() {}", "method Point.dist needs a parameter for its receiver", 1, 16},
+		{"func Point.(p) {}", "expected name and not (", 1, 12},
+
 		// Assignments
 		{"a = 1", "a = 1", 1, 3},
 		{"a = 1 b = 2", "a = 1\nb = 2", 1, 3},
@@ -401,6 +523,7 @@ if b {
 		{`x.y = 3`, `x["y"] = 3`, 1, 5},
 		{`x["y"] = 3`, `x["y"] = 3`, 1, 8},
 		{"(a + b) = 3", "expected name, subscript, or dot expression on left side of =", 1, 9},
+		{"a?.b = 3", "can't assign to ?[ or ?. expression", 1, 6},
 
 		// Comments, expression statements, multiline programs, etc
 		{"", "", 0, 0},
@@ -460,6 +583,131 @@ if false {
 	}
 }
 
+func TestWalk(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+func f(a) { return a + 1 }
+x = [f(1), {"k": func() { print(-2) }}]
+if not x { y = 3 }
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	names := []string{}
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		names = append(names, reflect.TypeOf(node).Elem().Name())
+		_, isIf := node.(*parser.If)
+		return !isIf
+	})
+	output := fmt.Sprintf("%s", names)
+	expected := "[FunctionDefinition Return Binary Variable Literal Assign Variable List Call Variable Literal " +
+		"Map Literal FunctionExpression ExpressionStatement Call Variable Unary Literal If]"
+	if output != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	prog, err := parser.ParseProgram([]byte(`
+func gen() {
+    f = func() { return 1 }
+    yield f()
+}
+func wrapper() {
+    inner = func() { yield 1 }
+    return inner
+}
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	generators := []string{}
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.FunctionDefinition:
+			generators = append(generators, fmt.Sprintf("%s:%v", n.Name, n.Generator))
+		case *parser.FunctionExpression:
+			generators = append(generators, fmt.Sprintf("func:%v", n.Generator))
+		}
+		return true
+	})
+	output := fmt.Sprintf("%s", generators)
+	expected := "[gen:true func:false wrapper:false func:true]"
+	if output != expected {
+		t.Fatalf("expected %s, got %s", expected, output)
+	}
+}
+
+func TestParseProgramComments(t *testing.T) {
+	source := `// header
+
+// about x
+x = 1 // one
+func f(a) { // not attached
+    // about return
+    return [
+        a, // not attached
+    ] // list
+    // end of block
+}
+print("// not a comment") // call
+// end of file`
+	prog, err := parser.ParseProgramComments([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	comments := []string{}
+	for _, c := range prog.Comments {
+		comments = append(comments, fmt.Sprintf("%d:%d %s", c.Position.Line, c.Position.Column, c.Text))
+	}
+	output := strings.Join(comments, "\n")
+	expected := `1:1 // header
+3:1 // about x
+4:7 // one
+5:13 // not attached
+6:5 // about return
+8:12 // not attached
+9:7 // list
+10:5 // end of block
+12:27 // call
+13:1 // end of file`
+	if output != expected {
+		t.Fatalf("expected comments:\n%s\ngot:\n%s", expected, output)
+	}
+
+	attached := []string{}
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		s, ok := node.(parser.Statement)
+		if !ok || prog.Attached[s] == nil {
+			return true
+		}
+		line := reflect.TypeOf(node).Elem().Name() + ":"
+		for _, c := range prog.Attached[s].Leading {
+			line += " " + c.Text
+		}
+		if prog.Attached[s].Trailing != nil {
+			line += " | " + prog.Attached[s].Trailing.Text
+		}
+		attached = append(attached, line)
+		return true
+	})
+	output = strings.Join(attached, "\n")
+	expected = `Assign: // header // about x | // one
+Return: // about return | // list
+ExpressionStatement: | // call`
+	if output != expected {
+		t.Fatalf("expected attached comments:\n%s\ngot:\n%s", expected, output)
+	}
+
+	prog, err = parser.ParseProgram([]byte(source))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if prog.Comments != nil || prog.Attached != nil {
+		t.Fatalf("expected no comments from ParseProgram")
+	}
+}
+
 func Example_valid() {
 	prog, err := parser.ParseProgram([]byte("if true { print(1234) }"))
 	if err != nil {
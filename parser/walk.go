@@ -0,0 +1,98 @@
+// Traversal of littlelang AST nodes
+
+package parser
+
+import (
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Node is a single AST node, either a Statement or an Expression.
+type Node interface {
+	Position() Position
+}
+
+// Walk traverses the statements in block in depth-first order, calling f for
+// each statement and expression (including those nested in function bodies).
+// If f returns false, Walk doesn't visit the children of that node.
+func Walk(block Block, f func(Node) bool) {
+	for _, s := range block {
+		walk(s, f)
+	}
+}
+
+func walk(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Assign:
+		walk(n.Target, f)
+		walk(n.Value, f)
+	case *OuterAssign:
+		walk(n.Value, f)
+	case *Yield:
+		walk(n.Value, f)
+	case *If:
+		walk(n.Condition, f)
+		Walk(n.Body, f)
+		Walk(n.Else, f)
+	case *While:
+		walk(n.Condition, f)
+		Walk(n.Body, f)
+	case *For:
+		walk(n.Iterable, f)
+		Walk(n.Body, f)
+	case *Try:
+		Walk(n.Body, f)
+		Walk(n.Catch, f)
+	case *Match:
+		walk(n.Value, f)
+		for _, c := range n.Cases {
+			walk(c.Pattern, f)
+			Walk(c.Body, f)
+		}
+	case *Return:
+		walk(n.Result, f)
+	case *ExpressionStatement:
+		walk(n.Expression, f)
+	case *FunctionDefinition:
+		Walk(n.Body, f)
+	case *Binary:
+		walk(n.Left, f)
+		walk(n.Right, f)
+	case *Unary:
+		walk(n.Operand, f)
+	case *Call:
+		walk(n.Function, f)
+		for _, arg := range n.Arguments {
+			walk(arg, f)
+		}
+		for _, keyword := range n.Keywords {
+			walk(keyword.Value, f)
+		}
+	case *List:
+		for _, value := range n.Values {
+			walk(value, f)
+		}
+	case *Spread:
+		walk(n.Value, f)
+	case *Map:
+		for _, item := range n.Items {
+			walk(item.Key, f)
+			walk(item.Value, f)
+		}
+	case *FunctionExpression:
+		Walk(n.Body, f)
+	case *Subscript:
+		walk(n.Container, f)
+		walk(n.Subscript, f)
+	case *Slice:
+		walk(n.Container, f)
+		if n.Start != nil {
+			walk(n.Start, f)
+		}
+		if n.End != nil {
+			walk(n.End, f)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Go pprof profiling of the interpreter for the littlelang command
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Start writing a CPU profile to filename (if not empty), returning a
+// function that stops profiling. Exit with an error message on failure.
+func startCPUProfile(filename string) func() {
+	if filename == "" {
+		return func() {}
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating CPU profile: %v\n", err)
+		os.Exit(exitError)
+	}
+	err = pprof.StartCPUProfile(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error starting CPU profile: %v\n", err)
+		os.Exit(exitError)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}
+}
+
+// Write a heap profile to filename (if not empty)
+func writeMemProfile(filename string) {
+	if filename == "" {
+		return
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating memory profile: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer f.Close()
+	runtime.GC() // get up-to-date statistics
+	err = pprof.WriteHeapProfile(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing memory profile: %v\n", err)
+		os.Exit(exitError)
+	}
+}
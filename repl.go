@@ -0,0 +1,234 @@
+// Interactive read-eval-print loop for the littlelang command
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+)
+
+// Prompts for the first line of a statement, and for the following lines
+// of a statement that isn't complete yet
+const (
+	replPrompt         = ">>> "
+	replContinuePrompt = "... "
+)
+
+// Help for the REPL's meta-commands, which are entered instead of a
+// statement
+const replHelp = `:vars        list the names of the global variables and functions
+:load file   run the given source file (or std library module)
+:reset       start again with a new interpreter
+:quit        exit the REPL (as does Ctrl-D)
+:help        show this help
+`
+
+// Run an interactive read-eval-print loop, reading statements from stdin
+// and executing them in interp until the end of input or :quit. The value
+// of an expression statement is printed unless it's nil. Errors are shown
+// but don't stop the loop. An interrupt (Ctrl-C) while the REPL is waiting
+// for input discards the statement being entered. The :reset command
+// replaces the interpreter with one from newInterp. Return the final
+// interpreter and the exit status.
+func repl(interp *interpreter.Interpreter, stdin *bufio.Reader, signals *signalNotifier,
+	newInterp func() (*interpreter.Interpreter, int)) (*interpreter.Interpreter, int) {
+	// Only show prompts if a user is typing the input
+	showPrompts := isTerminal(os.Stdin)
+	reader := &replReader{
+		stdin:      stdin,
+		signals:    signals,
+		interrupts: make(chan os.Signal, 1),
+	}
+	var input []byte
+	for {
+		if showPrompts {
+			if len(input) == 0 {
+				fmt.Print(replPrompt)
+			} else {
+				fmt.Print(replContinuePrompt)
+			}
+		}
+		line, err := reader.readLine()
+		if err == errInterrupted {
+			if showPrompts {
+				fmt.Println()
+			}
+			input = nil
+			continue
+		}
+		if err != nil && line == "" {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "error reading input: %v\n", err)
+				return interp, exitError
+			}
+			if showPrompts {
+				fmt.Println()
+			}
+			return interp, 0
+		}
+		if len(input) == 0 && strings.HasPrefix(line, ":") {
+			fields := strings.Fields(line)
+			switch {
+			case fields[0] == ":quit" && len(fields) == 1:
+				return interp, 0
+			case fields[0] == ":vars" && len(fields) == 1:
+				replVars(interp)
+			case fields[0] == ":load" && len(fields) == 2:
+				replLoad(interp, fields[1])
+			case fields[0] == ":reset" && len(fields) == 1:
+				interp.Stop()
+				interp, _ = newInterp()
+			case fields[0] == ":help" && len(fields) == 1:
+				fmt.Print(replHelp)
+			default:
+				fmt.Fprintf(os.Stderr, "invalid command %s (enter :help for a list)\n", strings.TrimSpace(line))
+			}
+			continue
+		}
+		input = append(input, line...)
+		prog, err := parser.ParseProgram(input)
+		if err != nil {
+			// Keep reading if the statement isn't finished yet, unless the
+			// user entered a blank line to give up on it
+			if incompleteInput(err) && strings.TrimSpace(line) != "" {
+				continue
+			}
+			showError(input, err)
+			input = nil
+			continue
+		}
+		replExecute(interp, input, prog)
+		input = nil
+	}
+}
+
+// Returned by replReader.readLine when the user interrupts it
+var errInterrupted = errors.New("interrupted")
+
+// Reads the REPL's input lines, letting the user interrupt the wait for
+// one. The program's trapped signals aren't delivered while the REPL is
+// waiting, as there's no program code running to handle them.
+type replReader struct {
+	stdin      *bufio.Reader
+	signals    *signalNotifier
+	interrupts chan os.Signal
+	pending    chan replLine // result of a read in progress, or nil
+}
+
+type replLine struct {
+	line string
+	err  error
+}
+
+// Read the next line of input, or return errInterrupted if an interrupt
+// signal arrives first. The read carries on after an interrupt, and the
+// next call returns its result, as stdin can't be read by two goroutines
+// at once. Any signal queued for the program is discarded before
+// returning, so it isn't handled by the next input's code.
+func (r *replReader) readLine() (string, error) {
+	if r.pending == nil {
+		r.pending = make(chan replLine, 1)
+		go func(pending chan<- replLine) {
+			line, err := r.stdin.ReadString('\n')
+			pending <- replLine{line, err}
+		}(r.pending)
+	}
+	signal.Notify(r.interrupts, os.Interrupt)
+	r.signals.pause()
+	defer func() {
+		r.signals.resume()
+		signal.Stop(r.interrupts)
+		select {
+		case <-r.interrupts:
+		default:
+		}
+	}()
+	select {
+	case result := <-r.pending:
+		r.pending = nil
+		return result.line, result.err
+	case <-r.interrupts:
+		return "", errInterrupted
+	}
+}
+
+// Report whether err is a parse error caused by the input ending before
+// the end of a statement, so more lines could complete it
+func incompleteInput(err error) bool {
+	e, ok := err.(parser.Error)
+	if !ok {
+		return false
+	}
+	return strings.HasSuffix(e.Message, "EOF") ||
+		strings.HasPrefix(e.Message, "didn't find end backtick") ||
+		strings.HasPrefix(e.Message, `didn't find end """`)
+}
+
+// Execute one REPL input in interp, printing the value if it's a single
+// expression, and showing any error inline with the input
+func replExecute(interp *interpreter.Interpreter, input []byte, prog *parser.Program) {
+	if len(prog.Statements) == 1 {
+		if s, ok := prog.Statements[0].(*parser.ExpressionStatement); ok {
+			value, err := interp.Evaluate(s.Expression)
+			if err != nil {
+				showError(input, err)
+				return
+			}
+			if value == nil {
+				return
+			}
+			str, err := interp.Repr(value)
+			if err != nil {
+				showError(input, err)
+				return
+			}
+			fmt.Println(str)
+			return
+		}
+	}
+	err := interp.Execute(prog)
+	if err != nil {
+		showError(input, err)
+	}
+}
+
+// Print the names of the global variables and functions defined in interp
+func replVars(interp *interpreter.Interpreter) {
+	globals := interp.Globals()
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// Parse the named source file and execute it in interp, showing any error
+func replLoad(interp *interpreter.Interpreter, filename string) {
+	input, ok := readSource(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error reading %q\n", filename)
+		return
+	}
+	sourceFiles[filename] = input
+	prog, err := parser.ParseFile(filename, input)
+	if err != nil {
+		showError(input, err)
+		return
+	}
+	err = interp.Execute(prog)
+	if err != nil {
+		showError(input, err)
+	}
+}
@@ -0,0 +1,164 @@
+// "littlelang selftest" command: run the interpreter test corpus
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/benhoyt/littlelang/internal/corpus"
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+)
+
+// Source of the littlelang interpreter written in littlelang
+//go:embed littlelang.ll
+var selfInterpreter []byte
+
+// Run the test corpus through both the Go interpreter and the embedded
+// littlelang interpreter (running on the Go one), print any failures and a
+// summary, and return the exit status
+func selftest() int {
+	selfProg, err := parser.ParseProgram(selfInterpreter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing embedded littlelang.ll: %v\n", err)
+		return exitError
+	}
+	failed := 0
+	for _, mode := range []string{"go", "littlelang"} {
+		modeFailed := 0
+		for _, test := range corpus.Tests {
+			var err error
+			if mode == "go" {
+				err = selftestGo(test)
+			} else {
+				err = selftestSelf(selfProg, test)
+			}
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n    %v\n", mode, summarizeSource(test.Source), err)
+				modeFailed++
+			}
+		}
+		fmt.Printf("%s: %d passed, %d failed\n", mode, len(corpus.Tests)-modeFailed, modeFailed)
+		failed += modeFailed
+	}
+	if failed > 0 {
+		return exitError
+	}
+	return 0
+}
+
+// Run test using the Go interpreter directly
+func selftestGo(test corpus.Test) error {
+	prog, err := parser.ParseProgram([]byte(test.Source))
+	if err != nil {
+		return err
+	}
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Args:       corpus.Args,
+		Stdin:      strings.NewReader(corpus.Stdin),
+		Stdout:     stdout,
+		Exit:       func(n int) { fmt.Fprintf(stdout, "exit(%d)", n) },
+		NoWarnings: true,
+	}
+	_, err = interpreter.Execute(prog, config)
+	var output string
+	if err != nil {
+		fields := strings.SplitN(err.Error(), ": ", 2)
+		if len(fields) < 2 {
+			return fmt.Errorf("expected \": \" in error output, got %q", err.Error())
+		}
+		if fields[0] != test.ErrPos {
+			return fmt.Errorf("expected errpos %q, got %q", test.ErrPos, fields[0])
+		}
+		output = fields[1]
+	} else {
+		output = strings.TrimRight(stdout.String(), "\n")
+	}
+	if output != test.Output {
+		return fmt.Errorf("expected %q, got %q", test.Output, output)
+	}
+	return nil
+}
+
+// Run test using littlelang.ll running on the Go interpreter. Like the
+// interpreter tests' -exe mode, only the error message is compared for
+// tests that are expected to fail, not the error position.
+func selftestSelf(selfProg *parser.Program, test corpus.Test) (err error) {
+	srcFile, err := ioutil.TempFile("", "lltest_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(srcFile.Name())
+	_, err = srcFile.Write([]byte(test.Source))
+	srcFile.Close()
+	if err != nil {
+		return err
+	}
+
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Args:       append([]string{srcFile.Name()}, corpus.Args...),
+		Stdin:      strings.NewReader(corpus.Stdin),
+		Stdout:     stdout,
+		ReturnExit: true,
+	}
+	status, err := executeSelf(selfProg, config)
+	var output string
+	switch {
+	case err != nil:
+		// Error raised by the Go interpreter while running littlelang.ll
+		fields := strings.SplitN(err.Error(), ": ", 2)
+		if len(fields) < 2 {
+			return fmt.Errorf("expected \": \" in error output, got %q", err.Error())
+		}
+		output = fields[1]
+	case status != 0:
+		// Error printed by littlelang.ll as the last line of its output
+		lines := strings.Split(strings.TrimRight(stdout.String(), "\n"), "\n")
+		lastLine := lines[len(lines)-1]
+		fields := strings.SplitN(lastLine, ": ", 2)
+		if len(fields) < 2 {
+			return fmt.Errorf("expected \": \" in error output, got %q", lastLine)
+		}
+		output = fields[1]
+	default:
+		output = strings.TrimRight(stdout.String(), "\n")
+	}
+	if (err != nil || status != 0) != (test.ErrPos != "") {
+		if test.ErrPos == "" {
+			return fmt.Errorf("expected no error, got %q", output)
+		}
+		return fmt.Errorf("expected error %q, got no error (output %q)", test.ErrPos, output)
+	}
+	if output != test.Output {
+		return fmt.Errorf("expected %q, got %q", test.Output, output)
+	}
+	return nil
+}
+
+// Execute prog, returning the exit status if it calls exit()
+func executeSelf(prog *parser.Program, config *interpreter.Config) (int, error) {
+	_, err := interpreter.Execute(prog, config)
+	if e, ok := err.(interpreter.ExitError); ok {
+		return e.Status, nil
+	}
+	return 0, err
+}
+
+// Return the first line of source, truncated for display
+func summarizeSource(source string) string {
+	s := strings.TrimSpace(source)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i] + " ..."
+	}
+	if len(s) > 60 {
+		s = s[:60] + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,103 @@
+// Signal handling for the littlelang command
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Signals a program can trap, and the status to exit with if one arrives
+// before the last one has been handled
+var trapSignals = map[string]struct {
+	signal os.Signal
+	status int
+}{
+	"interrupt": {os.Interrupt, 130},
+	"terminate": {syscall.SIGTERM, 143},
+}
+
+// Delivers the signals the program has trapped to the interpreter. A
+// signal the program hasn't trapped isn't caught at all, so it ends the
+// process right away as usual, even if the program is in a tight loop or
+// blocked. Programs handle trapped signals between statements, so if
+// another one arrives before the first has been handled (for example,
+// because the program is blocked reading input), exit immediately.
+type signalNotifier struct {
+	signals   chan string // for interpreter.Config.Signals
+	notifiers map[string]chan os.Signal
+
+	mutex  sync.Mutex
+	paused bool // the REPL is waiting for input, not running the program
+}
+
+func newSignalNotifier() *signalNotifier {
+	return &signalNotifier{
+		signals:   make(chan string, 1),
+		notifiers: make(map[string]chan os.Signal),
+	}
+}
+
+// Start or stop delivering the named signal, for interpreter.Config.Trap
+func (n *signalNotifier) trap(name string, trapped bool) {
+	sig, ok := trapSignals[name]
+	if !ok {
+		return
+	}
+	notify := n.notifiers[name]
+	if notify == nil {
+		notify = make(chan os.Signal, 1)
+		n.notifiers[name] = notify
+		go func() {
+			for range notify {
+				n.deliver(name, sig.status)
+			}
+		}()
+	}
+	if trapped {
+		signal.Notify(notify, sig.signal)
+	} else {
+		signal.Stop(notify)
+	}
+}
+
+func (n *signalNotifier) deliver(name string, status int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.paused {
+		// No program code is running, so there's nothing for a handler to
+		// interrupt: the REPL handles an interrupt itself (by discarding the
+		// input line), and other signals end the process as usual
+		if name != "interrupt" {
+			os.Exit(status)
+		}
+		return
+	}
+	select {
+	case n.signals <- name:
+	default:
+		os.Exit(status)
+	}
+}
+
+// Stop delivering trapped signals while the REPL waits for input
+func (n *signalNotifier) pause() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.paused = true
+}
+
+// Start delivering trapped signals again before the REPL executes the next
+// input, discarding any signal that was queued but not handled by the last
+// one, so it isn't handled by (or doesn't exit during) an unrelated input
+func (n *signalNotifier) resume() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.paused = false
+	select {
+	case <-n.signals:
+	default:
+	}
+}
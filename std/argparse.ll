@@ -0,0 +1,97 @@
+// Command-line option parsing. Load with "-lib std/argparse", then call
+// argparse.parse(args(), defaults), where defaults is a map of option name
+// to default value. For example:
+//
+//     opts = argparse.parse(args(), {"verbose": false, "n": 10})
+//
+// A bool option is set to true by -name (or --name). Other options take a
+// value, as in -name value or -name=value, which is converted with int()
+// if the default is an int. The remaining positional arguments are
+// returned in the "args" key. Arguments after "--" are always positional.
+// On an invalid option, or -h or --help, it prints usage and exits.
+
+argparse = {
+    "parse": func(argv, defaults) {
+        opts = {"args": []}
+        for name in defaults {
+            opts[name] = defaults[name]
+        }
+        i = 0
+        while i < len(argv) {
+            arg = argv[i]
+            i = i + 1
+            if arg == "--" {
+                while i < len(argv) {
+                    append(opts.args, argv[i])
+                    i = i + 1
+                }
+            } else if arg == "-h" or arg == "--help" {
+                argparse.usage(defaults)
+                exit(0)
+            } else if len(arg) > 1 and arg[0] == "-" {
+                name = arg
+                while len(name) > 0 and name[0] == "-" {
+                    name = slice(name, 1, len(name))
+                }
+                value = nil
+                eq = find(name, "=")
+                if eq >= 0 {
+                    value = slice(name, eq+1, len(name))
+                    name = slice(name, 0, eq)
+                }
+                if not (name in defaults) or name == "args" {
+                    argparse.error("unknown option -" + name, defaults)
+                }
+                if type(defaults[name]) == "bool" {
+                    if value != nil {
+                        argparse.error("option -" + name + " doesn't take a value", defaults)
+                    }
+                    opts[name] = true
+                } else {
+                    if value == nil {
+                        if i >= len(argv) {
+                            argparse.error("option -" + name + " requires a value", defaults)
+                        }
+                        value = argv[i]
+                        i = i + 1
+                    }
+                    if type(defaults[name]) == "int" {
+                        value = int(value)
+                        if value == nil {
+                            argparse.error("option -" + name + " requires an int", defaults)
+                        }
+                    }
+                    opts[name] = value
+                }
+            } else {
+                append(opts.args, arg)
+            }
+        }
+        return opts
+    },
+
+    // Print usage showing the options in defaults
+    "usage": func(defaults) {
+        names = []
+        for name in defaults {
+            append(names, name)
+        }
+        sort(names)
+        print("options:")
+        for name in names {
+            value = defaults[name]
+            if type(value) == "bool" {
+                print("  -" + name)
+            } else {
+                print("  -" + name + " " + type(value) + " (default " + str(value) + ")")
+            }
+        }
+    },
+
+    // Print error message and usage, then exit with status 2
+    "error": func(message, defaults) {
+        print("error: " + message)
+        argparse.usage(defaults)
+        exit(2)
+    },
+}
@@ -0,0 +1,241 @@
+// JSON encoding and decoding. Load with "-lib std/json", then call
+// json.encode(value) or json.decode(str). Numbers with a fraction or
+// exponent decode to floats, others to ints.
+
+json = {
+    // Return the JSON representation of value (nil, bool, int, float, str,
+    // list, or map). Map keys are output in sorted order. JSON can't
+    // represent infinite or NaN floats, so they're output as null.
+    "encode": func(value) {
+        t = type(value)
+        if t == "nil" {
+            return "null"
+        } else if t == "bool" or t == "int" {
+            return str(value)
+        } else if t == "float" {
+            if value != value or value - value != 0 {
+                return "null"
+            }
+            return str(value)
+        } else if t == "str" {
+            return json._quote(value)
+        } else if t == "list" {
+            parts = []
+            for x in value {
+                append(parts, json.encode(x))
+            }
+            return "[" + join(parts, ",") + "]"
+        } else if t == "map" {
+            keys = []
+            for k in value {
+                append(keys, k)
+            }
+            sort(keys)
+            parts = []
+            for k in keys {
+                append(parts, json._quote(k) + ":" + json.encode(value[k]))
+            }
+            return "{" + join(parts, ",") + "}"
+        }
+        return json._quote(str(value))
+    },
+
+    "_quote": func(s) {
+        escapes = {"\"": "\\\"", "\\": "\\\\", "\n": "\\n", "\r": "\\r", "\t": "\\t"}
+        parts = []
+        for i in range(len(s)) {
+            c = s[i]
+            if c in escapes {
+                append(parts, escapes[c])
+            } else {
+                append(parts, c)
+            }
+        }
+        return "\"" + join(parts, "") + "\""
+    },
+
+    // Parse the JSON in s and return the resulting value. Returns nil if s
+    // isn't valid JSON (like int() does for invalid input).
+    "decode": func(s) {
+        p = {"s": s, "i": 0, "ok": true}
+        value = json._value(p)
+        json._space(p)
+        if not p.ok or p.i != len(s) {
+            return nil
+        }
+        return value
+    },
+
+    "_space": func(p) {
+        while p.i < len(p.s) and p.s[p.i] in " \t\r\n" {
+            p.i = p.i + 1
+        }
+    },
+
+    "_fail": func(p) {
+        p.ok = false
+        p.i = len(p.s)
+        return nil
+    },
+
+    "_literal": func(p, word, value) {
+        if slice(p.s, p.i, p.i+len(word)) != word {
+            return json._fail(p)
+        }
+        p.i = p.i + len(word)
+        return value
+    },
+
+    "_value": func(p) {
+        json._space(p)
+        if p.i >= len(p.s) {
+            return json._fail(p)
+        }
+        c = p.s[p.i]
+        if c == "{" {
+            return json._object(p)
+        } else if c == "[" {
+            return json._array(p)
+        } else if c == "\"" {
+            return json._string(p)
+        } else if c == "t" {
+            return json._literal(p, "true", true)
+        } else if c == "f" {
+            return json._literal(p, "false", false)
+        } else if c == "n" {
+            return json._literal(p, "null", nil)
+        }
+        return json._number(p)
+    },
+
+    "_number": func(p) {
+        start = p.i
+        if p.i < len(p.s) and p.s[p.i] == "-" {
+            p.i = p.i + 1
+        }
+        while p.i < len(p.s) and p.s[p.i] in "0123456789" {
+            p.i = p.i + 1
+        }
+        convert = int
+        if p.i < len(p.s) and p.s[p.i] == "." {
+            convert = float
+            p.i = p.i + 1
+            while p.i < len(p.s) and p.s[p.i] in "0123456789" {
+                p.i = p.i + 1
+            }
+        }
+        if p.i < len(p.s) and p.s[p.i] in "eE" {
+            convert = float
+            p.i = p.i + 1
+            if p.i < len(p.s) and p.s[p.i] in "+-" {
+                p.i = p.i + 1
+            }
+            while p.i < len(p.s) and p.s[p.i] in "0123456789" {
+                p.i = p.i + 1
+            }
+        }
+        n = convert(slice(p.s, start, p.i))
+        if n == nil {
+            return json._fail(p)
+        }
+        return n
+    },
+
+    "_string": func(p) {
+        escapes = {"\"": "\"", "\\": "\\", "/": "/", "n": "\n", "r": "\r", "t": "\t"}
+        p.i = p.i + 1
+        parts = []
+        while p.i < len(p.s) and p.s[p.i] != "\"" {
+            c = p.s[p.i]
+            if c == "\\" {
+                p.i = p.i + 1
+                if p.i >= len(p.s) {
+                    return json._fail(p)
+                }
+                c = p.s[p.i]
+                if c == "u" {
+                    code = 0
+                    for i in range(4) {
+                        p.i = p.i + 1
+                        digit = -1
+                        if p.i < len(p.s) {
+                            digit = find("0123456789abcdef", lower(p.s[p.i]))
+                        }
+                        if digit < 0 {
+                            return json._fail(p)
+                        }
+                        code = code*16 + digit
+                    }
+                    c = char(code)
+                } else if c in escapes {
+                    c = escapes[c]
+                } else {
+                    return json._fail(p)
+                }
+            }
+            append(parts, c)
+            p.i = p.i + 1
+        }
+        if p.i >= len(p.s) {
+            return json._fail(p)
+        }
+        p.i = p.i + 1
+        return join(parts, "")
+    },
+
+    "_array": func(p) {
+        p.i = p.i + 1
+        result = []
+        json._space(p)
+        if p.i < len(p.s) and p.s[p.i] == "]" {
+            p.i = p.i + 1
+            return result
+        }
+        while p.ok {
+            append(result, json._value(p))
+            json._space(p)
+            if p.i < len(p.s) and p.s[p.i] == "]" {
+                p.i = p.i + 1
+                return result
+            }
+            if p.i >= len(p.s) or p.s[p.i] != "," {
+                return json._fail(p)
+            }
+            p.i = p.i + 1
+        }
+        return nil
+    },
+
+    "_object": func(p) {
+        p.i = p.i + 1
+        result = {}
+        json._space(p)
+        if p.i < len(p.s) and p.s[p.i] == "}" {
+            p.i = p.i + 1
+            return result
+        }
+        while p.ok {
+            json._space(p)
+            if p.i >= len(p.s) or p.s[p.i] != "\"" {
+                return json._fail(p)
+            }
+            key = json._string(p)
+            json._space(p)
+            if p.i >= len(p.s) or p.s[p.i] != ":" {
+                return json._fail(p)
+            }
+            p.i = p.i + 1
+            result[key] = json._value(p)
+            json._space(p)
+            if p.i < len(p.s) and p.s[p.i] == "}" {
+                p.i = p.i + 1
+                return result
+            }
+            if p.i >= len(p.s) or p.s[p.i] != "," {
+                return json._fail(p)
+            }
+            p.i = p.i + 1
+        }
+        return nil
+    },
+}
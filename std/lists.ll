@@ -0,0 +1,81 @@
+// List helpers. Load with "-lib std/lists", then call functions on the
+// lists map, for example lists.map(func(x) { return x*2 }, [1, 2, 3]).
+
+lists = {
+    // Return a new list with f(x) for each element x of list
+    "map": func(f, list) {
+        result = []
+        for x in list {
+            append(result, f(x))
+        }
+        return result
+    },
+
+    // Return a new list with the elements x of list for which f(x) is true
+    "filter": func(f, list) {
+        result = []
+        for x in list {
+            if f(x) {
+                append(result, x)
+            }
+        }
+        return result
+    },
+
+    // Combine the elements of list from left to right using f(acc, x),
+    // starting with initial
+    "reduce": func(f, list, initial) {
+        acc = initial
+        for x in list {
+            acc = f(acc, x)
+        }
+        return acc
+    },
+
+    // Return the sum of a list of ints
+    "sum": func(list) {
+        return lists.reduce(func(a, b) { return a + b }, list, 0)
+    },
+
+    // Return the smallest element of a non-empty list
+    "min": func(list) {
+        result = list[0]
+        for x in list {
+            if x < result {
+                result = x
+            }
+        }
+        return result
+    },
+
+    // Return the largest element of a non-empty list
+    "max": func(list) {
+        result = list[0]
+        for x in list {
+            if x > result {
+                result = x
+            }
+        }
+        return result
+    },
+
+    // Return a new list with the elements of list in reverse order
+    "reverse": func(list) {
+        result = []
+        i = len(list) - 1
+        while i >= 0 {
+            append(result, list[i])
+            i = i - 1
+        }
+        return result
+    },
+
+    // Return a new list of [index, element] pairs
+    "enumerate": func(list) {
+        result = []
+        for i in range(len(list)) {
+            append(result, [i, list[i]])
+        }
+        return result
+    },
+}
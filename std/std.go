@@ -0,0 +1,37 @@
+// Package std is the littlelang standard library: modules written in
+// littlelang that are embedded in the littlelang binary.
+//
+// Each module defines a single global map named after the module, holding
+// its functions. For example, the "strings" module defines strings.trim().
+//
+package std
+
+import (
+	"embed"
+	"sort"
+	"strings"
+)
+
+//go:embed *.ll
+var files embed.FS
+
+// Source returns the source code of the named module (for example
+// "strings"), and false if there's no such module.
+func Source(name string) ([]byte, bool) {
+	source, err := files.ReadFile(name + ".ll")
+	if err != nil {
+		return nil, false
+	}
+	return source, true
+}
+
+// Modules returns the names of the standard library modules in sorted order.
+func Modules() []string {
+	entries, _ := files.ReadDir(".")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".ll"))
+	}
+	sort.Strings(names)
+	return names
+}
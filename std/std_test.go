@@ -0,0 +1,97 @@
+// Test std package
+
+package std_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/std"
+)
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		module string
+		source string
+		output string
+	}{
+		{"strings", `print(strings.has_prefix("foobar", "foo"), strings.has_prefix("fo", "foo"))`, "true false"},
+		{"strings", `print(strings.has_suffix("foobar", "bar"), strings.has_suffix("foobar", "foo"))`, "true false"},
+		{"strings", `print("[" + strings.trim(" \t hi there\n ") + "]", "[" + strings.trim("   ") + "]")`, "[hi there] []"},
+		{"strings", `print(strings.repeat("ab", 3), strings.replace("a-b-c", "-", "+"))`, "ababab a+b+c"},
+		{"strings", `print("[" + strings.pad_left("ab", 4) + "]", "[" + strings.pad_right("ab", 4) + "]")`, "[  ab] [ab  ]"},
+		{"strings", `print(strings.is_digits("123"), strings.is_digits("12a"), strings.is_digits(""))`, "true false false"},
+
+		{"lists", `print(lists.map(func(x) { return x*2 }, [1, 2, 3]))`, "[2, 4, 6]"},
+		{"lists", `print(lists.filter(func(x) { return x%2 == 1 }, [1, 2, 3]))`, "[1, 3]"},
+		{"lists", `print(lists.sum([1, 2, 3]), lists.min([3, 1, 2]), lists.max([3, 1, 2]))`, "6 1 3"},
+		{"lists", `print(lists.reverse([1, 2, 3]), lists.enumerate(["a", "b"]))`, `[3, 2, 1] [[0, "a"], [1, "b"]]`},
+
+		{"json", `print(json.encode({"b": [1, true, nil], "a": "x\"y\n"}))`, `{"a":"x\"y\n","b":[1,true,null]}`},
+		{"json", `print(json.decode(" {\"a\": [1, -2, \"x\\u0041\"], \"b\": {}, \"c\": false} "))`, `{"a": [1, -2, "xA"], "b": {}, "c": false}`},
+		{"json", `print(json.decode("[1, 2"), json.decode("{\"a\" 1}"), json.decode("[] x"), json.decode("null"))`, "nil nil nil nil"},
+		{"json", `v = {"a": [1, "two", {"x": nil}]}  print(json.decode(json.encode(v)) == v)`, "true"},
+		{"json", `print(json.encode([1.5, 2.0, 1e100]), json.decode("[1.5, -2e3, 10]"))`, "[1.5,2.0,1e+100] [1.5, -2000.0, 10]"},
+
+		{"argparse", `o = argparse.parse(["-v", "-n", "5", "x", "--name=bob", "--", "-y"], {"v": false, "n": 1, "name": ""})
+print(o)`, `{"args": ["x", "-y"], "n": 5, "name": "bob", "v": true}`},
+		{"argparse", `o = argparse.parse(["-q"], {"v": false})`, "error: unknown option -q\noptions:\n  -v\nexit(2)"},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			source, ok := std.Source(test.module)
+			if !ok {
+				t.Fatalf("module %q not found", test.module)
+			}
+			stdout := &bytes.Buffer{}
+			config := &interpreter.Config{
+				Stdout: stdout,
+				Exit: func(n int) {
+					fmt.Fprintf(stdout, "exit(%d)", n)
+					panic(stdout)
+				},
+			}
+			interp := interpreter.New(config)
+			for _, src := range []string{string(source), test.source} {
+				prog, err := parser.ParseProgram([]byte(src))
+				if err != nil {
+					t.Fatalf("%s", err)
+				}
+				err = execute(interp, prog)
+				if err != nil {
+					t.Fatalf("%s", err)
+				}
+			}
+			output := strings.TrimRight(stdout.String(), "\n")
+			if output != test.output {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.output, output)
+			}
+		})
+	}
+}
+
+// Execute prog, stopping without an error if it calls exit()
+func execute(interp *interpreter.Interpreter, prog *parser.Program) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*bytes.Buffer); !ok {
+				panic(r)
+			}
+		}
+	}()
+	return interp.Execute(prog)
+}
+
+func TestModuleNames(t *testing.T) {
+	names := strings.Join(std.Modules(), " ")
+	if names != "argparse json lists strings" {
+		t.Fatalf("unexpected module names %q", names)
+	}
+	if _, ok := std.Source("nope"); ok {
+		t.Fatalf("expected module nope not to be found")
+	}
+}
@@ -0,0 +1,70 @@
+// String helpers. Load with "-lib std/strings", then call functions on the
+// strings map, for example strings.trim("  hi  ").
+
+strings = {
+    // Return true if s starts with prefix
+    "has_prefix": func(s, prefix) {
+        return len(s) >= len(prefix) and slice(s, 0, len(prefix)) == prefix
+    },
+
+    // Return true if s ends with suffix
+    "has_suffix": func(s, suffix) {
+        return len(s) >= len(suffix) and slice(s, len(s)-len(suffix), len(s)) == suffix
+    },
+
+    // Return s with leading and trailing whitespace removed
+    "trim": func(s) {
+        start = 0
+        while start < len(s) and s[start] in " \t\r\n" {
+            start = start + 1
+        }
+        end = len(s)
+        while end > start and s[end-1] in " \t\r\n" {
+            end = end - 1
+        }
+        return slice(s, start, end)
+    },
+
+    // Return s repeated n times
+    "repeat": func(s, n) {
+        parts = []
+        for i in range(n) {
+            append(parts, s)
+        }
+        return join(parts, "")
+    },
+
+    // Return s with all occurrences of old replaced by new
+    "replace": func(s, old, new) {
+        return join(split(s, old), new)
+    },
+
+    // Return s padded on the left with spaces to at least width bytes
+    "pad_left": func(s, width) {
+        if len(s) >= width {
+            return s
+        }
+        return strings.repeat(" ", width-len(s)) + s
+    },
+
+    // Return s padded on the right with spaces to at least width bytes
+    "pad_right": func(s, width) {
+        if len(s) >= width {
+            return s
+        }
+        return s + strings.repeat(" ", width-len(s))
+    },
+
+    // Return true if s is non-empty and contains only the digits 0-9
+    "is_digits": func(s) {
+        if s == "" {
+            return false
+        }
+        for i in range(len(s)) {
+            if not (s[i] in "0123456789") {
+                return false
+            }
+        }
+        return true
+    },
+}
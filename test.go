@@ -0,0 +1,142 @@
+// "littlelang test" command: run the tests in *_test.ll files
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+)
+
+// Run the test subcommand with the given args and return the exit status
+func testCommand(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "show each test that passes, not just failures")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: littlelang test [-v] dir_or_filename...\n\n")
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil || flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var filenames []string
+	for _, arg := range flags.Args() {
+		found, err := findTestFiles(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error finding tests: %v\n", err)
+			return exitError
+		}
+		filenames = append(filenames, found...)
+	}
+	passed, failed := 0, 0
+	for _, filename := range filenames {
+		filePassed, fileFailed := runTestFile(filename, *verbose)
+		passed += filePassed
+		failed += fileFailed
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return exitError
+	}
+	return 0
+}
+
+// Return the *_test.ll files in the directory tree at path, in lexical
+// order, or just path if it's a file
+func findTestFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var filenames []string
+	err = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(filename, "_test.ll") {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	})
+	return filenames, err
+}
+
+// Run the tests in the named file and return the number that passed and
+// failed. The file is run first, then each top-level function whose name
+// starts with "test_" is called in turn, and a test fails if it raises an
+// error (for example, from assert). A file without test functions is a
+// single test that passes if the file runs without error.
+func runTestFile(filename string, verbose bool) (passed, failed int) {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s\nerror reading %q\n", colorize("FAIL", colorRed), filename, filename)
+		return 0, 1
+	}
+	sourceFiles[filename] = source
+	prog, err := parser.ParseFile(filename, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s\n", colorize("FAIL", colorRed), filename)
+		showError(source, err)
+		return 0, 1
+	}
+	var tests []string
+	for _, s := range prog.Statements {
+		def, ok := s.(*parser.FunctionDefinition)
+		if ok && def.Struct == "" && strings.HasPrefix(def.Name, "test_") {
+			tests = append(tests, def.Name)
+		}
+	}
+
+	// Let test files include files in their own directory, and don't let
+	// a call to exit() stop the test run
+	config := &interpreter.Config{
+		IncludePath: []string{filepath.Dir(filename)},
+		ReturnExit:  true,
+	}
+	interp := interpreter.New(config)
+	err = interp.Execute(prog)
+	if err == nil {
+		for _, name := range tests {
+			call, _ := parser.ParseExpression([]byte(name + "()"))
+			_, err := interp.Evaluate(call)
+			if err != nil {
+				// Skip the call stack frame for the call above, as its
+				// position isn't in the test file
+				fmt.Fprintf(os.Stderr, "%s %s: %s\n", colorize("FAIL", colorRed), filename, name)
+				showErrorSkip(source, err, 1)
+				failed++
+				continue
+			}
+			if verbose {
+				fmt.Printf("ok   %s: %s\n", filename, name)
+			}
+			passed++
+		}
+	}
+	if stopErr := interp.Stop(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s\n", colorize("FAIL", colorRed), filename)
+		showError(source, err)
+		return passed, failed + 1
+	}
+	if len(tests) == 0 {
+		if verbose {
+			fmt.Printf("ok   %s\n", filename)
+		}
+		passed++
+	}
+	return passed, failed
+}
@@ -1,7 +1,9 @@
 // Package tokenizer turns a littlelang source string into a stream of tokens.
 //
 // To use the tokenizer, create a new tokenizer with NewTokenizer(source) and
-// then call Next() until the token type is EOF or ILLEGAL.
+// then call Next() until the token type is EOF or ILLEGAL. Comments are
+// skipped like whitespace, unless you call EmitComments() first to have
+// them returned as COMMENT tokens (for tools like formatters).
 //
 // This package can be imported using dot syntax, because the exported names
 // are more or less unique:
@@ -52,45 +54,63 @@ const (
 	GTE
 	LTE
 	NOTEQUAL
+	OPTDOT
+	OPTLBRACKET
 
 	// Three-character tokens
 	ELLIPSIS
 
 	// Keywords
 	AND
+	CATCH
 	ELSE
 	FALSE
 	FOR
 	FUNC
 	IF
 	IN
+	MATCH
 	NIL
 	NOT
 	OR
+	OUTER
 	RETURN
+	STRUCT
 	TRUE
+	TRY
 	WHILE
+	YIELD
 
 	// Literals and identifiers
+	FLOAT
 	INT
 	NAME
 	STR
+
+	// Comments (only returned after EmitComments is called)
+	COMMENT
 )
 
 var keywordTokens = map[string]Token{
 	"and":    AND,
+	"catch":  CATCH,
 	"else":   ELSE,
 	"false":  FALSE,
 	"for":    FOR,
 	"func":   FUNC,
 	"if":     IF,
 	"in":     IN,
+	"match":  MATCH,
 	"nil":    NIL,
 	"not":    NOT,
 	"or":     OR,
+	"outer":  OUTER,
 	"return": RETURN,
+	"struct": STRUCT,
 	"true":   TRUE,
+	"try":    TRY,
 	"while":  WHILE,
+	"yield":  YIELD,
 }
 
 var tokenNames = map[Token]string{
@@ -115,40 +135,62 @@ var tokenNames = map[Token]string{
 	RPAREN:   ")",
 	TIMES:    "*",
 
-	EQUAL:    "==",
-	GTE:      ">=",
-	LTE:      "<=",
-	NOTEQUAL: "!=",
+	EQUAL:       "==",
+	GTE:         ">=",
+	LTE:         "<=",
+	NOTEQUAL:    "!=",
+	OPTDOT:      "?.",
+	OPTLBRACKET: "?[",
 
 	ELLIPSIS: "...",
 
 	AND:    "and",
+	CATCH:  "catch",
 	ELSE:   "else",
 	FALSE:  "false",
 	FOR:    "for",
 	FUNC:   "func",
 	IF:     "if",
 	IN:     "in",
+	MATCH:  "match",
 	NIL:    "nil",
 	NOT:    "not",
 	OR:     "or",
+	OUTER:  "outer",
 	RETURN: "return",
+	STRUCT: "struct",
 	TRUE:   "true",
+	TRY:    "try",
 	WHILE:  "while",
+	YIELD:  "yield",
+
+	FLOAT: "float",
+	INT:   "int",
+	NAME:  "name",
+	STR:   "str",
 
-	INT:  "int",
-	NAME: "name",
-	STR:  "str",
+	COMMENT: "comment",
 }
 
 func (t Token) String() string {
 	return tokenNames[t]
 }
 
-// Position stores the line and column a token starts at
+// Position stores the line and column a token starts at, and the name of
+// the file it's in (empty if the tokenizer wasn't given a filename)
 type Position struct {
-	Line   int
-	Column int
+	Line     int
+	Column   int
+	Filename string
+}
+
+// String returns the position as "line:column", or as
+// "filename:line:column" if it has a filename.
+func (p Position) String() string {
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // Tokenizer parses input source code to a stream of tokens. Use
@@ -157,10 +199,14 @@ type Position struct {
 type Tokenizer struct {
 	input    []byte
 	offset   int
+	chOffset int
 	ch       rune
 	errorMsg string
 	pos      Position
 	nextPos  Position
+	start    int
+	end      int
+	comments bool
 }
 
 // NewTokenizer returns a new tokenizer that works off the given input.
@@ -170,11 +216,32 @@ func NewTokenizer(input []byte) *Tokenizer {
 	t.nextPos.Line = 1
 	t.nextPos.Column = 1
 	t.next()
+	if t.ch == '#' && t.offset < len(t.input) && t.input[t.offset] == '!' {
+		// Skip "#!" line at start of input so scripts can be executable
+		for t.ch != '\n' && t.ch >= 0 {
+			t.next()
+		}
+	}
 	return t
 }
 
+// SetFilename sets the filename of the positions Next() returns, so that
+// errors can say which file they're in. Call it before the first call to
+// Next().
+func (t *Tokenizer) SetFilename(filename string) {
+	t.pos.Filename = filename
+	t.nextPos.Filename = filename
+}
+
+// EmitComments makes Next() return a COMMENT token for each "//" comment
+// instead of skipping it. Call it before the first call to Next().
+func (t *Tokenizer) EmitComments() {
+	t.comments = true
+}
+
 func (t *Tokenizer) next() {
 	t.pos = t.nextPos
+	t.chOffset = t.offset
 	ch, size := utf8.DecodeRune(t.input[t.offset:])
 	if size == 0 {
 		t.ch = -1
@@ -200,7 +267,7 @@ func (t *Tokenizer) skipWhitespaceAndComments() {
 		for t.ch == ' ' || t.ch == '\t' || t.ch == '\r' || t.ch == '\n' {
 			t.next()
 		}
-		if !(t.ch == '/' && t.offset < len(t.input) && t.input[t.offset] == '/') {
+		if !(t.ch == '/' && t.offset < len(t.input) && t.input[t.offset] == '/') || t.comments {
 			break
 		}
 		// Skip //-prefixed comment (to end of line or end of input)
@@ -213,16 +280,53 @@ func (t *Tokenizer) skipWhitespaceAndComments() {
 	}
 }
 
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// Return the byte n bytes after the current character, or 0 if that's past
+// the end of the input
+func (t *Tokenizer) peek(n int) byte {
+	if t.offset+n >= len(t.input) {
+		return 0
+	}
+	return t.input[t.offset+n]
+}
+
 func isNameStart(ch rune) bool {
 	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
 }
 
 // Next() returns the position, token type, and token value of the next token
-// in the source. For ordinary tokens, the token value is empty. For INT,
-// NAME, and STR tokens, it's the number or string value. For an ILLEGAL
-// token, it's the error message.
+// in the source. For ordinary tokens, the token value is empty. For FLOAT,
+// INT, NAME, and STR tokens, it's the number or string value. For a COMMENT
+// token, it's the text after the "//" up to the end of the line. For an
+// ILLEGAL token, it's the error message.
 func (t *Tokenizer) Next() (Position, Token, string) {
 	t.skipWhitespaceAndComments()
+	t.start = t.chOffset
+	pos, token, value := t.token()
+	t.end = t.chOffset
+	return pos, token, value
+}
+
+// Lexeme returns the raw source text of the token most recently returned by
+// Next(), exactly as it appears in the input (for example "==" for EQUAL, or
+// the quoted and escaped string for a STR token). The length of the token in
+// bytes is simply the length of the returned string. For EOF, the lexeme is
+// empty.
+func (t *Tokenizer) Lexeme() string {
+	return string(t.input[t.start:t.end])
+}
+
+// Offset returns the byte offset in the input of the start of the token most
+// recently returned by Next(). Together with Lexeme(), this allows tools like
+// formatters and highlighters to reconstruct the input precisely.
+func (t *Tokenizer) Offset() int {
+	return t.start
+}
+
+func (t *Tokenizer) token() (Position, Token, string) {
 	if t.ch < 0 {
 		if t.errorMsg != "" {
 			return t.pos, ILLEGAL, t.errorMsg
@@ -259,7 +363,18 @@ func (t *Tokenizer) Next() (Position, Token, string) {
 	case ',':
 		token = COMMA
 	case '/':
-		token = DIVIDE
+		if t.ch == '/' {
+			// Comment (only reached if EmitComments was called)
+			t.next()
+			start := t.chOffset
+			for t.ch != '\n' && t.ch >= 0 {
+				t.next()
+			}
+			token = COMMENT
+			value = string(t.input[start:t.chOffset])
+		} else {
+			token = DIVIDE
+		}
 	case '{':
 		token = LBRACE
 	case '[':
@@ -296,6 +411,18 @@ func (t *Tokenizer) Next() (Position, Token, string) {
 			token = ILLEGAL
 			value = fmt.Sprintf("expected != instead of !%c", t.ch)
 		}
+	case '?':
+		switch t.ch {
+		case '.':
+			t.next()
+			token = OPTDOT
+		case '[':
+			t.next()
+			token = OPTLBRACKET
+		default:
+			token = ILLEGAL
+			value = fmt.Sprintf("expected ?. or ?[ instead of ?%c", t.ch)
+		}
 	case '<':
 		if t.ch == '=' {
 			t.next()
@@ -330,16 +457,64 @@ func (t *Tokenizer) Next() (Position, Token, string) {
 			t.next()
 		}
 		token = INT
+		// A float has a fractional part, an exponent, or both (the
+		// lookahead means "1..." is still an int followed by ellipsis)
+		if t.ch == '.' && isDigit(t.peek(0)) {
+			runes = append(runes, t.ch)
+			t.next()
+			for t.ch >= '0' && t.ch <= '9' {
+				runes = append(runes, t.ch)
+				t.next()
+			}
+			token = FLOAT
+		}
+		if (t.ch == 'e' || t.ch == 'E') &&
+			(isDigit(t.peek(0)) || (t.peek(0) == '+' || t.peek(0) == '-') && isDigit(t.peek(1))) {
+			runes = append(runes, t.ch)
+			t.next()
+			if t.ch == '+' || t.ch == '-' {
+				runes = append(runes, t.ch)
+				t.next()
+			}
+			for t.ch >= '0' && t.ch <= '9' {
+				runes = append(runes, t.ch)
+				t.next()
+			}
+			token = FLOAT
+		}
 		value = string(runes)
 
 	case '"':
+		// A triple-quoted string may contain newlines (but carriage
+		// returns are removed, as for raw strings)
+		triple := false
+		if t.ch == '"' && t.peek(0) == '"' {
+			t.next()
+			t.next()
+			triple = true
+		}
 		runes := []rune{}
-		for t.ch != '"' {
+		for {
+			if triple && t.ch == '"' && t.peek(0) == '"' && t.peek(1) == '"' {
+				t.next()
+				t.next()
+				break
+			}
+			if !triple && t.ch == '"' {
+				break
+			}
 			c := t.ch
 			if c < 0 {
+				if triple {
+					return pos, ILLEGAL, `didn't find end """ in string`
+				}
 				return pos, ILLEGAL, "didn't find end quote in string"
 			}
-			if c == '\r' || c == '\n' {
+			if c == '\r' && triple {
+				t.next()
+				continue
+			}
+			if (c == '\r' || c == '\n') && !triple {
 				return pos, ILLEGAL, "can't have newline in string"
 			}
 			if c == '\\' {
@@ -364,6 +539,23 @@ func (t *Tokenizer) Next() (Position, Token, string) {
 		token = STR
 		value = string(runes)
 
+	case '`':
+		// Raw string: no escapes, and may contain newlines (carriage
+		// returns are removed, like in Go)
+		runes := []rune{}
+		for t.ch != '`' {
+			if t.ch < 0 {
+				return pos, ILLEGAL, "didn't find end backtick in raw string"
+			}
+			if t.ch != '\r' {
+				runes = append(runes, t.ch)
+			}
+			t.next()
+		}
+		t.next()
+		token = STR
+		value = string(runes)
+
 	default:
 		token = ILLEGAL
 		value = fmt.Sprintf("unexpected %c", ch)
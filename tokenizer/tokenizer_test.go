@@ -105,6 +105,22 @@ func TestAll(t *testing.T) {
 		{" \"foo", []Info{
 			{1, 2, ILLEGAL, "didn't find end quote in string"},
 		}},
+		{"`a\\b\"c` `x\r\ny` ``", []Info{
+			{1, 1, STR, `a\b"c`},
+			{1, 9, STR, "x\ny"},
+			{2, 4, STR, ""},
+		}},
+		{"`foo", []Info{
+			{1, 1, ILLEGAL, "didn't find end backtick in raw string"},
+		}},
+		{"\"\"\"a\r\n\"b\\t\"\"\" \"\" \"\"\"\"\"\"", []Info{
+			{1, 1, STR, "a\n\"b\t"},
+			{2, 9, STR, ""},
+			{2, 12, STR, ""},
+		}},
+		{"\"\"\"foo\n\"\"", []Info{
+			{1, 1, ILLEGAL, `didn't find end """ in string`},
+		}},
 		{"\x80", []Info{
 			{1, 1, ILLEGAL, "invalid UTF-8 byte 0x80"},
 		}},
@@ -119,6 +135,17 @@ func TestAll(t *testing.T) {
 			{1, 21, INT, "0"},
 			{1, 22, NAME, "x321"},
 		}},
+		{"1.5 0.25 1e9 2.5E-3 1e+2 1. 1...", []Info{
+			{1, 1, FLOAT, "1.5"},
+			{1, 5, FLOAT, "0.25"},
+			{1, 10, FLOAT, "1e9"},
+			{1, 14, FLOAT, "2.5E-3"},
+			{1, 21, FLOAT, "1e+2"},
+			{1, 26, INT, "1"},
+			{1, 27, DOT, ""},
+			{1, 29, INT, "1"},
+			{1, 30, ELLIPSIS, ""},
+		}},
 		{`"foo" "'" "\"" "x\"y" "\t\r\n" "\\" "\z"`, []Info{
 			{1, 1, STR, "foo"},
 			{1, 7, STR, `'`},
@@ -136,6 +163,17 @@ func TestAll(t *testing.T) {
 			{1, 3, PLUS, ""},
 			{1, 5, INT, "2"},
 		}},
+		{"#!/usr/bin/env littlelang\nprint(1)", []Info{
+			{2, 1, NAME, "print"},
+			{2, 6, LPAREN, ""},
+			{2, 7, INT, "1"},
+			{2, 8, RPAREN, ""},
+		}},
+		{"#!", []Info{}},
+		{"1\n#!", []Info{
+			{1, 1, INT, "1"},
+			{2, 1, ILLEGAL, "unexpected #"},
+		}},
 		{"func() {\n    return a+b\n}", []Info{
 			{1, 1, FUNC, ""},
 			{1, 5, LPAREN, ""},
@@ -173,6 +211,13 @@ func TestAll(t *testing.T) {
 			{1, 42, RETURN, ""},
 			{1, 49, TRUE, ""},
 		}},
+		{"try catch yield match struct", []Info{
+			{1, 1, TRY, ""},
+			{1, 5, CATCH, ""},
+			{1, 11, YIELD, ""},
+			{1, 17, MATCH, ""},
+			{1, 23, STRUCT, ""},
+		}},
 		{"= == != < <= > >= !!", []Info{
 			{1, 1, ASSIGN, ""},
 			{1, 3, EQUAL, ""},
@@ -183,6 +228,15 @@ func TestAll(t *testing.T) {
 			{1, 16, GTE, ""},
 			{1, 19, ILLEGAL, "expected != instead of !!"},
 		}},
+		{"a?.b?[1] ?x", []Info{
+			{1, 1, NAME, "a"},
+			{1, 2, OPTDOT, ""},
+			{1, 4, NAME, "b"},
+			{1, 5, OPTLBRACKET, ""},
+			{1, 7, INT, "1"},
+			{1, 8, RBRACKET, ""},
+			{1, 10, ILLEGAL, "expected ?. or ?[ instead of ?x"},
+		}},
 		{"+-*/% ()[]{}:, . ... .... @", []Info{
 			{1, 1, PLUS, ""},
 			{1, 2, MINUS, ""},
@@ -217,6 +271,93 @@ func TestAll(t *testing.T) {
 	}
 }
 
+func TestLexeme(t *testing.T) {
+	tests := []struct {
+		input   string
+		lexemes []string
+	}{
+		{"", []string{}},
+		{"a == b", []string{"a", "==", "b"}},
+		{"print(1234, \"x\\ty\")  // done", []string{"print", "(", "1234", ",", "\"x\\ty\"", ")"}},
+		{"f(a...) != not\n\t{}", []string{"f", "(", "a", "...", ")", "!=", "not", "{", "}"}},
+		{"“x” + 1", []string{"“"}},
+		{"\"abc", []string{"\"abc"}},
+	}
+	for _, test := range tests {
+		k := NewTokenizer([]byte(test.input))
+		lexemes := []string{}
+		reconstructed := ""
+		last := 0
+		for {
+			_, token, _ := k.Next()
+			lexeme := k.Lexeme()
+			if token == EOF {
+				if lexeme != "" {
+					t.Errorf("%q: expected empty EOF lexeme, got %q", test.input, lexeme)
+				}
+				reconstructed += test.input[last:]
+				break
+			}
+			lexemes = append(lexemes, lexeme)
+			reconstructed += test.input[last:k.Offset()] + lexeme
+			last = k.Offset() + len(lexeme)
+			if token == ILLEGAL {
+				reconstructed += test.input[last:]
+				break
+			}
+		}
+		if strings.Join(lexemes, " ") != strings.Join(test.lexemes, " ") {
+			t.Errorf("%q: expected lexemes %q, got %q", test.input, test.lexemes, lexemes)
+		}
+		if reconstructed != test.input {
+			t.Errorf("%q: reconstructed input as %q", test.input, reconstructed)
+		}
+	}
+}
+
+func TestEmitComments(t *testing.T) {
+	tests := []struct {
+		input  string
+		output []Info
+	}{
+		{"// hi", []Info{{1, 1, COMMENT, " hi"}}},
+		{"//", []Info{{1, 1, COMMENT, ""}}},
+		{"x = 1 // one\n//two\n\ny/2", []Info{
+			{1, 1, NAME, "x"},
+			{1, 3, ASSIGN, ""},
+			{1, 5, INT, "1"},
+			{1, 7, COMMENT, " one"},
+			{2, 1, COMMENT, "two"},
+			{4, 1, NAME, "y"},
+			{4, 2, DIVIDE, ""},
+			{4, 3, INT, "2"},
+		}},
+		{"\"a // b\" // c", []Info{
+			{1, 1, STR, "a // b"},
+			{1, 10, COMMENT, " c"},
+		}},
+		{"#!/usr/bin/env littlelang\n// c", []Info{{2, 1, COMMENT, " c"}}},
+	}
+	for _, test := range tests {
+		k := NewTokenizer([]byte(test.input))
+		k.EmitComments()
+		output := []Info{}
+		for {
+			pos, token, value := k.Next()
+			if token == EOF {
+				break
+			}
+			output = append(output, Info{pos.Line, pos.Column, token, value})
+			if token == COMMENT && k.Lexeme() != "//"+value {
+				t.Errorf("%q: expected comment lexeme %q, got %q", test.input, "//"+value, k.Lexeme())
+			}
+		}
+		if msg := infosEqual(output, test.output); msg != "" {
+			t.Errorf("%q: %s", test.input, msg)
+		}
+	}
+}
+
 func TestString(t *testing.T) {
 	output := tokenStrings(`
 and else false for func if in nil not or return true while
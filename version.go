@@ -0,0 +1,40 @@
+// Version information for the littlelang command
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version of littlelang, overridden at release build time using:
+//
+//	go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// Print version, commit, and Go runtime information
+func showVersion() {
+	v := version
+	commit := "unknown"
+	modified := false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+	}
+	if modified {
+		commit += " (modified)"
+	}
+	fmt.Printf("littlelang %s\n", v)
+	fmt.Printf("commit: %s\n", commit)
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,98 @@
+// "littlelang vet" command: report suspicious constructs in source files
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/benhoyt/littlelang/vet"
+)
+
+// Diagnostic as output by "littlelang vet -json"
+type vetDiagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Run the vet subcommand with the given args and return the exit status:
+// 0 if no problems were found, exitError if some were
+func vetCommand(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ContinueOnError)
+	rulesFlag := flags.String("rules", "", "comma-separated `list` of rules to run (default all)")
+	disable := flags.String("disable", "", "comma-separated `list` of rules not to run")
+	jsonOutput := flags.Bool("json", false, "print diagnostics as a JSON array")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: littlelang vet [-rules list] [-disable list] [-json] source_filename...\n\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nrules:\n")
+		for _, rule := range vet.Rules {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", rule.Name, rule.Doc)
+		}
+	}
+	if flags.Parse(args) != nil || flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var rules []string
+	if *rulesFlag != "" {
+		rules = strings.Split(*rulesFlag, ",")
+	} else {
+		for _, rule := range vet.Rules {
+			rules = append(rules, rule.Name)
+		}
+	}
+	if *disable != "" {
+		disabled := make(map[string]bool)
+		for _, name := range strings.Split(*disable, ",") {
+			disabled[name] = true
+		}
+		var enabled []string
+		for _, name := range rules {
+			if !disabled[name] {
+				enabled = append(enabled, name)
+			}
+		}
+		rules = enabled
+		if rules == nil {
+			rules = []string{}
+		}
+	}
+
+	all := []vetDiagnostic{}
+	for _, filename := range flags.Args() {
+		_, prog := parseFile(filename)
+		diagnostics, err := vet.Check(prog, rules)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		for _, d := range diagnostics {
+			all = append(all, vetDiagnostic{filename, d.Position.Line, d.Position.Column, d.Rule, d.Message})
+		}
+	}
+
+	if *jsonOutput {
+		b, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		fmt.Printf("%s\n", b)
+	} else {
+		for _, d := range all {
+			fmt.Printf("%s:%d:%d: %s (%s)\n", d.File, d.Line, d.Column, d.Message, d.Rule)
+		}
+	}
+	if len(all) > 0 {
+		return exitError
+	}
+	return 0
+}
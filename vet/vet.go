@@ -0,0 +1,303 @@
+// Package vet reports suspicious constructs in littlelang programs.
+//
+// Call Check(prog, rules) with a program from parser.ParseProgram() to get
+// a list of diagnostics. Each diagnostic comes from one of the checks in
+// Rules, and the checks can be enabled individually.
+//
+package vet
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+// Diagnostic is a single problem found in a program.
+type Diagnostic struct {
+	Position Position
+	Rule     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", d.Position.Line, d.Position.Column, d.Message, d.Rule)
+}
+
+// Rule is a single check that Check can run.
+type Rule struct {
+	Name string
+	Doc  string
+	run  func(c *checker, prog *parser.Program)
+}
+
+// Rules is the list of all checks, in the order they're run.
+var Rules = []Rule{
+	{"unused", "variables assigned in a function but never used", checkUnused},
+	{"shadow", "variables, functions, and parameters that shadow a builtin", checkShadow},
+	{"funcequal", "== or != comparisons of functions", checkFuncEqual},
+	{"constcond", "if and while conditions that are constant", checkConstCond},
+	{"unreachable", "statements after a return", checkUnreachable},
+}
+
+type checker struct {
+	diagnostics []Diagnostic
+	rule        string
+}
+
+func (c *checker) report(pos Position, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{pos, c.rule, fmt.Sprintf(format, args...)})
+}
+
+// Check runs the named rules on prog (all rules if names is nil) and
+// returns the diagnostics found, sorted by position. It returns an error if
+// a rule name is invalid.
+func Check(prog *parser.Program, names []string) ([]Diagnostic, error) {
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		found := false
+		for _, rule := range Rules {
+			if rule.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown vet rule %q", name)
+		}
+		enabled[name] = true
+	}
+	c := &checker{}
+	for _, rule := range Rules {
+		if names != nil && !enabled[rule.Name] {
+			continue
+		}
+		c.rule = rule.Name
+		rule.run(c, prog)
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		pi, pj := c.diagnostics[i].Position, c.diagnostics[j].Position
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+	return c.diagnostics, nil
+}
+
+// Report local variables that are assigned in a function but never read
+// in it or any nested function
+func checkUnused(c *checker, prog *parser.Program) {
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.FunctionDefinition:
+			checkUnusedInFunction(c, n.Body)
+		case *parser.FunctionExpression:
+			checkUnusedInFunction(c, n.Body)
+		}
+		return true
+	})
+}
+
+func checkUnusedInFunction(c *checker, body parser.Block) {
+	// Find local assignments (not including nested functions' locals)
+	type assignment struct {
+		name string
+		pos  Position
+	}
+	var assigned []assignment
+	parser.Walk(body, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.Assign:
+			if v, ok := n.Target.(*parser.Variable); ok {
+				assigned = append(assigned, assignment{v.Name, v.Position()})
+			}
+		case *parser.For:
+			assigned = append(assigned, assignment{n.Name, n.Position()})
+			if n.ValueName != "" {
+				assigned = append(assigned, assignment{n.ValueName, n.Position()})
+			}
+		case *parser.FunctionDefinition:
+			if n.Struct == "" {
+				assigned = append(assigned, assignment{n.Name, n.Position()})
+			}
+			return false
+		case *parser.StructDefinition:
+			assigned = append(assigned, assignment{n.Name, n.Position()})
+		case *parser.FunctionExpression:
+			return false
+		}
+		return true
+	})
+
+	// Find all variable reads, including in nested functions
+	targets := make(map[*parser.Variable]bool)
+	parser.Walk(body, func(node parser.Node) bool {
+		if a, ok := node.(*parser.Assign); ok {
+			if v, ok := a.Target.(*parser.Variable); ok {
+				targets[v] = true
+			}
+		}
+		return true
+	})
+	used := make(map[string]bool)
+	parser.Walk(body, func(node parser.Node) bool {
+		if v, ok := node.(*parser.Variable); ok && !targets[v] {
+			used[v.Name] = true
+		}
+		return true
+	})
+
+	reported := make(map[string]bool)
+	for _, a := range assigned {
+		if !used[a.name] && !reported[a.name] {
+			c.report(a.pos, "%s is assigned but never used", a.name)
+			reported[a.name] = true
+		}
+	}
+}
+
+// Report names defined or assigned that shadow a builtin function
+func checkShadow(c *checker, prog *parser.Program) {
+	builtins := make(map[string]bool)
+	for _, name := range interpreter.Builtins() {
+		builtins[name] = true
+	}
+	checkParams := func(pos Position, params []string) {
+		for _, param := range params {
+			if builtins[param] {
+				c.report(pos, "parameter %s shadows builtin %s()", param, param)
+			}
+		}
+	}
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.Assign:
+			if v, ok := n.Target.(*parser.Variable); ok && builtins[v.Name] {
+				c.report(v.Position(), "assignment to %s shadows builtin %s()", v.Name, v.Name)
+			}
+		case *parser.For:
+			for _, name := range []string{n.Name, n.ValueName} {
+				if builtins[name] {
+					c.report(n.Position(), "loop variable %s shadows builtin %s()", name, name)
+				}
+			}
+		case *parser.Try:
+			if builtins[n.ErrorName] {
+				c.report(n.Position(), "error name %s shadows builtin %s()", n.ErrorName, n.ErrorName)
+			}
+		case *parser.FunctionDefinition:
+			if n.Struct == "" && builtins[n.Name] {
+				c.report(n.Position(), "function %s shadows builtin %s()", n.Name, n.Name)
+			}
+			checkParams(n.Position(), n.Parameters)
+		case *parser.StructDefinition:
+			if builtins[n.Name] {
+				c.report(n.Position(), "struct %s shadows builtin %s()", n.Name, n.Name)
+			}
+		case *parser.FunctionExpression:
+			checkParams(n.Position(), n.Parameters)
+		}
+		return true
+	})
+}
+
+// Report == and != comparisons where one side is a function
+func checkFuncEqual(c *checker, prog *parser.Program) {
+	functions := make(map[string]bool)
+	for _, name := range interpreter.Builtins() {
+		functions[name] = true
+	}
+	for _, s := range prog.Statements {
+		if f, ok := s.(*parser.FunctionDefinition); ok && f.Struct == "" {
+			functions[f.Name] = true
+		}
+	}
+	isFunction := func(expr parser.Expression) bool {
+		switch e := expr.(type) {
+		case *parser.FunctionExpression:
+			return true
+		case *parser.Variable:
+			return functions[e.Name]
+		}
+		return false
+	}
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		if b, ok := node.(*parser.Binary); ok && (b.Operator == EQUAL || b.Operator == NOTEQUAL) {
+			if isFunction(b.Left) || isFunction(b.Right) {
+				c.report(b.Position(), "comparison of function with %s", b.Operator)
+			}
+		}
+		return true
+	})
+}
+
+// Report if and while conditions that don't depend on any variables (but
+// allow "while true", the usual way to write an infinite loop)
+func checkConstCond(c *checker, prog *parser.Program) {
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.If:
+			if isConstant(n.Condition) {
+				c.report(n.Condition.Position(), "if condition is constant")
+			}
+		case *parser.While:
+			if lit, ok := n.Condition.(*parser.Literal); ok && lit.Value == true {
+				break
+			}
+			if isConstant(n.Condition) {
+				c.report(n.Condition.Position(), "while condition is constant")
+			}
+		}
+		return true
+	})
+}
+
+func isConstant(expr parser.Expression) bool {
+	switch e := expr.(type) {
+	case *parser.Literal:
+		return true
+	case *parser.Unary:
+		return isConstant(e.Operand)
+	case *parser.Binary:
+		return isConstant(e.Left) && isConstant(e.Right)
+	}
+	return false
+}
+
+// Report the first statement after a return in each block
+func checkUnreachable(c *checker, prog *parser.Program) {
+	checkBlock := func(block parser.Block) {
+		for i, s := range block {
+			if _, ok := s.(*parser.Return); ok && i+1 < len(block) {
+				c.report(block[i+1].Position(), "unreachable code")
+				break
+			}
+		}
+	}
+	checkBlock(prog.Statements)
+	parser.Walk(prog.Statements, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.If:
+			checkBlock(n.Body)
+			checkBlock(n.Else)
+		case *parser.While:
+			checkBlock(n.Body)
+		case *parser.For:
+			checkBlock(n.Body)
+		case *parser.Try:
+			checkBlock(n.Body)
+			checkBlock(n.Catch)
+		case *parser.Match:
+			for _, c := range n.Cases {
+				checkBlock(c.Body)
+			}
+		case *parser.FunctionDefinition:
+			checkBlock(n.Body)
+		case *parser.FunctionExpression:
+			checkBlock(n.Body)
+		}
+		return true
+	})
+}
@@ -0,0 +1,89 @@
+// Test vet package
+
+package vet_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/benhoyt/littlelang/parser"
+	"github.com/benhoyt/littlelang/vet"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		source string
+		rules  []string
+		output string
+	}{
+		{`x = 1  print(x)`, nil, ``},
+
+		// unused
+		{`func f() { x = 1  y = 2  return y }`, nil, `1:12: x is assigned but never used (unused)`},
+		{`func f() { x = 1  x = x + 1 }`, nil, ``},
+		{`func f() { x = 1  return func() { return x } }`, nil, ``},
+		{`func f() { for i in range(3) { print(1) } }`, nil, `1:12: i is assigned but never used (unused)`},
+		{`func f(m) { for k, v in m { print(k) } }`, nil, `1:13: v is assigned but never used (unused)`},
+		{`func f() { func g() {} }`, nil, `1:12: g is assigned but never used (unused)`},
+		{`func f() { struct S { a }  func S.m(s) {} }`, nil, `1:12: S is assigned but never used (unused)`},
+		{`func f() { struct S { a }  func S.m(s) {}  return S(1) }`, nil, ``},
+		{`x = 1`, nil, ``},
+
+		// shadow
+		{`len = 3  print(len)`, nil, `1:1: assignment to len shadows builtin len() (shadow)`},
+		{`func print(x) {}`, nil, `1:1: function print shadows builtin print() (shadow)`},
+		{`struct set { a }`, nil, `1:1: struct set shadows builtin set() (shadow)`},
+		{`struct S { a }  func S.len(s) { return 0 }`, nil, ``},
+		{`func f(str) { return str }`, nil, `1:1: parameter str shadows builtin str() (shadow)`},
+		{`for type in [1] { print(type) }`, nil, `1:1: loop variable type shadows builtin type() (shadow)`},
+		{`for k, len in {} { print(k, len) }`, nil, `1:1: loop variable len shadows builtin len() (shadow)`},
+		{`try { print(1) } catch str { print(str) }`, nil, `1:1: error name str shadows builtin str() (shadow)`},
+
+		// funcequal
+		{`func f() {}  print(f == nil)`, nil, `1:22: comparison of function with == (funcequal)`},
+		{`x = 1  print(x != len)`, nil, `1:16: comparison of function with != (funcequal)`},
+		{`x = 1  print(x == 2)`, nil, ``},
+
+		// constcond
+		{`if true { print(1) }`, nil, `1:4: if condition is constant (constcond)`},
+		{`if not (1 == 2) { print(1) }`, nil, `1:4: if condition is constant (constcond)`},
+		{`while false { print(1) }`, nil, `1:7: while condition is constant (constcond)`},
+		{`while true { exit() }`, nil, ``},
+
+		// unreachable
+		{`func f() { return 1  print(2) }`, nil, `1:22: unreachable code (unreachable)`},
+		{`func f() { if true { return 1 } print(2) }`, []string{"unreachable"}, ``},
+		{`func f() { try { return 1  print(2) } catch e { return 2 } }`, nil, `1:28: unreachable code (unreachable)`},
+		{`func f(v) { match v { [x] { return x  print(2) } } }`, nil, `1:39: unreachable code (unreachable)`},
+
+		// rule selection
+		{`len = 3  if true { print(len) }`, []string{"constcond"}, `1:13: if condition is constant (constcond)`},
+		{`len = 3  if true { print(len) }`, []string{}, ``},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			prog, err := parser.ParseProgram([]byte(test.source))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			diagnostics, err := vet.Check(prog, test.rules)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			var lines []string
+			for _, d := range diagnostics {
+				lines = append(lines, d.String())
+			}
+			output := strings.Join(lines, "\n")
+			if output != test.output {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.output, output)
+			}
+		})
+	}
+
+	prog, _ := parser.ParseProgram([]byte(`x = 1`))
+	_, err := vet.Check(prog, []string{"bogus"})
+	if err == nil || err.Error() != `unknown vet rule "bogus"` {
+		t.Fatalf("expected unknown rule error, got %v", err)
+	}
+}
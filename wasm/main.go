@@ -0,0 +1,169 @@
+//go:build js && wasm
+
+// WebAssembly build of littlelang for embedding in a web page
+//
+// Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o littlelang.wasm ./wasm
+//
+// and load it using the wasm_exec.js support file that comes with Go. This
+// defines a global littlelang object with two functions:
+//
+//	littlelang.parse(source)
+//	    Returns {ast, error}, where ast is the parsed program as a string
+//	    and error is null or an error object (see below).
+//
+//	littlelang.run(source, options)
+//	    Runs the program and returns {output, exitStatus, error}, where
+//	    output is everything the program printed. Options is an optional
+//	    object with fields stdin (string), args (array of strings), and
+//	    timeout (milliseconds).
+//
+// Error objects have the fields kind ("parse", "type", "value", "name",
+// "runtime", "error", "assertion", or "timeout"), message, line, column, and
+// stack (an array of {function, line, column} call frames, outermost first).
+// Errors raised by the throw() builtin have kind "error", and those raised
+// by assert() have kind "assertion".
+//
+// All I/O goes through the interpreter config: there's no filesystem, so
+// read(filename), write(data, filename), appendfile(), and the other
+// filesystem builtins like exists() and listdir() return an error, and exit()
+// stops the program.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/benhoyt/littlelang/interpreter"
+	"github.com/benhoyt/littlelang/parser"
+	. "github.com/benhoyt/littlelang/tokenizer"
+)
+
+func main() {
+	js.Global().Set("littlelang", map[string]interface{}{
+		"parse": js.FuncOf(parse),
+		"run":   js.FuncOf(run),
+	})
+	// Keep running so the functions above can be called
+	select {}
+}
+
+func parse(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		err := jsError("runtime", "parse() requires a source argument", Position{}, nil)
+		return map[string]interface{}{"ast": nil, "error": err}
+	}
+	prog, err := parser.ParseProgram([]byte(args[0].String()))
+	if err != nil {
+		return map[string]interface{}{"ast": nil, "error": errorToJS(err)}
+	}
+	return map[string]interface{}{"ast": prog.String(), "error": nil}
+}
+
+func run(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		err := jsError("runtime", "run() requires a source argument", Position{}, nil)
+		return map[string]interface{}{"output": "", "exitStatus": 1, "error": err}
+	}
+	prog, err := parser.ParseProgram([]byte(args[0].String()))
+	if err != nil {
+		return map[string]interface{}{"output": "", "exitStatus": 1, "error": errorToJS(err)}
+	}
+
+	stdout := &bytes.Buffer{}
+	config := &interpreter.Config{
+		Stdin:      strings.NewReader(""),
+		Stdout:     stdout,
+		ReturnExit: true,
+		ReadFile:   func(string) ([]byte, error) { return nil, errors.New("no filesystem in the browser") },
+		WriteFile:  func(string, []byte) error { return errors.New("no filesystem in the browser") },
+		AppendFile: func(string, []byte) error { return errors.New("no filesystem in the browser") },
+		OpenFile:   func(string) (io.ReadCloser, error) { return nil, errors.New("no filesystem in the browser") },
+		Stat:       func(string) (os.FileInfo, error) { return nil, errors.New("no filesystem in the browser") },
+		ReadDir:    func(string) ([]os.FileInfo, error) { return nil, errors.New("no filesystem in the browser") },
+		Mkdir:      func(string) error { return errors.New("no filesystem in the browser") },
+		Remove:     func(string) error { return errors.New("no filesystem in the browser") },
+	}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		options := args[1]
+		if stdin := options.Get("stdin"); stdin.Type() == js.TypeString {
+			config.Stdin = strings.NewReader(stdin.String())
+		}
+		if argList := options.Get("args"); argList.Type() == js.TypeObject {
+			for i := 0; i < argList.Length(); i++ {
+				config.Args = append(config.Args, argList.Index(i).String())
+			}
+		}
+		if timeout := options.Get("timeout"); timeout.Type() == js.TypeNumber {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout.Float())*time.Millisecond)
+			defer cancel()
+			config.Context = ctx
+		}
+	}
+
+	status, err := execute(prog, config)
+	result := map[string]interface{}{"output": stdout.String(), "exitStatus": status, "error": nil}
+	if err != nil {
+		result["exitStatus"] = 1
+		result["error"] = errorToJS(err)
+	}
+	return result
+}
+
+// Execute prog, returning the exit status if it calls exit()
+func execute(prog *parser.Program, config *interpreter.Config) (int, error) {
+	_, err := interpreter.Execute(prog, config)
+	if e, ok := err.(interpreter.ExitError); ok {
+		return e.Status, nil
+	}
+	return 0, err
+}
+
+// Convert a parser or interpreter error to a JavaScript error object
+func errorToJS(err error) interface{} {
+	switch e := err.(type) {
+	case parser.Error:
+		return jsError("parse", e.Message, e.Position, nil)
+	case interpreter.TypeError:
+		return jsError("type", e.Message, e.Position(), e.Stack())
+	case interpreter.ValueError:
+		return jsError("value", e.Message, e.Position(), e.Stack())
+	case interpreter.NameError:
+		return jsError("name", e.Message, e.Position(), e.Stack())
+	case interpreter.RuntimeError:
+		return jsError("runtime", e.Message, e.Position(), e.Stack())
+	case interpreter.UserError:
+		return jsError("error", e.Message, e.Position(), e.Stack())
+	case interpreter.AssertionError:
+		return jsError("assertion", e.Message, e.Position(), e.Stack())
+	case interpreter.TimeoutError:
+		return jsError("timeout", e.Message, e.Position(), e.Stack())
+	default:
+		return jsError("runtime", err.Error(), Position{}, nil)
+	}
+}
+
+func jsError(kind, message string, pos Position, stack []interpreter.Frame) interface{} {
+	frames := make([]interface{}, len(stack))
+	for i, frame := range stack {
+		frames[i] = map[string]interface{}{
+			"function": frame.Function,
+			"line":     frame.Position.Line,
+			"column":   frame.Position.Column,
+		}
+	}
+	return map[string]interface{}{
+		"kind":    kind,
+		"message": message,
+		"line":    pos.Line,
+		"column":  pos.Column,
+		"stack":   frames,
+	}
+}
@@ -0,0 +1,178 @@
+// Watch mode for the littlelang command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// How often to check watched files for changes
+const watchInterval = 250 * time.Millisecond
+
+// Environment variable set for the child process that watch runs. Its
+// value is the name of a file that the child appends the name of each file
+// include() reads to, one per line, so that those are watched too. The
+// child ignores -watch when it's set, instead of watching files itself.
+const watchChildEnv = "LITTLELANG_WATCH_CHILD"
+
+// Run littlelang with the given args (which shouldn't include -watch) in a
+// child process, and re-run it whenever one of the given files, or a file
+// the program has included, changes, clearing the screen first. If the
+// child is still running when a file changes, it's killed before being
+// restarted. Watching stops when interrupted, or when the child finishes
+// and there are no files to watch, and watch returns the exit status for
+// the command.
+func watch(files []string, args []string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error finding littlelang executable: %v\n", err)
+		os.Exit(exitError)
+	}
+	includesFile, err := ioutil.TempFile("", "littlelang_watch_")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating watch file: %v\n", err)
+		os.Exit(exitError)
+	}
+	includesFile.Close()
+	includes := includesFile.Name()
+	defer os.Remove(includes)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	files = append([]string{}, files...)
+	for {
+		fmt.Print("\033[H\033[2J") // move cursor to top left and clear screen
+		modTimes := getModTimes(files)
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), watchChildEnv+"="+includes)
+		done := make(chan error, 1)
+		err := cmd.Start()
+		if err != nil {
+			done <- err
+		} else {
+			go func() { done <- cmd.Wait() }()
+		}
+
+		for changed := false; !changed; {
+			select {
+			case err := <-done:
+				if err != nil {
+					fmt.Printf("[%v]\n", err)
+				}
+				files, modTimes = addIncludes(files, modTimes, includes)
+				if len(files) == 0 {
+					fmt.Println("[no files to watch]")
+					if cmd.ProcessState == nil {
+						return exitError
+					}
+					return cmd.ProcessState.ExitCode()
+				}
+				fmt.Printf("[waiting for changes to %s]\n", strings.Join(files, ", "))
+				done = nil
+			case <-interrupts:
+				if done != nil {
+					cmd.Process.Kill()
+					<-done
+				}
+				return 130 // same as the interrupt signal's default
+			case <-time.After(watchInterval):
+				files, modTimes = addIncludes(files, modTimes, includes)
+				changed = !modTimesEqual(getModTimes(files), modTimes)
+			}
+		}
+		if done != nil {
+			cmd.Process.Kill()
+			<-done
+		}
+	}
+}
+
+// Add the files listed in the named includes file that aren't already in
+// files, along with their current modification times, and return the new
+// files and times
+func addIncludes(files []string, modTimes []time.Time, includes string) ([]string, []time.Time) {
+	data, err := ioutil.ReadFile(includes)
+	if err != nil {
+		return files, modTimes
+	}
+	watched := make(map[string]bool, len(files))
+	for _, file := range files {
+		watched[file] = true
+	}
+	for _, file := range strings.Split(string(data), "\n") {
+		if file == "" || watched[file] {
+			continue
+		}
+		watched[file] = true
+		files = append(files, file)
+		modTimes = append(modTimes, getModTimes([]string{file})...)
+	}
+	return files, modTimes
+}
+
+// Return a Config.Included function that appends each included filename
+// to the named includes file, for the parent watch process to read
+func recordIncludes(includes string) func(filename string) {
+	return func(filename string) {
+		f, err := os.OpenFile(includes, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(f, filename)
+		f.Close()
+	}
+}
+
+// Return the modification time of each file (zero time if not found)
+func getModTimes(files []string) []time.Time {
+	times := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func modTimesEqual(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the command line args to run the child process with: the flags
+// that were set (other than -watch), rebuilt from the parsed flag set, then
+// "--" and the positional args. Rebuilding them, rather than removing -watch
+// from the raw args, means a flag's value can't be mistaken for the end of
+// the flags.
+func watchArgs(flags *flag.FlagSet) []string {
+	args := []string{}
+	flags.Visit(func(f *flag.Flag) {
+		switch value := f.Value.(type) {
+		case *stringList:
+			for _, s := range *value {
+				args = append(args, "-"+f.Name+"="+s)
+			}
+		default:
+			if f.Name != "watch" {
+				args = append(args, "-"+f.Name+"="+value.String())
+			}
+		}
+	})
+	args = append(args, "--")
+	return append(args, flags.Args()...)
+}